@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/viswals_task/core/services"
+	"github.com/viswals_task/internal/logger"
+	"github.com/viswals_task/pkg/cache"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/redis"
+	"go.uber.org/zap"
+)
+
+var (
+	DevEnvironment        = "dev"
+	defaultRedisTTLStr    = "60s"
+	defaultRedisTTLJitter = "0.1"
+	defaultRedisCodec     = "json"
+)
+
+// cachesync warms the cache with the most recently created and the most read users, then compares a sample of the
+// cached users with the database and fixes the stale entries. It runs once, or every interval until stopped.
+// With -invalidate every cached user is deleted first, e.g. after the users have been changed in bulk.
+func main() {
+	recent := flag.Int64("recent", 1000, "number of most recently created users to warm")
+	popular := flag.Int64("popular", 1000, "number of most read users to warm")
+	sample := flag.Int("sample", 500, "number of cached keys compared with the database, 0 disables reconciliation")
+	invalidate := flag.Bool("invalidate", false, "delete every cached user before the first warm-up")
+	interval := flag.Duration("interval", 0, "run again after the interval until stopped, 0 runs once")
+	flag.Parse()
+
+	log, err := logger.Init(os.Stdout, strings.ToLower(os.Getenv("ENVIRONMENT")) == DevEnvironment)
+	if err != nil {
+		fmt.Printf("can't initialise logger throws error : %v", err)
+		return
+	}
+
+	dbUrl, ok := os.LookupEnv("POSTGRES_CONNECTION_STRING")
+	if !ok {
+		log.Error("postgres connection string is not set, please provide environment variable POSTGRES_CONNECTION_STRING")
+		return
+	}
+
+	redisConn, err := redis.LoadConnection(os.Getenv)
+	if err != nil {
+		log.Error("redis connection is not set or invalid", zap.Error(err))
+		return
+	}
+
+	// warmed users must expire like the users cached by the consumers.
+	ttlStr, ok := os.LookupEnv("REDIS_TTL")
+	if !ok {
+		ttlStr = defaultRedisTTLStr
+	}
+
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		log.Error("error fetching redis TTL throws error", zap.Error(err), zap.String("ttl", ttlStr))
+		return
+	}
+
+	// the warmed users are written together, their expiries are spread.
+	ttlJitterStr, ok := os.LookupEnv("REDIS_TTL_JITTER")
+	if !ok {
+		ttlJitterStr = defaultRedisTTLJitter
+	}
+
+	ttlJitter, err := strconv.ParseFloat(ttlJitterStr, 64)
+	if err != nil {
+		log.Error("error fetching redis TTL jitter throws error", zap.Error(err), zap.String("ttl_jitter", ttlJitterStr))
+		return
+	}
+
+	// entries of every codec are read, switch to msgpack once every instance knows codecs.
+	codecName, ok := os.LookupEnv("REDIS_CODEC")
+	if !ok {
+		codecName = defaultRedisCodec
+	}
+
+	codec, err := redis.CodecByName(codecName)
+	if err != nil {
+		log.Error("error fetching redis codec throws error", zap.Error(err), zap.String("codec", codecName))
+		return
+	}
+
+	dataStore, err := database.New(dbUrl)
+	if err != nil {
+		log.Error("can't initialise database throws error", zap.Error(err))
+		return
+	}
+	defer dataStore.Close()
+
+	redisStore, err := redis.New(redisConn, redis.Config{
+		TTL:         ttl,
+		NegativeTTL: time.Second,
+		TTLJitter:   ttlJitter,
+		Codec:       codec,
+		KeyPrefix:   os.Getenv("REDIS_KEY_PREFIX"),
+	})
+	if err != nil {
+		log.Error("can't initialise redis throws error", zap.Error(err))
+		return
+	}
+	defer redisStore.Close()
+
+	// changes are announced so the consumers drop their local copies.
+	memStore, err := cache.New(redisStore, nil, redisStore, nil, log)
+	if err != nil {
+		log.Error("can't initialise cache throws error", zap.Error(err))
+		return
+	}
+
+	if *invalidate {
+		deleted, err := redisStore.DeleteByPrefix(context.Background(), "")
+		if err != nil {
+			log.Error("can't invalidate cached users throws error", zap.Error(err))
+			return
+		}
+
+		err = memStore.InvalidateAll(context.Background())
+		if err != nil {
+			log.Error("can't announce the invalidation throws error, the local copies expire with LOCAL_CACHE_TTL", zap.Error(err))
+			return
+		}
+		log.Info("cached users invalidated", zap.Int64("deleted", deleted))
+	}
+
+	cacheSync := services.NewCacheSync(dataStore, memStore, redisStore, log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		run(ctx, cacheSync, *recent, *popular, *sample, log)
+		if *interval <= 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func run(ctx context.Context, cacheSync *services.CacheSync, recent, popular int64, sample int, log *zap.Logger) {
+	warmup, err := cacheSync.Warm(ctx, recent, popular)
+	if err != nil {
+		log.Error("cache warm-up stopped", zap.Error(err), zap.Any("progress", warmup))
+	} else {
+		log.Info("cache warm-up completed", zap.Any("progress", warmup))
+	}
+
+	if sample <= 0 {
+		return
+	}
+
+	reconciliation, err := cacheSync.Reconcile(ctx, sample)
+	if err != nil {
+		log.Error("cache reconciliation stopped", zap.Error(err), zap.Any("progress", reconciliation))
+		return
+	}
+	log.Info("cache reconciliation completed", zap.Any("progress", reconciliation))
+}
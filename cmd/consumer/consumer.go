@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,9 +11,12 @@ import (
 	"time"
 
 	"github.com/viswals_task/controller"
+	"github.com/viswals_task/core/models"
 	"github.com/viswals_task/core/services"
+	"github.com/viswals_task/internal/authutils"
 	"github.com/viswals_task/internal/encryptionutils"
 	"github.com/viswals_task/internal/logger"
+	"github.com/viswals_task/pkg/cache"
 	"github.com/viswals_task/pkg/database"
 	"github.com/viswals_task/pkg/rabbitmq"
 	"github.com/viswals_task/pkg/redis"
@@ -22,7 +26,29 @@ import (
 var (
 	DevEnvironment     = "dev"
 	defaultRedisTTLStr = "60s"
-	defaultBufferSize  = "50"
+	// keep missing users cached shortly, they might be created soon.
+	defaultRedisNegativeTTLStr = "5s"
+	// spread the expiries of the users cached together, e.g. by the warm-up.
+	defaultRedisTTLJitter     = "0.1"
+	defaultRedisCodec         = "json"
+	defaultBufferSize         = "50"
+	defaultLocalCacheMaxBytes = "67108864"
+	defaultLocalCacheTTLStr   = "30s"
+	defaultOutboxExchange     = "user_events"
+	defaultOutboxIntervalStr  = "1s"
+	defaultOutboxRetentionStr = "24h"
+	defaultOutboxBatchSize    = 100
+	defaultWebhookQueue       = "webhooks"
+	defaultWebhookIntervalStr = "1s"
+	// outbox events received ahead of their deliveries being stored.
+	webhookPrefetch             = 50
+	defaultIdempotencyWindowStr = "24h"
+	defaultIdempotencyLeaseStr  = "1m"
+	idempotencyPruneInterval    = 10 * time.Minute
+	defaultImportBatchSize      = 100
+	readsFlushInterval          = 10 * time.Second
+	// wait of the reads of the change feed, the events are sent to the clients as soon as they are read.
+	eventsReadWait = 5 * time.Second
 )
 
 func main() {
@@ -33,17 +59,28 @@ func main() {
 		return
 	}
 
+	encryptionConfig, err := encryptionutils.ConfigFromEnv(os.Getenv)
+	if err != nil {
+		log.Error("encryption configuration is invalid", zap.Error(err))
+		return
+	}
+
+	encr, err := encryptionutils.Load(encryptionConfig)
+	if err != nil {
+		log.Error("encryption key not provided or invalid ", zap.Error(err))
+		return
+	}
 
-	encr,err := encryptionutils.New([]byte(os.Getenv("ENCRYPTION_KEY")))
+	indexer, err := encryptionutils.NewIndexer([]byte(os.Getenv("BLIND_INDEX_KEY")))
 	if err != nil {
-		log.Error("encryption key not provided or invalid ",zap.Error(err))
+		log.Error("blind index key not provided or invalid ", zap.Error(err))
 		return
 	}
 
+	fields := encryptionutils.NewFieldCipher(encr, indexer)
 
 	// initialize environment variables.
 	var DbUrl string
-	var InMemUrl string
 	var QueueUrl string
 
 	if url, ok := os.LookupEnv("POSTGRES_CONNECTION_STRING"); ok {
@@ -53,10 +90,10 @@ func main() {
 		return
 	}
 
-	if url, ok := os.LookupEnv("REDIS_CONNECTION_STRING"); ok {
-		InMemUrl = url
-	} else {
-		log.Error("redis connection string is not set using default url")
+	// a single node with REDIS_CONNECTION_STRING, or Sentinel or Cluster with REDIS_ADDRS.
+	redisConn, err := redis.LoadConnection(os.Getenv)
+	if err != nil {
+		log.Error("redis connection is not set or invalid", zap.Error(err))
 		return
 	}
 
@@ -89,6 +126,13 @@ func main() {
 		}
 	}
 
+	uniqueEmail := strings.ToLower(os.Getenv("UNIQUE_EMAIL")) == "true"
+	err = dataStore.SetUniqueEmail(context.Background(), uniqueEmail)
+	if err != nil {
+		log.Error("can't update unique email constraint throws error", zap.Error(err), zap.Bool("unique_email", uniqueEmail))
+		return
+	}
+
 	ttlstr, ok := os.LookupEnv("REDIS_TTL")
 	if !ok {
 		ttlstr = defaultRedisTTLStr
@@ -101,13 +145,110 @@ func main() {
 		return
 	}
 
+	negativeTTLStr, ok := os.LookupEnv("REDIS_NEGATIVE_TTL")
+	if !ok {
+		negativeTTLStr = defaultRedisNegativeTTLStr
+	}
+
+	negativeTTL, err := time.ParseDuration(negativeTTLStr)
+	if err != nil {
+		log.Error("error fetching redis negative TTL throws error", zap.Error(err), zap.String("negative_ttl", negativeTTLStr))
+		return
+	}
+
+	ttlJitterStr, ok := os.LookupEnv("REDIS_TTL_JITTER")
+	if !ok {
+		ttlJitterStr = defaultRedisTTLJitter
+	}
+
+	ttlJitter, err := strconv.ParseFloat(ttlJitterStr, 64)
+	if err != nil {
+		log.Error("error fetching redis TTL jitter throws error", zap.Error(err), zap.String("ttl_jitter", ttlJitterStr))
+		return
+	}
+
+	// entries of every codec are read, switch to msgpack once every instance knows codecs.
+	codecName, ok := os.LookupEnv("REDIS_CODEC")
+	if !ok {
+		codecName = defaultRedisCodec
+	}
+
+	codec, err := redis.CodecByName(codecName)
+	if err != nil {
+		log.Error("error fetching redis codec throws error", zap.Error(err), zap.String("codec", codecName))
+		return
+	}
+
+	// number of user events kept for the clients resuming the change feed, see redis.DefaultEventsMaxLen.
+	var eventsMaxLen int64
+	if v := os.Getenv("USER_EVENTS_MAX_LEN"); v != "" {
+		eventsMaxLen, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || eventsMaxLen <= 0 {
+			log.Error("error parsing USER_EVENTS_MAX_LEN, it should be a positive number", zap.Error(err), zap.String("value", v))
+			return
+		}
+	}
+
 	// initializing caching service.
-	memStore, err := redis.New(InMemUrl, ttl)
+	redisStore, err := redis.New(redisConn, redis.Config{
+		TTL:          ttl,
+		NegativeTTL:  negativeTTL,
+		TTLJitter:    ttlJitter,
+		Codec:        codec,
+		KeyPrefix:    os.Getenv("REDIS_KEY_PREFIX"),
+		EventsMaxLen: eventsMaxLen,
+	})
 	if err != nil {
 		log.Error("can't initialise redis throws error", zap.Error(err))
 		return
 	}
 
+	// optional in-process cache in front of redis, disabled when LOCAL_CACHE_ENTRIES is not set.
+	var localCache *cache.LRU
+	if entries, _ := strconv.Atoi(os.Getenv("LOCAL_CACHE_ENTRIES")); entries > 0 {
+		maxBytesStr, ok := os.LookupEnv("LOCAL_CACHE_MAX_BYTES")
+		if !ok {
+			maxBytesStr = defaultLocalCacheMaxBytes
+		}
+
+		maxBytes, err := strconv.Atoi(maxBytesStr)
+		if err != nil {
+			log.Error("error fetching local cache max bytes throws error", zap.Error(err), zap.String("max_bytes", maxBytesStr))
+			return
+		}
+
+		localTTLStr, ok := os.LookupEnv("LOCAL_CACHE_TTL")
+		if !ok {
+			localTTLStr = defaultLocalCacheTTLStr
+		}
+
+		localTTL, err := time.ParseDuration(localTTLStr)
+		if err != nil {
+			log.Error("error fetching local cache TTL throws error", zap.Error(err), zap.String("ttl", localTTLStr))
+			return
+		}
+
+		localCache = cache.NewLRU(entries, maxBytes, localTTL)
+		log.Info("local cache enabled", zap.Int("entries", entries), zap.Int("max_bytes", maxBytes), zap.Duration("ttl", localTTL))
+	}
+
+	memStore, err := cache.New(redisStore, localCache, redisStore, redisStore, log)
+	if err != nil {
+		log.Error("can't initialise cache throws error", zap.Error(err))
+		return
+	}
+
+	// drop the local copies of the users changed by the other instances.
+	go func() {
+		err := memStore.Listen(context.Background())
+		if err != nil {
+			log.Error("cache invalidation listener stopped", zap.Error(err))
+		}
+	}()
+
+	// read counts decide which users are warmed first.
+	go memStore.FlushReads(context.Background(), readsFlushInterval)
+
 	queueName, ok := os.LookupEnv("RABBITMQ_QUEUE_NAME")
 	if !ok {
 		log.Error("queue name is not set using environment variable 'QUEUE_NAME'")
@@ -121,7 +262,7 @@ func main() {
 		return
 	}
 
-	consumer, err := services.NewConsumer(queueService, dataStore, memStore,encr, log)
+	consumer, err := services.NewConsumer(queueService, dataStore, memStore, redisStore, dataStore, fields, log)
 	if err != nil {
 		log.Error("can't initialise database throws error", zap.Error(err))
 		return
@@ -139,17 +280,248 @@ func main() {
 		return
 	}
 
+	// publish the changes of the users written in the outbox, the relays of every instance share the events.
+	outboxExchange, ok := os.LookupEnv("OUTBOX_EXCHANGE")
+	if !ok {
+		outboxExchange = defaultOutboxExchange
+	}
+
+	outboxIntervalStr, ok := os.LookupEnv("OUTBOX_RELAY_INTERVAL")
+	if !ok {
+		outboxIntervalStr = defaultOutboxIntervalStr
+	}
+
+	outboxInterval, err := time.ParseDuration(outboxIntervalStr)
+	if err != nil {
+		log.Error("error parsing outbox relay interval throws error", zap.Error(err), zap.String("interval", outboxIntervalStr))
+		return
+	}
+
+	outboxRetentionStr, ok := os.LookupEnv("OUTBOX_RETENTION")
+	if !ok {
+		outboxRetentionStr = defaultOutboxRetentionStr
+	}
+
+	outboxRetention, err := time.ParseDuration(outboxRetentionStr)
+	if err != nil {
+		log.Error("error parsing outbox retention throws error", zap.Error(err), zap.String("retention", outboxRetentionStr))
+		return
+	}
+
+	outboxBatchSize, _ := strconv.Atoi(os.Getenv("OUTBOX_BATCH_SIZE"))
+	if outboxBatchSize <= 0 {
+		outboxBatchSize = defaultOutboxBatchSize
+	}
+
+	exchange, err := rabbitmq.NewExchange(QueueUrl, outboxExchange)
+	if err != nil {
+		log.Error("can't initialise outbox exchange throws error", zap.Error(err), zap.String("exchange", outboxExchange))
+		return
+	}
+	defer exchange.Close()
+
+	// the relay and the webhook deliveries stop with the broker connection, exit so the service is restarted.
+	exchangeClosed := exchange.Closed()
+	go func() {
+		err := <-exchangeClosed
+		log.Fatal("outbox exchange connection closed, stopping the consumer", zap.Error(err), zap.String("exchange", outboxExchange))
+	}()
+
+	outboxRelay := services.NewOutboxRelay(dataStore, exchange, outboxBatchSize, outboxRetention, log)
+	go outboxRelay.Run(context.Background(), outboxInterval)
+
+	// deliver the published changes to the webhooks of the partners, see services.WebhookConfig for the defaults.
+	webhookQueue, ok := os.LookupEnv("WEBHOOK_QUEUE")
+	if !ok {
+		webhookQueue = defaultWebhookQueue
+	}
+
+	webhookIntervalStr, ok := os.LookupEnv("WEBHOOK_INTERVAL")
+	if !ok {
+		webhookIntervalStr = defaultWebhookIntervalStr
+	}
+
+	webhookInterval, err := time.ParseDuration(webhookIntervalStr)
+	if err != nil {
+		log.Error("error parsing webhook interval throws error", zap.Error(err), zap.String("interval", webhookIntervalStr))
+		return
+	}
+
+	var webhookConfig services.WebhookConfig
+	if v := os.Getenv("WEBHOOK_TIMEOUT"); v != "" {
+		webhookConfig.Timeout, err = time.ParseDuration(v)
+		if err != nil {
+			log.Error("error parsing WEBHOOK_TIMEOUT throws error", zap.Error(err), zap.String("value", v))
+			return
+		}
+	}
+	for env, value := range map[string]*int{
+		"WEBHOOK_MAX_ATTEMPTS":  &webhookConfig.MaxAttempts,
+		"WEBHOOK_DISABLE_AFTER": &webhookConfig.DisableAfter,
+		"WEBHOOK_BATCH_SIZE":    &webhookConfig.BatchSize,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value, err = strconv.Atoi(v)
+			if err != nil || *value <= 0 {
+				log.Error("error parsing "+env+", it should be a positive number", zap.Error(err), zap.String("value", v))
+				return
+			}
+		}
+	}
+
+	webhookEvents, err := exchange.Consume(webhookQueue, "user.#", webhookPrefetch)
+	if err != nil {
+		log.Error("can't initialise webhook queue throws error", zap.Error(err), zap.String("queue", webhookQueue))
+		return
+	}
+
+	webhookService := services.NewWebhookService(dataStore, fields, webhookConfig, log)
+	go webhookService.Enqueue(context.Background(), webhookEvents)
+	go webhookService.Run(context.Background(), webhookInterval)
+
 	// create a separate go routine to handle upcoming data.
 	wg := &sync.WaitGroup{}
 	log.Info("starting consumer")
 	go consumer.Consume(wg, bufferSize)
 
-
 	// initialize user service.
-	userService := services.NewUserService(dataStore, memStore, encr,log)
+	userService := services.NewUserService(dataStore, memStore, redisStore, fields, log)
+	// a single reader of the change feed per instance sends the new events to the clients of /users/events and /users/ws.
+	go userService.RunEvents(context.Background(), eventsReadWait)
+
+	// warm the cache in the background, see cmd/cachesync to warm it on a schedule.
+	var warmRecent, warmPopular int64
+	for env, value := range map[string]*int64{
+		"CACHE_WARMUP_RECENT":  &warmRecent,
+		"CACHE_WARMUP_POPULAR": &warmPopular,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || *value < 0 {
+				log.Error("error parsing "+env+", it should be a positive number or 0", zap.Error(err), zap.String("value", v))
+				return
+			}
+		}
+	}
+	if warmRecent > 0 || warmPopular > 0 {
+		cacheSync := services.NewCacheSync(dataStore, memStore, redisStore, log)
+		go func() {
+			progress, err := cacheSync.Warm(context.Background(), warmRecent, warmPopular)
+			if err != nil {
+				log.Error("cache warm-up stopped", zap.Error(err), zap.Any("progress", progress))
+				return
+			}
+			log.Info("cache warm-up completed", zap.Any("progress", progress))
+		}()
+	}
 
 	// initialize controller service.
-	ctl := controller.New(userService, log)
+	// role of the requests without role token, they don't see the emails unless configured otherwise.
+	defaultRole := models.RoleNone
+	if v, ok := os.LookupEnv("DEFAULT_ROLE"); ok {
+		defaultRole, err = models.ParseRole(v)
+		if err != nil {
+			log.Error("invalid DEFAULT_ROLE", zap.Error(err), zap.String("role", v))
+			return
+		}
+	}
+
+	// the role tokens are verified with the secret shared with the gateway, without it every caller gets the default role.
+	var roleTokens controller.RoleVerifier
+	if secret, ok := os.LookupEnv("ROLE_TOKEN_SECRET"); ok {
+		roleTokens, err = authutils.NewRoleTokens([]byte(secret))
+		if err != nil {
+			log.Error("invalid ROLE_TOKEN_SECRET", zap.Error(err))
+			return
+		}
+	}
+
+	// pace of the user stream, see controller.SSEConfig for the defaults.
+	var sseConfig controller.SSEConfig
+	for env, value := range map[string]*time.Duration{
+		"SSE_PAGE_INTERVAL": &sseConfig.PageInterval,
+		"SSE_HEARTBEAT":     &sseConfig.Heartbeat,
+		"SSE_RETRY":         &sseConfig.Retry,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value, err = time.ParseDuration(v)
+			if err != nil {
+				log.Error("error parsing "+env+" throws error", zap.Error(err), zap.String("value", v))
+				return
+			}
+		}
+	}
+
+	// limits of the user subscriptions, see controller.WebSocketConfig for the defaults.
+	var wsConfig controller.WebSocketConfig
+	if v := os.Getenv("WS_PING_INTERVAL"); v != "" {
+		wsConfig.PingInterval, err = time.ParseDuration(v)
+		if err != nil {
+			log.Error("error parsing WS_PING_INTERVAL throws error", zap.Error(err), zap.String("value", v))
+			return
+		}
+	}
+	for env, value := range map[string]*int{
+		"WS_SEND_BUFFER":       &wsConfig.SendBuffer,
+		"WS_MAX_SUBSCRIPTIONS": &wsConfig.MaxSubscriptions,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value, err = strconv.Atoi(v)
+			if err != nil || *value <= 0 {
+				log.Error("error parsing "+env+", it should be a positive number", zap.Error(err), zap.String("value", v))
+				return
+			}
+		}
+	}
+
+	// how long the responses of the requests sent with an Idempotency-Key are replayed to their retries.
+	idempotencyWindowStr, ok := os.LookupEnv("IDEMPOTENCY_WINDOW")
+	if !ok {
+		idempotencyWindowStr = defaultIdempotencyWindowStr
+	}
+
+	idempotencyWindow, err := time.ParseDuration(idempotencyWindowStr)
+	if err != nil {
+		log.Error("error parsing idempotency window throws error", zap.Error(err), zap.String("window", idempotencyWindowStr))
+		return
+	}
+
+	// how long a request in progress holds its key, a retry takes it over afterwards.
+	idempotencyLeaseStr, ok := os.LookupEnv("IDEMPOTENCY_LEASE")
+	if !ok {
+		idempotencyLeaseStr = defaultIdempotencyLeaseStr
+	}
+
+	idempotencyLease, err := time.ParseDuration(idempotencyLeaseStr)
+	if err != nil || idempotencyLease <= 0 {
+		log.Error("error parsing IDEMPOTENCY_LEASE, it should be a positive duration", zap.Error(err), zap.String("lease", idempotencyLeaseStr))
+		return
+	}
+
+	idempotencyService := services.NewIdempotencyService(dataStore, idempotencyWindow, idempotencyLease, log)
+	go idempotencyService.Run(context.Background(), idempotencyPruneInterval)
+
+	// the uploaded csv files are published to the ingestion queue on a connection of their own, the consumer keeps
+	// reading from the queue while they are published.
+	importBatchSize := defaultImportBatchSize
+	if v := os.Getenv("IMPORT_BATCH_SIZE"); v != "" {
+		importBatchSize, err = strconv.Atoi(v)
+		if err != nil || importBatchSize <= 0 {
+			log.Error("error parsing IMPORT_BATCH_SIZE, it should be a positive number", zap.Error(err), zap.String("value", v))
+			return
+		}
+	}
+
+	importQueue, err := rabbitmq.New(QueueUrl, queueName)
+	if err != nil {
+		log.Error("can't initialise import queue throws error", zap.Error(err))
+		return
+	}
+	defer importQueue.Close()
+
+	importer := services.NewImporter(importQueue, dataStore, importBatchSize, log)
+
+	ctl := controller.New(userService, webhookService, idempotencyService, importer, defaultRole, roleTokens, sseConfig, wsConfig, log)
 
 	// initialize router
 	registerRouter(ctl)
@@ -178,7 +550,22 @@ func registerRouter(ctl *controller.Controller) {
 	http.HandleFunc("GET /users", ctl.GetAllUsers)
 	http.HandleFunc("GET /users/{id}", ctl.GetUser)
 	http.HandleFunc("POST /users", ctl.CreateUser)
+	http.HandleFunc("POST /users/batch", ctl.CreateUsers)
+	http.HandleFunc("POST /users/lookup", ctl.LookupUsers)
+	http.HandleFunc("GET /users/export", ctl.ExportUsers)
 	http.HandleFunc("DELETE /users/{id}", ctl.DeleteUser)
+	http.HandleFunc("POST /users/{id}/erase", ctl.EraseUser)
 	http.HandleFunc("GET /users/sse", ctl.GetAllUsersSSE)
+	http.HandleFunc("GET /users/events", ctl.GetUserEvents)
+	http.HandleFunc("GET /users/ws", ctl.SubscribeUsers)
+	http.HandleFunc("POST /imports", ctl.CreateImport)
+	http.HandleFunc("GET /imports", ctl.ListImports)
+	http.HandleFunc("GET /imports/{id}", ctl.GetImport)
+	http.HandleFunc("POST /webhooks", ctl.CreateWebhook)
+	http.HandleFunc("GET /webhooks", ctl.ListWebhooks)
+	http.HandleFunc("GET /webhooks/{id}", ctl.GetWebhook)
+	http.HandleFunc("PUT /webhooks/{id}", ctl.UpdateWebhook)
+	http.HandleFunc("DELETE /webhooks/{id}", ctl.DeleteWebhook)
+	http.HandleFunc("GET /webhooks/{id}/deliveries", ctl.ListWebhookDeliveries)
 	http.Handle("/", http.FileServer(http.Dir("./client")))
 }
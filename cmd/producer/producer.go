@@ -4,8 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"github.com/viswals_task/core/services"
-	"github.com/viswals_task/internal/csvutils"
 	"github.com/viswals_task/internal/logger"
+	"github.com/viswals_task/pkg/database"
 	"github.com/viswals_task/pkg/rabbitmq"
 	"go.uber.org/zap"
 	"os"
@@ -40,12 +40,13 @@ func main() {
 		return
 	}
 
-	// open csv file as csv reader.
-	csvReader, err := csvutils.OpenFile(*csvFilePath)
+	// open csv file, it is read as csv by the importer.
+	csvFile, err := os.Open(*csvFilePath)
 	if err != nil {
 		log.Error("failed to open csv file ", zap.Error(err), zap.String("csvFilePath", *csvFilePath))
 		return
 	}
+	defer csvFile.Close()
 
 	// create connection with queue provider.
 	queueConnection, ok := os.LookupEnv("RABBITMQ_CONNECTION_STRING")
@@ -66,10 +67,8 @@ func main() {
 		return
 	}
 
-	// initializing producer service.
-	producer := services.NewProducer(csvReader, queueService, log)
 	defer func() {
-		err := producer.Close()
+		err := queueService.Close()
 		if err != nil {
 			log.Error("failed to close rabbitmq producer", zap.Error(err), zap.String("queueName", queueName))
 		}
@@ -89,13 +88,29 @@ func main() {
 		batchSize = size
 	}
 
+	// initializing producer service, the run is tracked in the imports table when the database is configured.
+	importer := services.NewImporter(queueService, nil, batchSize, log)
+	dbUrl, ok := os.LookupEnv("POSTGRES_CONNECTION_STRING")
+	if !ok {
+		log.Warn("postgres connection string is not set, the run is not tracked, you can provide environment variable POSTGRES_CONNECTION_STRING to track it")
+	} else {
+		dataStore, err := database.New(dbUrl)
+		if err != nil {
+			log.Error("failed to connect to database", zap.Error(err))
+			return
+		}
+		defer dataStore.Close()
+
+		importer = services.NewImporter(queueService, dataStore, batchSize, log)
+	}
+
 	log.Info("starting producer", zap.Int("batchSize", batchSize))
 
-	err = producer.Start(batchSize)
+	importID, err := importer.Import(csvFile, *csvFilePath)
 	if err != nil {
-		log.Error("failed to start producer", zap.Error(err), zap.String("queueName", queueName))
+		log.Error("failed to start producer", zap.Error(err), zap.String("queueName", queueName), zap.String("import_id", importID))
 		return
 	}
 
-	log.Info("Producer has completed its work")
+	log.Info("Producer has completed its work", zap.String("import_id", importID))
 }
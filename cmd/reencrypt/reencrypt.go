@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/viswals_task/core/services"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/internal/logger"
+	"github.com/viswals_task/pkg/cache"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/redis"
+	"go.uber.org/zap"
+)
+
+var (
+	DevEnvironment = "dev"
+)
+
+// reencrypt re-encrypts every stored email with the active key of the keyring (ENCRYPTION_ACTIVE_KEY_ID),
+// it can be stopped at any time and resumes from the last processed batch when started again.
+func main() {
+	batchSize := flag.Int64("batch", 500, "number of users processed per batch")
+	restart := flag.Bool("restart", false, "ignore the saved checkpoint and start from the first user")
+	flag.Parse()
+
+	log, err := logger.Init(os.Stdout, strings.ToLower(os.Getenv("ENVIRONMENT")) == DevEnvironment)
+	if err != nil {
+		fmt.Printf("can't initialise logger throws error : %v", err)
+		return
+	}
+
+	encryptionConfig, err := encryptionutils.ConfigFromEnv(os.Getenv)
+	if err != nil {
+		log.Error("encryption configuration is invalid", zap.Error(err))
+		return
+	}
+
+	encr, err := encryptionutils.Load(encryptionConfig)
+	if err != nil {
+		log.Error("encryption key not provided or invalid ", zap.Error(err))
+		return
+	}
+
+	// blind indexes don't depend on the encryption key, re-encryption doesn't need the indexer.
+	fields := encryptionutils.NewFieldCipher(encr, nil)
+
+	dbUrl, ok := os.LookupEnv("POSTGRES_CONNECTION_STRING")
+	if !ok {
+		log.Error("postgres connection string is not set, please provide environment variable POSTGRES_CONNECTION_STRING")
+		return
+	}
+
+	dataStore, err := database.New(dbUrl)
+	if err != nil {
+		log.Error("can't initialise database throws error", zap.Error(err))
+		return
+	}
+	defer dataStore.Close()
+
+	// cached users still hold the old ciphertext, remove them when redis is configured.
+	var reencryptor *services.Reencryptor
+	if os.Getenv("REDIS_CONNECTION_STRING") != "" || os.Getenv("REDIS_ADDRS") != "" {
+		redisConn, err := redis.LoadConnection(os.Getenv)
+		if err != nil {
+			log.Error("redis connection is invalid", zap.Error(err))
+			return
+		}
+
+		redisStore, err := redis.New(redisConn, redis.Config{
+			TTL:         time.Minute,
+			NegativeTTL: time.Second,
+			KeyPrefix:   os.Getenv("REDIS_KEY_PREFIX"),
+		})
+		if err != nil {
+			log.Error("can't initialise redis throws error", zap.Error(err))
+			return
+		}
+
+		// removals are announced so the consumers drop their local copies.
+		memStore, err := cache.New(redisStore, nil, redisStore, nil, log)
+		if err != nil {
+			log.Error("can't initialise cache throws error", zap.Error(err))
+			return
+		}
+		reencryptor = services.NewReencryptor(dataStore, dataStore, memStore, fields, log, *batchSize)
+	} else {
+		log.Warn("redis connection is not set, cached users are left to expire with their TTL")
+		reencryptor = services.NewReencryptor(dataStore, dataStore, nil, fields, log, *batchSize)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *restart {
+		err = reencryptor.Reset(ctx)
+		if err != nil {
+			log.Error("can't reset re-encryption checkpoint", zap.Error(err))
+			return
+		}
+	}
+
+	log.Info("starting re-encryption", zap.String("key_id", encr.ActiveKeyID()), zap.Int64("batch_size", *batchSize))
+
+	progress, err := reencryptor.Run(ctx)
+	if err != nil {
+		log.Error("re-encryption stopped, run again to resume", zap.Error(err), zap.Any("progress", progress))
+		return
+	}
+
+	log.Info("re-encryption completed", zap.Any("progress", progress))
+
+	// users created before the email blind index existed are not found by email until they are indexed.
+	indexKey := os.Getenv("BLIND_INDEX_KEY")
+	if indexKey == "" {
+		log.Warn("blind index key is not set, emails are not indexed, you can provide environment variable BLIND_INDEX_KEY to index them")
+		return
+	}
+
+	indexer, err := encryptionutils.NewIndexer([]byte(indexKey))
+	if err != nil {
+		log.Error("blind index key is invalid", zap.Error(err))
+		return
+	}
+
+	indexProgress, err := services.NewEmailIndexer(dataStore, encryptionutils.NewFieldCipher(encr, indexer), log, *batchSize).Run(ctx)
+	if err != nil {
+		log.Error("email indexing stopped, run again to resume", zap.Error(err), zap.Any("progress", indexProgress))
+		return
+	}
+
+	log.Info("email indexing completed", zap.Any("progress", indexProgress))
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// maxBatchSize is the number of users created or looked up per request.
+var maxBatchSize = 100
+
+// batchMeta counts the users of a batch by outcome.
+type batchMeta struct {
+	Created   int `json:"created"`
+	Duplicate int `json:"duplicate"`
+	Invalid   int `json:"invalid"`
+	Failed    int `json:"failed"`
+}
+
+// lookupMeta lists the requested ids without user.
+type lookupMeta struct {
+	NotFound []int64 `json:"not_found"`
+	Erased   []int64 `json:"erased"`
+}
+
+type lookupRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// CreateUsers creates a batch of users, the body is a JSON array of users. Every user has a result with its index in
+// the batch, the users failing do not stop the batch.
+func (c *Controller) CreateUsers(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var items []json.RawMessage
+	err := json.NewDecoder(req.Body).Decode(&items)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid request body, it should be an array of users", nil)
+		return
+	}
+
+	if len(items) == 0 || len(items) > maxBatchSize {
+		c.sendResponse(res, http.StatusBadRequest, fmt.Sprintf("a batch should contain between 1 and %d users", maxBatchSize), nil)
+		return
+	}
+
+	results := make([]*models.CreateResult, len(items))
+	users := make([]*models.UserDetails, 0, len(items))
+	// positions are the indexes in the batch of the users decoded.
+	positions := make([]int, 0, len(items))
+	for i, item := range items {
+		var user models.UserDetails
+		err := json.Unmarshal(item, &user)
+		if err != nil {
+			results[i] = &models.CreateResult{Index: i, Status: models.CreateInvalid, Error: err.Error()}
+			continue
+		}
+		users = append(users, &user)
+		positions = append(positions, i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	for i, result := range c.UserService.CreateUsers(ctx, users) {
+		result.Index = positions[i]
+		results[result.Index] = result
+	}
+
+	meta := &batchMeta{}
+	for _, result := range results {
+		switch result.Status {
+		case models.CreateCreated:
+			meta.Created++
+		case models.CreateDuplicate:
+			meta.Duplicate++
+		case models.CreateInvalid:
+			meta.Invalid++
+		default:
+			meta.Failed++
+		}
+	}
+
+	c.sendResponseWithMeta(res, http.StatusOK, "batch processed", results, meta)
+}
+
+// LookupUsers returns the users with the ids of the body, e.g. {"ids":[1,2,3]}, in the order of the ids. The ids
+// without user are listed in the meta.
+func (c *Controller) LookupUsers(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var body lookupRequest
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid request body, it should be {\"ids\":[...]}", nil)
+		return
+	}
+
+	if len(body.IDs) == 0 || len(body.IDs) > maxBatchSize {
+		c.sendResponse(res, http.StatusBadRequest, fmt.Sprintf("a lookup should contain between 1 and %d ids", maxBatchSize), nil)
+		return
+	}
+
+	ctx, err := c.withRole(context.Background(), req)
+	if err != nil {
+		c.sendResponse(res, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	ctx, err = withTimeFormat(ctx, req)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	lookup, err := c.UserService.GetUsers(ctx, body.IDs)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.sendResponse(res, http.StatusRequestTimeout, "deadline exceed please try again after some time.", nil)
+			return
+		}
+		c.logger.Error("failed to look up users", zap.Error(err), zap.Int("count", len(body.IDs)))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	meta := &lookupMeta{NotFound: lookup.NotFound, Erased: lookup.Erased}
+	if meta.NotFound == nil {
+		meta.NotFound = []int64{}
+	}
+	if meta.Erased == nil {
+		meta.Erased = []int64{}
+	}
+
+	c.sendResponseWithMeta(res, http.StatusOK, "users", lookup.Users, meta)
+}
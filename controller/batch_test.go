@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/authutils"
+	"go.uber.org/zap"
+)
+
+// batchService creates every user and finds the users with an even id.
+type batchService struct {
+	UserService
+	created []*models.UserDetails
+	role    models.Role
+}
+
+func (s *batchService) CreateUsers(_ context.Context, users []*models.UserDetails) []*models.CreateResult {
+	s.created = users
+	results := make([]*models.CreateResult, 0, len(users))
+	for i, user := range users {
+		results = append(results, &models.CreateResult{Index: i, ID: user.ID, Status: models.CreateCreated})
+	}
+	return results
+}
+
+func (s *batchService) GetUsers(ctx context.Context, ids []int64) (*models.UserLookup, error) {
+	s.role = models.RoleFromContext(ctx)
+	lookup := &models.UserLookup{}
+	for _, id := range ids {
+		if id%2 == 0 {
+			lookup.Users = append(lookup.Users, &models.UserDetails{ID: id})
+		} else {
+			lookup.NotFound = append(lookup.NotFound, id)
+		}
+	}
+	return lookup, nil
+}
+
+func TestCreateUsers(t *testing.T) {
+	t.Run("Success: results in the order of the batch", func(t *testing.T) {
+		service := &batchService{}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.CreateUsers(res, httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(`[{"id":1},{"id":"two"},{"id":3}]`)))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		var body struct {
+			Data []*models.CreateResult `json:"data"`
+			Meta batchMeta              `json:"meta"`
+		}
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.Len(t, service.created, 2)
+		assert.Equal(t, []models.CreateStatus{models.CreateCreated, models.CreateInvalid, models.CreateCreated}, []models.CreateStatus{body.Data[0].Status, body.Data[1].Status, body.Data[2].Status})
+		assert.Equal(t, []int{0, 1, 2}, []int{body.Data[0].Index, body.Data[1].Index, body.Data[2].Index})
+		assert.Equal(t, int64(3), body.Data[2].ID)
+		assert.Equal(t, batchMeta{Created: 2, Invalid: 1}, body.Meta)
+	})
+
+	testCases := map[string]string{
+		"Fail: not an array":    `{"id":1}`,
+		"Fail: empty batch":     `[]`,
+		"Fail: batch too large": "[" + strings.Repeat(`{"id":1},`, maxBatchSize) + `{"id":1}]`,
+	}
+
+	for name, body := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := New(&batchService{}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.CreateUsers(res, httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(body)))
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
+
+func TestLookupUsers(t *testing.T) {
+	t.Run("Success: users and ids without user", func(t *testing.T) {
+		service := &batchService{}
+		tokens := newRoleTokens(t)
+		c := New(service, nil, nil, nil, models.RoleAdmin, tokens, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		req := httptest.NewRequest(http.MethodPost, "/users/lookup", strings.NewReader(`{"ids":[2,3,4]}`))
+		req.Header.Set("Authorization", "Bearer "+signRole(t, tokens, models.RoleSupport))
+		res := httptest.NewRecorder()
+		c.LookupUsers(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, models.RoleSupport, service.role)
+
+		var body struct {
+			Data []*models.UserDetails `json:"data"`
+			Meta lookupMeta            `json:"meta"`
+		}
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.Len(t, body.Data, 2)
+		assert.Equal(t, lookupMeta{NotFound: []int64{3}, Erased: []int64{}}, body.Meta)
+	})
+
+	t.Run("Success: default role without token", func(t *testing.T) {
+		service := &batchService{}
+		c := New(service, nil, nil, nil, models.RoleNone, newRoleTokens(t), SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.LookupUsers(res, httptest.NewRequest(http.MethodPost, "/users/lookup", strings.NewReader(`{"ids":[2]}`)))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, models.RoleNone, service.role)
+	})
+
+	t.Run("Fail: role tokens not accepted", func(t *testing.T) {
+		c := New(&batchService{}, nil, nil, nil, models.RoleNone, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		req := httptest.NewRequest(http.MethodPost, "/users/lookup", strings.NewReader(`{"ids":[2]}`))
+		req.Header.Set("Authorization", "Bearer "+signRole(t, newRoleTokens(t), models.RoleAdmin))
+		res := httptest.NewRecorder()
+		c.LookupUsers(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	for name, authorization := range map[string]string{"Fail: invalid token": "Bearer admin", "Fail: not a bearer token": "Basic YWRtaW46YWRtaW4="} {
+		t.Run(name, func(t *testing.T) {
+			service := &batchService{}
+			c := New(service, nil, nil, nil, models.RoleNone, newRoleTokens(t), SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			req := httptest.NewRequest(http.MethodPost, "/users/lookup", strings.NewReader(`{"ids":[2]}`))
+			req.Header.Set("Authorization", authorization)
+			res := httptest.NewRecorder()
+			c.LookupUsers(res, req)
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+			assert.Empty(t, service.role)
+		})
+	}
+
+	for name, body := range map[string]string{"Fail: no ids": `{"ids":[]}`, "Fail: invalid body": `[1,2]`} {
+		t.Run(name, func(t *testing.T) {
+			c := New(&batchService{}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.LookupUsers(res, httptest.NewRequest(http.MethodPost, "/users/lookup", strings.NewReader(body)))
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
+
+func newRoleTokens(t *testing.T) *authutils.RoleTokens {
+	tokens, err := authutils.NewRoleTokens([]byte("secretsecretsecretsecretsecret32"))
+	assert.NoError(t, err)
+	return tokens
+}
+
+func signRole(t *testing.T, tokens *authutils.RoleTokens, role models.Role) string {
+	token, err := tokens.Sign(role, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	return token
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
 	"github.com/viswals_task/pkg/database"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,32 +20,106 @@ var (
 )
 
 type UserService interface {
-	GetAllUsers(context.Context) ([]*models.UserDetails, error)
+	GetAllUsers(context.Context, *models.UserQuery) (*models.UserPage, error)
 	GetUser(context.Context, string) (*models.UserDetails, error)
 	CreateUser(context.Context, *models.UserDetails) error
+	CreateUsers(context.Context, []*models.UserDetails) []*models.CreateResult
+	GetUsers(ctx context.Context, ids []int64) (*models.UserLookup, error)
 	DeleteUser(context.Context, string) error
-	GetAllUsersSSE(ctx context.Context, limit, lastKey int64) ([]byte, error)
+	EraseUser(ctx context.Context, userID int64) (*models.ErasureRecord, error)
+	GetAllUsersSSE(context.Context, *models.UserQuery) (*models.UserPage, error)
+	ExportUsers(ctx context.Context, query *models.UserQuery, write func(*models.UserDetails) error) error
+	SubscribeEvents(ctx context.Context, after string, match func(*models.UserEvent) bool) (services.EventSubscription, error)
 }
 
 type Controller struct {
 	UserService UserService
-	logger      *zap.Logger
+	Webhooks    WebhookService
+	// Idempotency replays the responses of the retries, the Idempotency-Key header is ignored when it is nil.
+	Idempotency IdempotencyService
+	Imports     ImportService
+	// defaultRole is the role of the requests without role token.
+	defaultRole models.Role
+	// roleTokens verifies the role tokens, the requests having one are rejected when it is nil.
+	roleTokens RoleVerifier
+	sse        SSEConfig
+	ws         WebSocketConfig
+	logger     *zap.Logger
 }
 
-func New(userService UserService, logger *zap.Logger) *Controller {
+func New(userService UserService, webhooks WebhookService, idempotency IdempotencyService, imports ImportService, defaultRole models.Role, roleTokens RoleVerifier, sse SSEConfig, ws WebSocketConfig, logger *zap.Logger) *Controller {
 	return &Controller{
 		UserService: userService,
+		Webhooks:    webhooks,
+		Idempotency: idempotency,
+		Imports:     imports,
+		defaultRole: defaultRole,
+		roleTokens:  roleTokens,
+		sse:         sse.withDefaults(),
+		ws:          ws.withDefaults(),
 		logger:      logger,
 	}
 }
 
+// RoleVerifier returns the role granted by a token, e.g. authutils.RoleTokens.
+type RoleVerifier interface {
+	Verify(token string) (models.Role, error)
+}
+
+var (
+	errRoleTokenNotAccepted = errors.New("role tokens are not accepted, the request should not have an Authorization header")
+	errInvalidAuthorization = errors.New("invalid Authorization header, it should be 'Bearer <role token>'")
+)
+
+// withRole adds the role of the caller to the context, it decides how much personal data the response contains.
+// The role is the one granted by the bearer token issued by the gateway authenticating the callers,
+// requests without token get the default role.
+func (c *Controller) withRole(ctx context.Context, req *http.Request) (context.Context, error) {
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return models.WithRole(ctx, c.defaultRole), nil
+	}
+
+	if c.roleTokens == nil {
+		return nil, errRoleTokenNotAccepted
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return nil, errInvalidAuthorization
+	}
+
+	role, err := c.roleTokens.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return models.WithRole(ctx, role), nil
+}
+
+// timeFormatParam selects how the timestamps are written, see models.ParseTimeFormat.
+const timeFormatParam = "time_format"
+
+// withTimeFormat adds the time format requested by the caller to the context.
+func withTimeFormat(ctx context.Context, req *http.Request) (context.Context, error) {
+	format, err := models.ParseTimeFormat(req.URL.Query().Get(timeFormatParam))
+	if err != nil {
+		return nil, err
+	}
+	return models.WithTimeFormat(ctx, format), nil
+}
+
 type httpResponse struct {
 	StatusCode int         `json:"status_code"`
 	Message    string      `json:"message"`
 	Data       interface{} `json:"data"`
+	Meta       interface{} `json:"meta,omitempty"`
 }
 
 func (c *Controller) sendResponse(res http.ResponseWriter, statusCode int, message string, data interface{}) {
+	c.sendResponseWithMeta(res, statusCode, message, data, nil)
+}
+
+func (c *Controller) sendResponseWithMeta(res http.ResponseWriter, statusCode int, message string, data interface{}, meta interface{}) {
 	res.Header().Add("Content-Type", "application/json")
 	res.WriteHeader(statusCode)
 
@@ -56,6 +131,7 @@ func (c *Controller) sendResponse(res http.ResponseWriter, statusCode int, messa
 		StatusCode: statusCode,
 		Message:    message,
 		Data:       data,
+		Meta:       meta,
 	}
 	err := json.NewEncoder(res).Encode(output)
 	if err != nil {
@@ -71,16 +147,49 @@ func (c *Controller) sendResponse(res http.ResponseWriter, statusCode int, messa
 }
 
 func (c *Controller) GetAllUsers(res http.ResponseWriter, req *http.Request) {
-	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	query, err := parseUserQuery(req.URL.Query())
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, err := c.withRole(req.Context(), req)
+	if err != nil {
+		c.sendResponse(res, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	ctx, err = withTimeFormat(ctx, req)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	users, err := c.UserService.GetAllUsers(ctx)
+	page, err := c.UserService.GetAllUsers(ctx, query)
 	if err != nil {
+		if errors.Is(err, models.ErrUnsortableField) || errors.Is(err, models.ErrInvalidEmail) {
+			c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.sendResponse(res, http.StatusRequestTimeout, "deadline exceed please try again after some time.", nil)
+			return
+		}
+		c.logger.Error("failed to get all users", zap.Error(err))
 		c.sendResponse(res, http.StatusInternalServerError, "failed to get all users", nil)
 		return
 	}
 
-	c.sendResponse(res, http.StatusOK, "all users", users)
+	meta := &pageMeta{
+		Limit:      query.Limit,
+		NextCursor: page.NextCursor,
+		TotalCount: page.TotalCount,
+	}
+
+	c.sendResponseWithMeta(res, http.StatusOK, "all users", page.Users, meta)
 }
 
 func (c *Controller) GetUser(res http.ResponseWriter, req *http.Request) {
@@ -90,7 +199,19 @@ func (c *Controller) GetUser(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	ctx, err := c.withRole(context.Background(), req)
+	if err != nil {
+		c.sendResponse(res, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	ctx, err = withTimeFormat(ctx, req)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
 	user, err := c.UserService.GetUser(ctx, id)
@@ -103,6 +224,10 @@ func (c *Controller) GetUser(res http.ResponseWriter, req *http.Request) {
 			c.sendResponse(res, http.StatusNotFound, "requested data not found", nil)
 			return
 		}
+		if errors.Is(err, models.ErrUserErased) {
+			c.sendResponse(res, http.StatusGone, "personal data of the user has been erased", nil)
+			return
+		}
 		c.logger.Error("failed to get user", zap.Error(err), zap.String("id", id))
 		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
 		return
@@ -132,13 +257,31 @@ func (c *Controller) CreateUser(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	err = c.UserService.CreateUser(ctx, &user)
+	// a retry with the same Idempotency-Key replays the response instead of creating the user again.
+	created := &httpResponse{StatusCode: http.StatusCreated, Message: "data created successfully"}
+	c.idempotent(ctx, res, req, body, created, func(ctx context.Context, res http.ResponseWriter) {
+		c.createUser(ctx, res, &user, created)
+	})
+}
+
+func (c *Controller) createUser(ctx context.Context, res http.ResponseWriter, user *models.UserDetails, created *httpResponse) {
+	err := c.UserService.CreateUser(ctx, user)
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidUserID) || errors.Is(err, models.ErrInvalidEmail) {
+			c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
 		if errors.Is(err, database.ErrDuplicate) {
 			c.sendResponse(res, http.StatusConflict, "user already exist in database", nil)
 			return
 		}
 
+		if errors.Is(err, database.ErrDuplicateEmail) {
+			c.sendResponse(res, http.StatusConflict, "user with the same email already exist in database", nil)
+			return
+		}
+
 		if errors.Is(err, context.DeadlineExceeded) {
 			c.sendResponse(res, http.StatusRequestTimeout, "request time out please try again later", nil)
 			return
@@ -148,7 +291,7 @@ func (c *Controller) CreateUser(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	c.sendResponse(res, http.StatusCreated, "data created successfully", nil)
+	c.sendResponse(res, created.StatusCode, created.Message, created.Data)
 }
 
 func (c *Controller) DeleteUser(res http.ResponseWriter, req *http.Request) {
@@ -180,64 +323,32 @@ func (c *Controller) DeleteUser(res http.ResponseWriter, req *http.Request) {
 
 }
 
-func (c *Controller) GetAllUsersSSE(res http.ResponseWriter, req *http.Request) {
-	// default value
-	var limit int64 = 10
-	var offset int64 = 1
-	var err error
-	q := req.URL.Query()
-
-	if q.Get("limit") != "" {
-		limit, err = strconv.ParseInt(q.Get("limit"), 10, 64)
-		if err != nil {
-			c.sendResponse(res, http.StatusBadRequest, "failed to parse limit parameter", nil)
-			return
-		}
-	}
-
-	// Set essential headers
-	res.Header().Set("Content-Type", "text/event-stream")
-	res.Header().Set("Cache-Control", "no-cache")
-
-	res.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// flusher to send data immediately to the client using Flush function
-	flusher, ok := res.(http.Flusher)
-	if !ok {
-		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+// EraseUser crypto-shreds the personal data of the user, the response contains the audit record of the erasure.
+func (c *Controller) EraseUser(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid user id, please check url. it should be /users/:id/erase", nil)
 		return
 	}
-	var isLastData bool
-	for {
 
-		data, err := c.UserService.GetAllUsersSSE(context.Background(), limit, offset*limit)
-		if err != nil {
-			if errors.Is(err, database.ErrNoData) {
-				isLastData = true
-			}
-		}
-		_, err = fmt.Fprint(res, "data: "+string(data)+"\n\n")
-		if err != nil {
-			c.logger.Error("failed to write response", zap.Error(err))
-			c.sendResponse(res, http.StatusInternalServerError, "fail to send data to client", nil)
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	record, err := c.UserService.EraseUser(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoData) {
+			c.sendResponse(res, http.StatusNotFound, "requested data not found", nil)
 			return
 		}
-		flusher.Flush()
-		time.Sleep(2 * time.Second)
 
-		if isLastData {
-			break
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.sendResponse(res, http.StatusRequestTimeout, "request time out please try again later", nil)
+			return
 		}
-
-		// increasing offset for next batch
-		offset++
-	}
-
-	_, err = fmt.Fprint(res, "data: END\n\n")
-	if err != nil {
-		c.logger.Error("failed to write response", zap.Error(err))
-		c.sendResponse(res, http.StatusInternalServerError, "fail to send data to client", nil)
+		c.logger.Error("failed to erase user", zap.Error(err), zap.Int64("id", id))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error can't erase user, please try again", nil)
 		return
 	}
-	flusher.Flush()
+
+	c.sendResponse(res, http.StatusOK, "personal data erased", record)
 }
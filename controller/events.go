@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// eventsBatchSize is the maximum number of events read at once.
+const eventsBatchSize = 100
+
+// GetUserEvents streams the changes of the users as they are written by any instance. Every event is sent
+// with its sequence number as id, a reconnecting client sends it back as Last-Event-ID, or as the after
+// parameter, and receives the events it missed. The types parameter selects the event types, e.g.
+// types=created,deleted. Only the new events are sent when no sequence number is given.
+func (c *Controller) GetUserEvents(res http.ResponseWriter, req *http.Request) {
+	types, err := models.ParseEventTypes(req.URL.Query().Get("types"))
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	after := req.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = req.URL.Query().Get("after")
+	}
+	if after != "" {
+		_, err = models.ParseEventSeq(after)
+		if err != nil {
+			c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	// the request context is cancelled when the client disconnects.
+	ctx := req.Context()
+	filter := &models.EventFilter{Types: types}
+
+	// the position of a client without sequence number is resolved before responding so no event written after the
+	// request is missed.
+	subscription, err := c.UserService.SubscribeEvents(ctx, after, filter.Matches)
+	if err != nil {
+		if errors.Is(err, services.ErrEventsDisabled) {
+			c.sendResponse(res, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		c.logger.Error("failed to read user events", zap.Error(err))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+	defer subscription.Close()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.WriteHeader(http.StatusOK)
+
+	stream := &sseWriter{res: res, flusher: flusher}
+	err = stream.retry(c.sse.Retry)
+	if err != nil {
+		return
+	}
+
+	for {
+		events, err := subscription.Next(ctx, eventsBatchSize, c.sse.Heartbeat)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("failed to read user events", zap.Error(err))
+				_ = stream.event("error", "", "failed to get user events")
+			}
+			return
+		}
+
+		// nothing was sent while waiting for events, keep the connection open through proxies.
+		if len(events) == 0 {
+			err = stream.comment("heartbeat")
+			if err != nil {
+				return
+			}
+		}
+
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				c.logger.Error("failed to marshal user event", zap.Error(err))
+				_ = stream.event("error", "", "failed to get user events")
+				return
+			}
+
+			err = stream.event("", event.Seq, string(data))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
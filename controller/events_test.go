@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"go.uber.org/zap"
+)
+
+// eventService returns its subscription, the position and filter of the client are recorded.
+type eventService struct {
+	UserService
+	subscription *eventSubscription
+	err          error
+
+	after string
+	match func(*models.UserEvent) bool
+}
+
+func (s *eventService) SubscribeEvents(_ context.Context, after string, match func(*models.UserEvent) bool) (services.EventSubscription, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.after, s.match = after, match
+	return s.subscription, nil
+}
+
+// eventSubscription returns its batches in order, then waits for the client to disconnect.
+type eventSubscription struct {
+	mu      sync.Mutex
+	batches [][]*models.UserEvent
+	waits   []time.Duration
+	closed  bool
+}
+
+func (s *eventSubscription) Next(ctx context.Context, _ int64, wait time.Duration) ([]*models.UserEvent, error) {
+	s.mu.Lock()
+	s.waits = append(s.waits, wait)
+	if len(s.batches) > 0 {
+		events := s.batches[0]
+		s.batches = s.batches[1:]
+		s.mu.Unlock()
+		return events, nil
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *eventSubscription) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+func (s *eventSubscription) recorded() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Duration(nil), s.waits...)
+}
+
+func TestGetUserEvents(t *testing.T) {
+	at := time.UnixMilli(1709296200000).UTC()
+	created := &models.UserEvent{Seq: "1709296200000-0", Type: models.EventCreated, UserID: 1, At: at}
+	deleted := &models.UserEvent{Seq: "1709296200000-1", Type: models.EventDeleted, UserID: 1, At: at}
+	sse := SSEConfig{Heartbeat: time.Second, Retry: time.Second}
+
+	t.Run("Success: stream events until the client disconnects", func(t *testing.T) {
+		service := &eventService{subscription: &eventSubscription{batches: [][]*models.UserEvent{{created}, nil, {deleted}}}}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/users/events?types=created,deleted", nil).WithContext(ctx)
+		req.Header.Set("Last-Event-ID", "1709296100000-0")
+		res := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			c.GetUserEvents(res, req)
+			close(done)
+		}()
+
+		assert.Eventually(t, func() bool { return len(service.subscription.recorded()) == 4 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+
+		assert.Equal(t, "1709296100000-0", service.after)
+		assert.True(t, service.match(deleted))
+		assert.False(t, service.match(&models.UserEvent{Type: models.EventMerged}))
+		assert.Equal(t, time.Second, service.subscription.recorded()[0])
+		assert.True(t, service.subscription.closed)
+
+		assert.Equal(t, "text/event-stream", res.Header().Get("Content-Type"))
+		assert.Equal(t, "retry: 1000\n\n"+
+			"id: 1709296200000-0\ndata: {\"seq\":\"1709296200000-0\",\"type\":\"created\",\"user_id\":1,\"at\":\"2024-03-01T12:30:00Z\"}\n\n"+
+			": heartbeat\n\n"+
+			"id: 1709296200000-1\ndata: {\"seq\":\"1709296200000-1\",\"type\":\"deleted\",\"user_id\":1,\"at\":\"2024-03-01T12:30:00Z\"}\n\n",
+			res.Body.String())
+	})
+
+	t.Run("Success: after parameter", func(t *testing.T) {
+		service := &eventService{subscription: &eventSubscription{}}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c.GetUserEvents(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/events?after="+created.Seq, nil).WithContext(ctx))
+
+		assert.Equal(t, created.Seq, service.after)
+	})
+
+	for name, target := range map[string]string{
+		"Fail: invalid event type":      "/users/events?types=created,renamed",
+		"Fail: invalid sequence number": "/users/events?after=latest",
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := New(&eventService{}, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+			res := httptest.NewRecorder()
+			c.GetUserEvents(res, httptest.NewRequest(http.MethodGet, target, nil))
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+	}
+
+	t.Run("Fail: change feed disabled", func(t *testing.T) {
+		c := New(&eventService{err: services.ErrEventsDisabled}, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		res := httptest.NewRecorder()
+		c.GetUserEvents(res, httptest.NewRequest(http.MethodGet, "/users/events", nil))
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.False(t, strings.Contains(res.Body.String(), "retry:"))
+	})
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportColumns are the columns of the csv exports, the ones of the files imported by the producer and POST /imports.
+var exportColumns = []string{"id", "first_name", "last_name", "email_address", "created_at", "deleted_at", "merged_at", "parent_user_id"}
+
+// userEncoder writes the users of an export, Flush writes what is buffered.
+type userEncoder interface {
+	Encode(user *models.UserDetails) error
+	Flush() error
+}
+
+type exportFormat struct {
+	contentType string
+	encoder     func(w io.Writer) userEncoder
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {contentType: "text/csv; charset=utf-8", encoder: newCSVEncoder},
+	"ndjson": {contentType: "application/x-ndjson", encoder: newNDJSONEncoder},
+}
+
+// csvEncoder writes the users like the imported files: times are unix milliseconds and -1 when not set.
+type csvEncoder struct {
+	writer *csv.Writer
+	header bool
+}
+
+func newCSVEncoder(w io.Writer) userEncoder {
+	return &csvEncoder{writer: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.writer.Write(exportColumns)
+}
+
+func (e *csvEncoder) Encode(user *models.UserDetails) error {
+	err := e.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	return e.writer.Write([]string{
+		strconv.FormatInt(user.ID, 10),
+		user.FirstName,
+		user.LastName,
+		user.EmailAddress,
+		csvTime(user.CreatedAt),
+		csvTime(user.DeletedAt),
+		csvTime(user.MergedAt),
+		strconv.FormatInt(user.ParentUserId, 10),
+	})
+}
+
+// Flush writes the header of an empty export too.
+func (e *csvEncoder) Flush() error {
+	err := e.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func csvTime(t models.NullTime) string {
+	if !t.Valid {
+		return "-1"
+	}
+	return strconv.FormatInt(t.Time.UnixMilli(), 10)
+}
+
+// ndjsonEncoder writes a JSON user per line, like the users of GET /users.
+type ndjsonEncoder struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newNDJSONEncoder(w io.Writer) userEncoder {
+	buffer := bufio.NewWriter(w)
+	return &ndjsonEncoder{buffer: buffer, encoder: json.NewEncoder(buffer)}
+}
+
+func (e *ndjsonEncoder) Encode(user *models.UserDetails) error {
+	return e.encoder.Encode(user)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.buffer.Flush()
+}
+
+// ExportUsers streams every user matching the filters of GET /users as a csv (default) or ndjson attachment, e.g.
+// /users/export?format=ndjson&deleted=false. The sort and the role of the caller are applied, the limit is ignored.
+// An export failing once started is aborted so the client gets an incomplete response rather than a truncated file.
+func (c *Controller) ExportUsers(res http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+
+	format, ok := exportFormats[name]
+	if !ok {
+		c.sendResponse(res, http.StatusBadRequest, "format should be either csv or ndjson", nil)
+		return
+	}
+
+	query, err := parseUserQuery(req.URL.Query())
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, err := c.withRole(req.Context(), req)
+	if err != nil {
+		c.sendResponse(res, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	ctx, err = withTimeFormat(ctx, req)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	encoder := format.encoder(res)
+	started := false
+	start := func() {
+		started = true
+		res.Header().Set("Content-Type", format.contentType)
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"users-%s.%s\"", time.Now().UTC().Format("20060102T150405Z"), name))
+		res.WriteHeader(http.StatusOK)
+	}
+
+	// the exports are not bounded by defaultTimeout, they stop when the client goes away.
+	err = c.UserService.ExportUsers(ctx, query, func(user *models.UserDetails) error {
+		if !started {
+			start()
+		}
+		return encoder.Encode(user)
+	})
+	if err == nil {
+		if !started {
+			start()
+		}
+		err = encoder.Flush()
+	}
+
+	if err == nil {
+		return
+	}
+
+	if !started {
+		if errors.Is(err, models.ErrUnsortableField) || errors.Is(err, models.ErrInvalidEmail) {
+			c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		c.logger.Error("failed to export users", zap.Error(err))
+		c.sendResponse(res, http.StatusInternalServerError, "failed to export users", nil)
+		return
+	}
+
+	c.logger.Error("user export stopped", zap.Error(err), zap.String("format", name))
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+)
+
+// exportService exports its users then fails with err.
+type exportService struct {
+	UserService
+	users []*models.UserDetails
+	err   error
+	query *models.UserQuery
+}
+
+func (s *exportService) ExportUsers(_ context.Context, query *models.UserQuery, write func(*models.UserDetails) error) error {
+	s.query = query
+	for _, user := range s.users {
+		err := write(user)
+		if err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestExportUsers(t *testing.T) {
+	createdAt := time.UnixMilli(1737481973000)
+	users := []*models.UserDetails{
+		{ID: 1, FirstName: "john", LastName: "doe", EmailAddress: "john@example.com", CreatedAt: models.NewNullTime(createdAt), ParentUserId: -1},
+		{ID: 2, FirstName: "jane", LastName: "doe, jr", ParentUserId: 1},
+	}
+
+	t.Run("Success: csv with filters", func(t *testing.T) {
+		service := &exportService{users: users}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.ExportUsers(res, httptest.NewRequest(http.MethodGet, "/users/export?deleted=false&sort=created_at", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+		assert.Regexp(t, `^attachment; filename="users-\d{8}T\d{6}Z\.csv"$`, res.Header().Get("Content-Disposition"))
+		assert.Equal(t, "id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n"+
+			"1,john,doe,john@example.com,1737481973000,-1,-1,-1\n"+
+			"2,jane,\"doe, jr\",,-1,-1,-1,1\n", res.Body.String())
+		assert.Equal(t, false, *service.query.Deleted)
+		assert.Equal(t, models.SortByCreatedAt, service.query.SortBy)
+	})
+
+	t.Run("Success: ndjson", func(t *testing.T) {
+		c := New(&exportService{users: users}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.ExportUsers(res, httptest.NewRequest(http.MethodGet, "/users/export?format=ndjson", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "application/x-ndjson", res.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSuffix(res.Body.String(), "\n"), "\n")
+		assert.Len(t, lines, 2)
+		assert.Contains(t, lines[1], `"id":2`)
+	})
+
+	t.Run("Success: empty csv has a header", func(t *testing.T) {
+		c := New(&exportService{}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.ExportUsers(res, httptest.NewRequest(http.MethodGet, "/users/export", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n", res.Body.String())
+	})
+
+	testCases := map[string]struct {
+		url  string
+		err  error
+		code int
+	}{
+		"Fail: unknown format":       {url: "/users/export?format=xml", code: http.StatusBadRequest},
+		"Fail: invalid filter":       {url: "/users/export?deleted=maybe", code: http.StatusBadRequest},
+		"Fail: blank email":          {url: "/users/export?email=%20", code: http.StatusBadRequest},
+		"Fail: encrypted sort":       {url: "/users/export", err: models.ErrUnsortableField, code: http.StatusBadRequest},
+		"Fail: database unavailable": {url: "/users/export", err: errors.New("test error"), code: http.StatusInternalServerError},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := New(&exportService{err: tc.err}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.ExportUsers(res, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			assert.Equal(t, tc.code, res.Code)
+			assert.Empty(t, res.Header().Get("Content-Disposition"))
+		})
+	}
+
+	t.Run("Fail: export aborted once started", func(t *testing.T) {
+		c := New(&exportService{users: users, err: errors.New("test error")}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			c.ExportUsers(res, httptest.NewRequest(http.MethodGet, "/users/export", nil))
+		})
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const (
+	// idempotencyKeyHeader makes the retries of a request replay its response, e.g. a uuid generated by the client.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on the responses replayed to a retry.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+type IdempotencyService interface {
+	Reserve(context.Context, *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	Complete(context.Context, *models.IdempotencyRecord) error
+	Release(context.Context, *models.IdempotencyRecord) error
+}
+
+// responseRecorder keeps a copy of the response written to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotent handles the request once per idempotency key: the retries replay the stored response and a retry with
+// another body is rejected. The success response is stored with the write of handle, so a retry replays it when the
+// write committed even if the request timed out. The requests without key are handled as usual.
+func (c *Controller) idempotent(ctx context.Context, res http.ResponseWriter, req *http.Request, body []byte, success *httpResponse, handle func(context.Context, http.ResponseWriter)) {
+	key := req.Header.Get(idempotencyKeyHeader)
+	if key == "" || c.Idempotency == nil {
+		handle(ctx, res)
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		c.sendResponse(res, http.StatusBadRequest, "invalid idempotency key, it should be at most 255 characters", nil)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	record := &models.IdempotencyRecord{Scope: req.Method + " " + req.URL.Path, Key: key, RequestHash: hex.EncodeToString(hash[:])}
+
+	stored, err := c.Idempotency.Reserve(ctx, record)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.sendResponse(res, http.StatusRequestTimeout, "request time out please try again later", nil)
+			return
+		}
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	if stored != nil {
+		switch {
+		case stored.RequestHash != record.RequestHash:
+			c.sendResponse(res, http.StatusUnprocessableEntity, "idempotency key already used by another request", nil)
+		case stored.StatusCode == 0:
+			c.sendResponse(res, http.StatusConflict, "request with the same idempotency key is in progress, please try again later", nil)
+		default:
+			res.Header().Add("Content-Type", "application/json")
+			res.Header().Set(idempotentReplayedHeader, "true")
+			res.WriteHeader(stored.StatusCode)
+			_, err = res.Write(stored.Response)
+			if err != nil {
+				c.logger.Error("failed to write response", zap.Error(err), zap.String("scope", record.Scope))
+			}
+		}
+		return
+	}
+
+	// the success response as written by sendResponse.
+	response, err := json.Marshal(success)
+	if err != nil {
+		c.logger.Error("failed to marshal json", zap.Error(err), zap.String("response", success.Message))
+	}
+	committed := *record
+	committed.StatusCode, committed.Response = success.StatusCode, append(response, '\n')
+
+	recorder := &responseRecorder{ResponseWriter: res}
+	handle(models.WithIdempotency(ctx, &committed), recorder)
+
+	// the request context might be done, the outcome is stored regardless.
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// without response the request can be retried, unless its write committed.
+	if recorder.status >= http.StatusInternalServerError || recorder.status == http.StatusRequestTimeout {
+		_ = c.Idempotency.Release(ctx, record)
+		return
+	}
+
+	record.StatusCode, record.Response = recorder.status, recorder.body.Bytes()
+	_ = c.Idempotency.Complete(ctx, record)
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+)
+
+// idempotencyStore keeps the records in memory like the database, the records committed with a write are stored by
+// createService.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*models.IdempotencyRecord
+}
+
+func (s *idempotencyStore) Reserve(_ context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.records[record.Key]; ok {
+		return stored, nil
+	}
+	s.records[record.Key] = &models.IdempotencyRecord{Scope: record.Scope, Key: record.Key, RequestHash: record.RequestHash}
+	return nil, nil
+}
+
+func (s *idempotencyStore) Complete(_ context.Context, record *models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored := s.records[record.Key]; stored.StatusCode == 0 {
+		stored.StatusCode, stored.Response = record.StatusCode, append([]byte(nil), record.Response...)
+	}
+	return nil
+}
+
+func (s *idempotencyStore) Release(_ context.Context, record *models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records[record.Key].StatusCode == 0 {
+		delete(s.records, record.Key)
+	}
+	return nil
+}
+
+// createService fails with errs in order, a write committed before a timeout stores the record of the context.
+type createService struct {
+	UserService
+	store     *idempotencyStore
+	errs      []error
+	committed bool
+	calls     int
+}
+
+func (s *createService) CreateUser(ctx context.Context, _ *models.UserDetails) error {
+	s.calls++
+	var err error
+	if len(s.errs) > 0 {
+		err, s.errs = s.errs[0], s.errs[1:]
+	}
+	if err == nil || s.committed {
+		if record := models.IdempotencyFromContext(ctx); record != nil {
+			_ = s.store.Complete(ctx, record)
+		}
+	}
+	return err
+}
+
+func TestCreateUserIdempotency(t *testing.T) {
+	body := `{"id":1,"first_name":"John"}`
+	send := func(c *Controller, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		res := httptest.NewRecorder()
+		c.CreateUser(res, req)
+		return res
+	}
+
+	testCases := map[string]struct {
+		errs      []error
+		committed bool
+		// body of the retry.
+		retry    string
+		statuses []int
+		replayed bool
+		calls    int
+	}{
+		"Success: retry replays created user":               {retry: body, statuses: []int{http.StatusCreated, http.StatusCreated}, replayed: true, calls: 1},
+		"Success: retry replays conflict":                   {errs: []error{database.ErrDuplicate}, retry: body, statuses: []int{http.StatusConflict, http.StatusConflict}, replayed: true, calls: 1},
+		"Success: retry after a committed write replays it": {errs: []error{context.DeadlineExceeded}, committed: true, retry: body, statuses: []int{http.StatusRequestTimeout, http.StatusCreated}, replayed: true, calls: 1},
+		"Success: retry after a failed write creates user":  {errs: []error{context.DeadlineExceeded}, retry: body, statuses: []int{http.StatusRequestTimeout, http.StatusCreated}, calls: 2},
+		"Success: retry replays invalid user":               {errs: []error{models.ErrInvalidEmail}, retry: body, statuses: []int{http.StatusBadRequest, http.StatusBadRequest}, replayed: true, calls: 1},
+		"Fail: retry with another body":                     {retry: `{"id":2}`, statuses: []int{http.StatusCreated, http.StatusUnprocessableEntity}, calls: 1},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store := &idempotencyStore{records: map[string]*models.IdempotencyRecord{}}
+			service := &createService{store: store, errs: tc.errs, committed: tc.committed}
+			c := New(service, nil, store, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+
+			first := send(c, "key-1", body)
+			retry := send(c, "key-1", tc.retry)
+			assert.Equal(t, tc.statuses, []int{first.Code, retry.Code})
+			assert.Equal(t, tc.replayed, retry.Header().Get(idempotentReplayedHeader) == "true")
+			if tc.replayed && first.Code == retry.Code {
+				assert.Equal(t, first.Body.String(), retry.Body.String())
+			}
+			assert.Equal(t, tc.calls, service.calls)
+		})
+	}
+
+	t.Run("Fail: request in progress", func(t *testing.T) {
+		store := &idempotencyStore{records: map[string]*models.IdempotencyRecord{}}
+		service := &createService{store: store}
+		c := New(service, nil, store, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		hash := sha256.Sum256([]byte(body))
+		_, _ = store.Reserve(context.Background(), &models.IdempotencyRecord{Key: "key-1", RequestHash: hex.EncodeToString(hash[:])})
+
+		assert.Equal(t, http.StatusConflict, send(c, "key-1", body).Code)
+		assert.Equal(t, 0, service.calls)
+	})
+
+	t.Run("Success: requests without key are not stored", func(t *testing.T) {
+		store := &idempotencyStore{records: map[string]*models.IdempotencyRecord{}}
+		service := &createService{store: store}
+		c := New(service, nil, store, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+
+		assert.Equal(t, http.StatusCreated, send(c, "", body).Code)
+		assert.Equal(t, http.StatusCreated, send(c, "", body).Code)
+		assert.Equal(t, 2, service.calls)
+		assert.Empty(t, store.records)
+	})
+
+	t.Run("Fail: key too long", func(t *testing.T) {
+		store := &idempotencyStore{records: map[string]*models.IdempotencyRecord{}}
+		service := &createService{store: store}
+		c := New(service, nil, store, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+
+		assert.Equal(t, http.StatusBadRequest, send(c, strings.Repeat("k", maxIdempotencyKeyLength+1), body).Code)
+		assert.Equal(t, 0, service.calls)
+	})
+}
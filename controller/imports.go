@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// importFileField is the form field of the uploaded csv file.
+const importFileField = "file"
+
+var (
+	defaultImportsLimit int64 = 50
+	maxImportsLimit     int64 = 500
+)
+
+type ImportService interface {
+	Import(file io.Reader, name string) (string, error)
+	GetImport(ctx context.Context, id string) (*models.Import, error)
+	ListImports(ctx context.Context, limit int64) ([]*models.Import, error)
+}
+
+type importResponse struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+}
+
+// CreateImport publishes the users of the csv file uploaded in the file field of a multipart/form-data request to the
+// ingestion queue, the file is streamed to the queue without being stored. The users are ingested by the consumers
+// once the response is sent.
+func (c *Controller) CreateImport(res http.ResponseWriter, req *http.Request) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid request, it should be a multipart/form-data upload with the csv in the 'file' field", nil)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			c.sendResponse(res, http.StatusBadRequest, "csv file not found, it should be uploaded in the 'file' field", nil)
+			return
+		}
+		if err != nil {
+			c.sendResponse(res, http.StatusBadRequest, "invalid multipart body", nil)
+			return
+		}
+
+		if part.FormName() != importFileField {
+			_ = part.Close()
+			continue
+		}
+
+		id, err := c.Imports.Import(part, part.FileName())
+		_ = part.Close()
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCSV) {
+				c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+				return
+			}
+			c.logger.Error("failed to import csv file", zap.Error(err), zap.String("import_id", id))
+			c.sendResponse(res, http.StatusInternalServerError, "import stopped, the users published before the failure are ingested", &importResponse{ID: id, File: part.FileName()})
+			return
+		}
+
+		c.sendResponse(res, http.StatusAccepted, "import published, the users are being ingested", &importResponse{ID: id, File: part.FileName()})
+		return
+	}
+}
+
+// ListImports returns the last imports with their counts, most recent first, limit defaults to 50.
+func (c *Controller) ListImports(res http.ResponseWriter, req *http.Request) {
+	limit := defaultImportsLimit
+	if v := req.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			c.sendResponse(res, http.StatusBadRequest, "invalid limit, it should be a positive integer", nil)
+			return
+		}
+		limit = min(parsed, maxImportsLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	imports, err := c.Imports.ListImports(ctx, limit)
+	if err != nil {
+		c.logger.Error("failed to list imports", zap.Error(err))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "imports", imports)
+}
+
+func (c *Controller) GetImport(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	imp, err := c.Imports.GetImport(ctx, req.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrNoData) {
+			c.sendResponse(res, http.StatusNotFound, "import not found", nil)
+			return
+		}
+		c.logger.Error("failed to get import", zap.Error(err), zap.String("import_id", req.PathValue("id")))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "success", imp)
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+)
+
+// importService reads the uploaded file and fails with err, it has a single import.
+type importService struct {
+	file  []byte
+	name  string
+	limit int64
+	err   error
+}
+
+func (s *importService) Import(file io.Reader, name string) (string, error) {
+	s.file, _ = io.ReadAll(file)
+	s.name = name
+	return "import-id", s.err
+}
+
+func (s *importService) GetImport(_ context.Context, id string) (*models.Import, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if id != "import-id" {
+		return nil, database.ErrNoData
+	}
+	return &models.Import{ID: id, Status: models.ImportCompleted}, nil
+}
+
+func (s *importService) ListImports(_ context.Context, limit int64) ([]*models.Import, error) {
+	s.limit = limit
+	return []*models.Import{{ID: "import-id"}}, s.err
+}
+
+func multipartUpload(t *testing.T, field string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	assert.NoError(t, writer.WriteField("comment", "users"))
+	part, err := writer.CreateFormFile(field, "users.csv")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte("id\n1\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/imports", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestCreateImport(t *testing.T) {
+	t.Run("Success: file streamed to the importer", func(t *testing.T) {
+		service := &importService{}
+		c := New(nil, nil, nil, service, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+		res := httptest.NewRecorder()
+		c.CreateImport(res, multipartUpload(t, importFileField))
+		assert.Equal(t, http.StatusAccepted, res.Code)
+		assert.Equal(t, "id\n1\n", string(service.file))
+		assert.Equal(t, "users.csv", service.name)
+		assert.Contains(t, res.Body.String(), `"id":"import-id"`)
+	})
+
+	testCases := map[string]struct {
+		req  func(t *testing.T) *http.Request
+		err  error
+		code int
+	}{
+		"Fail: not multipart": {
+			req: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/imports", bytes.NewBufferString("id\n1\n"))
+			},
+			code: http.StatusBadRequest,
+		},
+		"Fail: no file field": {
+			req:  func(t *testing.T) *http.Request { return multipartUpload(t, "upload") },
+			code: http.StatusBadRequest,
+		},
+		"Fail: invalid csv": {
+			req:  func(t *testing.T) *http.Request { return multipartUpload(t, importFileField) },
+			err:  services.ErrInvalidCSV,
+			code: http.StatusBadRequest,
+		},
+		"Fail: queue unavailable": {
+			req:  func(t *testing.T) *http.Request { return multipartUpload(t, importFileField) },
+			err:  errors.New("test error"),
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := New(nil, nil, nil, &importService{err: tc.err}, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.CreateImport(res, tc.req(t))
+			assert.Equal(t, tc.code, res.Code)
+		})
+	}
+}
+
+func TestListImports(t *testing.T) {
+	testCases := map[string]struct {
+		query string
+		err   error
+		code  int
+		limit int64
+	}{
+		"Success: default limit":  {code: http.StatusOK, limit: defaultImportsLimit},
+		"Success: limit capped":   {query: "?limit=1000", code: http.StatusOK, limit: maxImportsLimit},
+		"Fail: invalid limit":     {query: "?limit=-1", code: http.StatusBadRequest},
+		"Fail: store unavailable": {err: errors.New("test error"), code: http.StatusInternalServerError, limit: defaultImportsLimit},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &importService{err: tc.err}
+			c := New(nil, nil, nil, service, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.ListImports(res, httptest.NewRequest(http.MethodGet, "/imports"+tc.query, nil))
+			assert.Equal(t, tc.code, res.Code)
+			assert.Equal(t, tc.limit, service.limit)
+		})
+	}
+}
+
+func TestGetImport(t *testing.T) {
+	testCases := map[string]struct {
+		id   string
+		err  error
+		code int
+	}{
+		"Success: import found":   {id: "import-id", code: http.StatusOK},
+		"Fail: import not found":  {id: "unknown", code: http.StatusNotFound},
+		"Fail: store unavailable": {id: "import-id", err: errors.New("test error"), code: http.StatusInternalServerError},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := New(nil, nil, nil, &importService{err: tc.err}, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			req := httptest.NewRequest(http.MethodGet, "/imports/"+tc.id, nil)
+			req.SetPathValue("id", tc.id)
+			res := httptest.NewRecorder()
+			c.GetImport(res, req)
+			assert.Equal(t, tc.code, res.Code)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultPageLimit int64 = 50
+	maxPageLimit     int64 = 500
+)
+
+type pageMeta struct {
+	Limit      int64  `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	TotalCount int64  `json:"total_count"`
+}
+
+// parseUserQuery reads the pagination, sorting and filter parameters of the user listing.
+func parseUserQuery(q url.Values) (*models.UserQuery, error) {
+	query := &models.UserQuery{
+		Limit:  defaultPageLimit,
+		SortBy: models.SortByID,
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, errors.New("limit should be a positive number")
+		}
+		query.Limit = min(limit, maxPageLimit)
+	}
+
+	if v := q.Get("sort"); v != "" {
+		query.SortBy = models.SortField(v)
+		if !query.SortBy.Valid() {
+			return nil, fmt.Errorf("unsupported sort field %q, use either id or created_at", v)
+		}
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, errors.New("order should be either asc or desc")
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := models.DecodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+			return nil, errors.New("cursor does not belong to the requested sort order")
+		}
+		query.After = cursor
+	}
+
+	var err error
+
+	query.CreatedAfter, err = parseTimeParam(q, "created_after")
+	if err != nil {
+		return nil, err
+	}
+
+	query.CreatedBefore, err = parseTimeParam(q, "created_before")
+	if err != nil {
+		return nil, err
+	}
+
+	query.Deleted, err = parseBoolParam(q, "deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	query.Merged, err = parseBoolParam(q, "merged")
+	if err != nil {
+		return nil, err
+	}
+
+	if v := q.Get("parent_user_id"); v != "" {
+		parentID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("parent_user_id should be a number")
+		}
+		query.ParentUserID = &parentID
+	}
+
+	if q.Has("email") {
+		query.Email = q.Get("email")
+		if strings.TrimSpace(query.Email) == "" {
+			return nil, errors.New("email should not be blank")
+		}
+	}
+
+	return query, nil
+}
+
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s should be a RFC3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+func parseBoolParam(q url.Values, name string) (*bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s should be either true or false", name)
+	}
+	return &b, nil
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	defaultSSEPageInterval = 2 * time.Second
+	defaultSSEHeartbeat    = 15 * time.Second
+	defaultSSERetry        = 3 * time.Second
+)
+
+// SSEConfig configures the user stream, zero values use the defaults.
+type SSEConfig struct {
+	// PageInterval is the pause between two pages.
+	PageInterval time.Duration
+	// Heartbeat is the interval of the comments keeping the connection open through proxies while waiting.
+	Heartbeat time.Duration
+	// Retry is the reconnection delay suggested to the clients.
+	Retry time.Duration
+}
+
+func (s SSEConfig) withDefaults() SSEConfig {
+	if s.PageInterval <= 0 {
+		s.PageInterval = defaultSSEPageInterval
+	}
+	if s.Heartbeat <= 0 {
+		s.Heartbeat = defaultSSEHeartbeat
+	}
+	if s.Retry <= 0 {
+		s.Retry = defaultSSERetry
+	}
+	return s
+}
+
+// sseWriter writes server-sent events, every write is flushed to the client immediately.
+type sseWriter struct {
+	res     http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (w *sseWriter) flush(s string) error {
+	_, err := fmt.Fprint(w.res, s)
+	if err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+func (w *sseWriter) retry(d time.Duration) error {
+	return w.flush(fmt.Sprintf("retry: %d\n\n", d.Milliseconds()))
+}
+
+func (w *sseWriter) comment(text string) error {
+	return w.flush(": " + text + "\n\n")
+}
+
+// event writes an event, the event name and id are omitted when empty.
+func (w *sseWriter) event(name, id, data string) error {
+	var b strings.Builder
+	if name != "" {
+		b.WriteString("event: " + name + "\n")
+	}
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return w.flush(b.String())
+}
+
+// GetAllUsersSSE streams the user listing page by page, it accepts the parameters of GetAllUsers.
+// Every page is an event whose id is the cursor after its last user, a reconnecting client sends it back as
+// Last-Event-ID and resumes from the next page. The stream ends with an "end" event and stops as soon as the
+// client disconnects.
+func (c *Controller) GetAllUsersSSE(res http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		q.Set("cursor", lastEventID)
+	}
+
+	query, err := parseUserQuery(q)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	// the request context is cancelled when the client disconnects.
+	ctx, err := c.withRole(req.Context(), req)
+	if err != nil {
+		c.sendResponse(res, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	ctx, err = withTimeFormat(ctx, req)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	// flusher to send data immediately to the client using Flush function
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+
+	// Set essential headers
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.WriteHeader(http.StatusOK)
+
+	stream := &sseWriter{res: res, flusher: flusher}
+	err = stream.retry(c.sse.Retry)
+	if err != nil {
+		return
+	}
+
+	for {
+		page, err := c.streamPage(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Error("failed to stream users", zap.Error(err))
+			_ = stream.event("error", "", "failed to get users")
+			return
+		}
+
+		if len(page.Users) > 0 {
+			data, err := json.Marshal(page.Users)
+			if err != nil {
+				c.logger.Error("failed to marshal users", zap.Error(err))
+				_ = stream.event("error", "", "failed to get users")
+				return
+			}
+
+			cursor := models.CursorFor(page.Users[len(page.Users)-1], query.SortBy, query.Descending)
+			err = stream.event("", cursor.Encode(), string(data))
+			if err != nil {
+				return
+			}
+			query.After = cursor
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+
+		err = c.waitNextPage(ctx, stream)
+		if err != nil {
+			return
+		}
+	}
+
+	_ = stream.event("end", "", "END")
+}
+
+func (c *Controller) streamPage(ctx context.Context, query *models.UserQuery) (*models.UserPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	return c.UserService.GetAllUsersSSE(ctx, query)
+}
+
+// waitNextPage waits for the page interval, sending heartbeats meanwhile, it fails when the client disconnects.
+func (c *Controller) waitNextPage(ctx context.Context, stream *sseWriter) error {
+	timer := time.NewTimer(c.sse.PageInterval)
+	defer timer.Stop()
+
+	heartbeat := time.NewTicker(c.sse.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-heartbeat.C:
+			err := stream.comment("heartbeat")
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
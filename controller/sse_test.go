@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+)
+
+// streamService pages through users, the queries it receives are recorded.
+type streamService struct {
+	UserService
+	users   []*models.UserDetails
+	queries []models.UserQuery
+}
+
+func (s *streamService) GetAllUsersSSE(_ context.Context, query *models.UserQuery) (*models.UserPage, error) {
+	s.queries = append(s.queries, *query)
+
+	var users []*models.UserDetails
+	for _, user := range s.users {
+		if query.After == nil || user.ID > query.After.ID {
+			users = append(users, user)
+		}
+	}
+
+	page := &models.UserPage{Users: users}
+	if int64(len(users)) > query.Limit {
+		page.Users = users[:query.Limit]
+		page.NextCursor = models.CursorFor(page.Users[len(page.Users)-1], query.SortBy, query.Descending).Encode()
+	}
+	return page, nil
+}
+
+func TestGetAllUsersSSE(t *testing.T) {
+	users := []*models.UserDetails{{ID: 1}, {ID: 2}, {ID: 3}}
+	cursor := func(id int64) string {
+		return (&models.Cursor{SortBy: models.SortByID, ID: id}).Encode()
+	}
+	sse := SSEConfig{PageInterval: time.Millisecond, Retry: time.Second}
+
+	t.Run("Success: stream every page", func(t *testing.T) {
+		service := &streamService{users: users}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		res := httptest.NewRecorder()
+		c.GetAllUsersSSE(res, httptest.NewRequest(http.MethodGet, "/users/sse?limit=2", nil))
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "text/event-stream", res.Header().Get("Content-Type"))
+		body := res.Body.String()
+		assert.True(t, strings.HasPrefix(body, "retry: 1000\n\n"), body)
+		assert.Contains(t, body, "id: "+cursor(2)+"\ndata: [{\"id\":1,")
+		assert.Contains(t, body, "id: "+cursor(3)+"\ndata: [{\"id\":3,")
+		assert.True(t, strings.HasSuffix(body, "event: end\ndata: END\n\n"), body)
+		assert.Len(t, service.queries, 2)
+	})
+
+	t.Run("Success: resume after the last event", func(t *testing.T) {
+		service := &streamService{users: users}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		req := httptest.NewRequest(http.MethodGet, "/users/sse?limit=2", nil)
+		req.Header.Set("Last-Event-ID", cursor(2))
+		res := httptest.NewRecorder()
+		c.GetAllUsersSSE(res, req)
+
+		assert.Equal(t, int64(2), service.queries[0].After.ID)
+		assert.NotContains(t, res.Body.String(), "\"id\":1,")
+		assert.Contains(t, res.Body.String(), "id: "+cursor(3)+"\n")
+	})
+
+	t.Run("Fail: invalid last event id", func(t *testing.T) {
+		c := New(&streamService{users: users}, nil, nil, nil, models.RoleAdmin, nil, sse, WebSocketConfig{}, zap.NewNop())
+
+		req := httptest.NewRequest(http.MethodGet, "/users/sse", nil)
+		req.Header.Set("Last-Event-ID", "invalid")
+		res := httptest.NewRecorder()
+		c.GetAllUsersSSE(res, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Success: stop when the client disconnects", func(t *testing.T) {
+		service := &streamService{users: users}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{PageInterval: time.Hour, Heartbeat: time.Hour}, WebSocketConfig{}, zap.NewNop())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/users/sse?limit=1", nil).WithContext(ctx)
+		res := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			c.GetAllUsersSSE(res, req)
+			close(done)
+		}()
+
+		// the first page is sent before waiting for the page interval.
+		assert.Eventually(t, func() bool {
+			select {
+			case <-done:
+				return true
+			default:
+				cancel()
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		assert.Len(t, service.queries, 1)
+		assert.NotContains(t, res.Body.String(), "event: end")
+	})
+}
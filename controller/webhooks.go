@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+var (
+	defaultDeliveriesLimit int64 = 50
+	maxDeliveriesLimit     int64 = 500
+)
+
+type WebhookService interface {
+	CreateWebhook(context.Context, *models.Webhook) (*models.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (*models.Webhook, error)
+	ListWebhooks(context.Context) ([]*models.Webhook, error)
+	UpdateWebhook(context.Context, *models.Webhook) (*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	ListDeliveries(ctx context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error)
+}
+
+// webhookRequest is the body of the requests creating or updating a webhook.
+type webhookRequest struct {
+	URL        string             `json:"url"`
+	EventTypes []models.EventType `json:"event_types"`
+	// Secret is only accepted on creation, it is generated when empty.
+	Secret string `json:"secret"`
+	// Enabled is only used on update, it defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+func (c *Controller) webhookID(res http.ResponseWriter, req *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid webhook id, please check url. it should be /webhooks/:id", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+func (c *Controller) decodeWebhook(res http.ResponseWriter, req *http.Request) (*models.Webhook, bool) {
+	defer req.Body.Close()
+
+	var body webhookRequest
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		c.sendResponse(res, http.StatusBadRequest, "invalid request body", nil)
+		return nil, false
+	}
+
+	webhook := &models.Webhook{URL: body.URL, EventTypes: body.EventTypes, Secret: body.Secret, Enabled: true}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []models.EventType{}
+	}
+	if body.Enabled != nil {
+		webhook.Enabled = *body.Enabled
+	}
+	return webhook, true
+}
+
+// webhookError responds to the errors of the webhook service.
+func (c *Controller) webhookError(res http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidWebhookURL), errors.Is(err, models.ErrPrivateWebhookURL), errors.Is(err, models.ErrInvalidEventType):
+		c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, database.ErrNoData):
+		c.sendResponse(res, http.StatusNotFound, "requested webhook not found", nil)
+	case errors.Is(err, context.DeadlineExceeded):
+		c.sendResponse(res, http.StatusRequestTimeout, "request time out please try again later", nil)
+	default:
+		c.logger.Error(message, zap.Error(err))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+	}
+}
+
+// CreateWebhook registers a webhook, the response is the only one containing its secret.
+func (c *Controller) CreateWebhook(res http.ResponseWriter, req *http.Request) {
+	webhook, ok := c.decodeWebhook(res, req)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	webhook, err := c.Webhooks.CreateWebhook(ctx, webhook)
+	if err != nil {
+		c.webhookError(res, err, "failed to create webhook")
+		return
+	}
+
+	c.sendResponse(res, http.StatusCreated, "webhook created, keep its secret to verify the signatures", webhook)
+}
+
+func (c *Controller) ListWebhooks(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	webhooks, err := c.Webhooks.ListWebhooks(ctx)
+	if err != nil {
+		c.webhookError(res, err, "failed to list webhooks")
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "all webhooks", webhooks)
+}
+
+func (c *Controller) GetWebhook(res http.ResponseWriter, req *http.Request) {
+	id, ok := c.webhookID(res, req)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	webhook, err := c.Webhooks.GetWebhook(ctx, id)
+	if err != nil {
+		c.webhookError(res, err, "failed to get webhook")
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "success", webhook)
+}
+
+// UpdateWebhook replaces the url, event types and state of the webhook, enabling a disabled webhook resumes its
+// deliveries.
+func (c *Controller) UpdateWebhook(res http.ResponseWriter, req *http.Request) {
+	id, ok := c.webhookID(res, req)
+	if !ok {
+		return
+	}
+
+	webhook, ok := c.decodeWebhook(res, req)
+	if !ok {
+		return
+	}
+	webhook.ID = id
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	webhook, err := c.Webhooks.UpdateWebhook(ctx, webhook)
+	if err != nil {
+		c.webhookError(res, err, "failed to update webhook")
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "webhook updated", webhook)
+}
+
+func (c *Controller) DeleteWebhook(res http.ResponseWriter, req *http.Request) {
+	id, ok := c.webhookID(res, req)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	err := c.Webhooks.DeleteWebhook(ctx, id)
+	if err != nil {
+		c.webhookError(res, err, "failed to delete webhook")
+		return
+	}
+
+	c.sendResponse(res, http.StatusNoContent, "success", nil)
+}
+
+// ListWebhookDeliveries returns the delivery log of the webhook, most recent first, limit defaults to 50.
+func (c *Controller) ListWebhookDeliveries(res http.ResponseWriter, req *http.Request) {
+	id, ok := c.webhookID(res, req)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if v := req.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			c.sendResponse(res, http.StatusBadRequest, "invalid limit, it should be a positive integer", nil)
+			return
+		}
+		limit = min(parsed, maxDeliveriesLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	deliveries, err := c.Webhooks.ListDeliveries(ctx, id, limit)
+	if err != nil {
+		c.webhookError(res, err, "failed to list webhook deliveries")
+		return
+	}
+
+	c.sendResponse(res, http.StatusOK, "webhook deliveries", deliveries)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+)
+
+// webhookService validates the webhooks it creates and knows the webhook 1 only.
+type webhookService struct {
+	WebhookService
+	limit int64
+}
+
+func (s *webhookService) CreateWebhook(_ context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	err := webhook.Validate()
+	if err != nil {
+		return nil, err
+	}
+	webhook.ID, webhook.Secret = 1, "secret"
+	return webhook, nil
+}
+
+func (s *webhookService) ListDeliveries(_ context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error) {
+	if webhookID != 1 {
+		return nil, database.ErrNoData
+	}
+	s.limit = limit
+	return []*models.WebhookDelivery{}, nil
+}
+
+func TestCreateWebhook(t *testing.T) {
+	testCases := map[string]struct {
+		body   string
+		status int
+	}{
+		"Success: webhook created with its secret": {body: `{"url":"https://partner.example.com/users","event_types":["created"]}`, status: http.StatusCreated},
+		"Fail: invalid body":                       {body: `{"url":`, status: http.StatusBadRequest},
+		"Fail: invalid url":                        {body: `{"url":"partner.example.com"}`, status: http.StatusBadRequest},
+		"Fail: invalid event type":                 {body: `{"url":"https://partner.example.com","event_types":["renamed"]}`, status: http.StatusBadRequest},
+		"Fail: private url":                        {body: `{"url":"http://169.254.169.254/latest/meta-data"}`, status: http.StatusBadRequest},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := New(nil, &webhookService{}, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			res := httptest.NewRecorder()
+			c.CreateWebhook(res, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.status, res.Code)
+
+			if tc.status == http.StatusCreated {
+				var body struct {
+					Data models.Webhook `json:"data"`
+				}
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, "secret", body.Data.Secret)
+				assert.True(t, body.Data.Enabled)
+			}
+		})
+	}
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	testCases := map[string]struct {
+		id     string
+		query  string
+		status int
+		limit  int64
+	}{
+		"Success: default limit":   {id: "1", status: http.StatusOK, limit: defaultDeliveriesLimit},
+		"Success: limit is capped": {id: "1", query: "?limit=100000", status: http.StatusOK, limit: maxDeliveriesLimit},
+		"Fail: invalid limit":      {id: "1", query: "?limit=-1", status: http.StatusBadRequest},
+		"Fail: invalid webhook id": {id: "one", status: http.StatusBadRequest},
+		"Fail: webhook not found":  {id: "2", status: http.StatusNotFound},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &webhookService{}
+			c := New(nil, service, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			req := httptest.NewRequest(http.MethodGet, "/webhooks/"+tc.id+"/deliveries"+tc.query, nil)
+			req.SetPathValue("id", tc.id)
+			res := httptest.NewRecorder()
+			c.ListWebhookDeliveries(res, req)
+			assert.Equal(t, tc.status, res.Code)
+			assert.Equal(t, tc.limit, service.limit)
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	defaultWSPingInterval     = 30 * time.Second
+	defaultWSWriteTimeout     = 10 * time.Second
+	defaultWSSendBuffer       = 256
+	defaultWSMaxSubscriptions = 100
+	defaultWSMaxMessageBytes  = int64(4096)
+
+	errSlowConsumer = errors.New("send buffer full, reconnect with after set to the seq of the last event received")
+	errEventsFailed = errors.New("failed to get user events")
+)
+
+// WebSocketConfig configures the user subscriptions, zero values use the defaults.
+type WebSocketConfig struct {
+	// PingInterval is the interval of the pings, a connection is closed when no pong is received for two intervals.
+	PingInterval time.Duration
+	WriteTimeout time.Duration
+	// SendBuffer is the number of messages queued for a connection, a client reading slower than the events are
+	// written is disconnected when it is full.
+	SendBuffer int
+	// MaxSubscriptions is the number of subscriptions of a connection.
+	MaxSubscriptions int
+	// MaxMessageBytes is the size limit of the messages of the clients.
+	MaxMessageBytes int64
+}
+
+func (w WebSocketConfig) withDefaults() WebSocketConfig {
+	if w.PingInterval <= 0 {
+		w.PingInterval = defaultWSPingInterval
+	}
+	if w.WriteTimeout <= 0 {
+		w.WriteTimeout = defaultWSWriteTimeout
+	}
+	if w.SendBuffer <= 0 {
+		w.SendBuffer = defaultWSSendBuffer
+	}
+	if w.MaxSubscriptions <= 0 {
+		w.MaxSubscriptions = defaultWSMaxSubscriptions
+	}
+	if w.MaxMessageBytes <= 0 {
+		w.MaxMessageBytes = defaultWSMaxMessageBytes
+	}
+	return w
+}
+
+// upgrader accepts every origin like the SSE endpoints, the events don't contain personal data and the callers
+// are not authenticated with cookies.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+const (
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+// wsRequest is a message of the client, e.g. {"action":"subscribe","id":"vip","user_ids":[1,2],"types":["deleted"]}.
+type wsRequest struct {
+	Action string `json:"action"`
+	// ID names the subscription, subscribing again with the same id replaces it.
+	ID string `json:"id"`
+	models.EventFilter
+}
+
+// wsMessage is a message to the client, Type is subscribed, unsubscribed, event or error.
+type wsMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// Subscriptions are the ids of the subscriptions matching the event.
+	Subscriptions []string          `json:"subscriptions,omitempty"`
+	Event         *models.UserEvent `json:"event,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// subscriber is a websocket connection, its messages are queued in send and written by a single goroutine.
+type subscriber struct {
+	conn   *websocket.Conn
+	config WebSocketConfig
+	send   chan *wsMessage
+	cancel context.CancelCauseFunc
+
+	mu            sync.Mutex
+	subscriptions map[string]models.EventFilter
+	// subscribed is closed by the first subscription, the events are read from then on.
+	subscribed     chan struct{}
+	subscribedOnce sync.Once
+}
+
+// enqueue queues the message, the connection is closed when the client doesn't read fast enough.
+func (s *subscriber) enqueue(message *wsMessage) bool {
+	select {
+	case s.send <- message:
+		return true
+	default:
+		s.cancel(errSlowConsumer)
+		return false
+	}
+}
+
+func (s *subscriber) handle(request *wsRequest) *wsMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch request.Action {
+	case actionSubscribe:
+		if request.ID == "" {
+			return &wsMessage{Type: "error", Error: "subscription id is required"}
+		}
+		err := request.EventFilter.Validate()
+		if err != nil {
+			return &wsMessage{Type: "error", ID: request.ID, Error: err.Error()}
+		}
+		_, replaced := s.subscriptions[request.ID]
+		if !replaced && len(s.subscriptions) >= s.config.MaxSubscriptions {
+			return &wsMessage{Type: "error", ID: request.ID, Error: "too many subscriptions"}
+		}
+		s.subscriptions[request.ID] = request.EventFilter
+		s.subscribedOnce.Do(func() { close(s.subscribed) })
+		return &wsMessage{Type: "subscribed", ID: request.ID}
+	case actionUnsubscribe:
+		delete(s.subscriptions, request.ID)
+		return &wsMessage{Type: "unsubscribed", ID: request.ID}
+	default:
+		return &wsMessage{Type: "error", ID: request.ID, Error: "unknown action, it should be subscribe or unsubscribe"}
+	}
+}
+
+// matching returns the ids of the subscriptions matching the event.
+func (s *subscriber) matching(event *models.UserEvent) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, filter := range s.subscriptions {
+		if filter.Matches(event) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// matches reports whether a subscription matches the event.
+func (s *subscriber) matches(event *models.UserEvent) bool {
+	return len(s.matching(event)) > 0
+}
+
+// readMessages handles the messages of the client until the connection is closed.
+func (s *subscriber) readMessages() {
+	pongWait := 2 * s.config.PingInterval
+	s.conn.SetReadLimit(s.config.MaxMessageBytes)
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.cancel(err)
+			return
+		}
+
+		var request wsRequest
+		err = json.Unmarshal(data, &request)
+		if err != nil {
+			if !s.enqueue(&wsMessage{Type: "error", Error: "invalid message"}) {
+				return
+			}
+			continue
+		}
+
+		if !s.enqueue(s.handle(&request)) {
+			return
+		}
+	}
+}
+
+// writeMessages writes the queued messages and the pings until ctx is done, then closes the connection.
+func (s *subscriber) writeMessages(ctx context.Context) {
+	defer s.conn.Close()
+
+	ping := time.NewTicker(s.config.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.close(context.Cause(ctx))
+			return
+		case message := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+			err := s.conn.WriteJSON(message)
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.config.WriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close sends the close frame telling the client why the connection is closed.
+func (s *subscriber) close(cause error) {
+	code, text := websocket.CloseNormalClosure, ""
+	switch {
+	case errors.Is(cause, errSlowConsumer):
+		code, text = websocket.ClosePolicyViolation, cause.Error()
+	case errors.Is(cause, errEventsFailed):
+		code, text = websocket.CloseInternalServerErr, cause.Error()
+	}
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(s.config.WriteTimeout))
+}
+
+// SubscribeUsers upgrades to a websocket on which the client subscribes to the changes of the users, see wsRequest,
+// and receives the matching events of the change feed. A client reconnecting with after set to the seq of the
+// last event received gets the events it missed, only the new events are sent otherwise.
+func (c *Controller) SubscribeUsers(res http.ResponseWriter, req *http.Request) {
+	after := req.URL.Query().Get("after")
+	if after != "" {
+		_, err := models.ParseEventSeq(after)
+		if err != nil {
+			c.sendResponse(res, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+
+	s := &subscriber{
+		config:        c.ws,
+		send:          make(chan *wsMessage, c.ws.SendBuffer),
+		cancel:        cancel,
+		subscriptions: make(map[string]models.EventFilter),
+		subscribed:    make(chan struct{}),
+	}
+
+	// the position of a client without sequence number is resolved before upgrading, the events are filtered by
+	// the subscriptions as they are received.
+	subscription, err := c.UserService.SubscribeEvents(ctx, after, s.matches)
+	if err != nil {
+		if errors.Is(err, services.ErrEventsDisabled) {
+			c.sendResponse(res, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		c.logger.Error("failed to read user events", zap.Error(err))
+		c.sendResponse(res, http.StatusInternalServerError, "internal server error", nil)
+		return
+	}
+	defer subscription.Close()
+
+	s.conn, err = upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		// the upgrader has already responded.
+		return
+	}
+
+	go s.readMessages()
+	go c.pumpEvents(ctx, s, subscription)
+	s.writeMessages(ctx)
+}
+
+// pumpEvents queues the events matching the subscriptions until ctx is done, it starts with the first
+// subscription so a resuming client gets the events it missed once it has subscribed again.
+func (c *Controller) pumpEvents(ctx context.Context, s *subscriber, subscription services.EventSubscription) {
+	select {
+	case <-s.subscribed:
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		events, err := subscription.Next(ctx, eventsBatchSize, c.ws.PingInterval)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("failed to read user events", zap.Error(err))
+				s.cancel(errEventsFailed)
+			}
+			return
+		}
+
+		for _, event := range events {
+			// the subscriptions may have changed since the event was received.
+			ids := s.matching(event)
+			if len(ids) > 0 && !s.enqueue(&wsMessage{Type: "event", Subscriptions: ids, Event: event}) {
+				return
+			}
+		}
+	}
+}
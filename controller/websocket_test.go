@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/core/services"
+	"go.uber.org/zap"
+)
+
+// feedService subscribes to the batches sent on its channel.
+type feedService struct {
+	UserService
+	batches chan []*models.UserEvent
+}
+
+func (s *feedService) SubscribeEvents(context.Context, string, func(*models.UserEvent) bool) (services.EventSubscription, error) {
+	return s, nil
+}
+
+func (s *feedService) Next(ctx context.Context, _ int64, _ time.Duration) ([]*models.UserEvent, error) {
+	select {
+	case events := <-s.batches:
+		return events, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *feedService) Close() {}
+
+func dial(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+	assert.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	return conn
+}
+
+func TestSubscribeUsers(t *testing.T) {
+	at := time.UnixMilli(1709296200000).UTC()
+	created := &models.UserEvent{Seq: "1709296200000-0", Type: models.EventCreated, UserID: 1, At: at}
+	other := &models.UserEvent{Seq: "1709296200000-1", Type: models.EventCreated, UserID: 2, At: at}
+	deleted := &models.UserEvent{Seq: "1709296200000-2", Type: models.EventDeleted, UserID: 1, At: at}
+
+	t.Run("Success: receive the events of the subscriptions", func(t *testing.T) {
+		service := &feedService{batches: make(chan []*models.UserEvent, 2)}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{PingInterval: 20 * time.Millisecond}, zap.NewNop())
+		server := httptest.NewServer(http.HandlerFunc(c.SubscribeUsers))
+		defer server.Close()
+
+		// the missed events are sent once the client has subscribed again.
+		service.batches <- []*models.UserEvent{created}
+		conn := dial(t, server, "?after=1709296100000-0")
+		defer conn.Close()
+
+		pinged := make(chan struct{}, 1)
+		conn.SetPingHandler(func(data string) error {
+			select {
+			case pinged <- struct{}{}:
+			default:
+			}
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+
+		var message wsMessage
+		assert.NoError(t, conn.WriteJSON(map[string]any{"action": "subscribe", "id": "user-1", "user_ids": []int64{1}}))
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, wsMessage{Type: "subscribed", ID: "user-1"}, message)
+
+		message = wsMessage{}
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, wsMessage{Type: "event", Subscriptions: []string{"user-1"}, Event: created}, message)
+
+		assert.NoError(t, conn.WriteJSON(map[string]any{"action": "subscribe", "id": "deleted", "types": []string{"deleted"}}))
+		message = wsMessage{}
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, wsMessage{Type: "subscribed", ID: "deleted"}, message)
+
+		service.batches <- []*models.UserEvent{other, deleted}
+		message = wsMessage{}
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, deleted, message.Event)
+		assert.ElementsMatch(t, []string{"user-1", "deleted"}, message.Subscriptions)
+
+		for _, request := range []map[string]any{
+			{"action": "subscribe", "id": "invalid", "types": []string{"renamed"}},
+			{"action": "subscribe"},
+			{"action": "rename"},
+		} {
+			assert.NoError(t, conn.WriteJSON(request))
+			message = wsMessage{}
+			assert.NoError(t, conn.ReadJSON(&message))
+			assert.Equal(t, "error", message.Type, request)
+		}
+
+		assert.NoError(t, conn.WriteJSON(map[string]any{"action": "unsubscribe", "id": "user-1"}))
+		message = wsMessage{}
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, wsMessage{Type: "unsubscribed", ID: "user-1"}, message)
+
+		// pings are handled while reading, nothing else is sent.
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _, err := conn.ReadMessage()
+		assert.Error(t, err)
+		assert.Len(t, pinged, 1)
+	})
+
+	t.Run("Fail: too many subscriptions", func(t *testing.T) {
+		service := &feedService{batches: make(chan []*models.UserEvent, 1)}
+		c := New(service, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{MaxSubscriptions: 1}, zap.NewNop())
+		server := httptest.NewServer(http.HandlerFunc(c.SubscribeUsers))
+		defer server.Close()
+
+		conn := dial(t, server, "")
+		defer conn.Close()
+
+		var message wsMessage
+		for _, id := range []string{"a", "a", "b"} {
+			assert.NoError(t, conn.WriteJSON(map[string]any{"action": "subscribe", "id": id}))
+			message = wsMessage{}
+			assert.NoError(t, conn.ReadJSON(&message))
+		}
+		assert.Equal(t, wsMessage{Type: "error", ID: "b", Error: "too many subscriptions"}, message)
+	})
+
+	t.Run("Fail: slow consumer is disconnected", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		s := &subscriber{send: make(chan *wsMessage, 1), cancel: cancel}
+
+		assert.True(t, s.enqueue(&wsMessage{Type: "event"}))
+		assert.False(t, s.enqueue(&wsMessage{Type: "event"}))
+		assert.ErrorIs(t, context.Cause(ctx), errSlowConsumer)
+	})
+
+	for name, tc := range map[string]struct {
+		err    error
+		query  string
+		status int
+	}{
+		"Fail: invalid sequence number": {query: "?after=latest", status: http.StatusBadRequest},
+		"Fail: change feed disabled":    {err: services.ErrEventsDisabled, status: http.StatusNotFound},
+		"Fail: change feed unavailable": {err: errors.New("test error"), status: http.StatusInternalServerError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := New(&eventService{err: tc.err}, nil, nil, nil, models.RoleAdmin, nil, SSEConfig{}, WebSocketConfig{}, zap.NewNop())
+			server := httptest.NewServer(http.HandlerFunc(c.SubscribeUsers))
+			defer server.Close()
+
+			_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+tc.query, nil)
+			assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+			assert.Equal(t, tc.status, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidUserID = errors.New("invalid user id, it should be a positive integer")
+	ErrInvalidEmail  = errors.New("invalid email address")
+)
+
+// Validate checks the fields of a user created through the API.
+func (u *UserDetails) Validate() error {
+	if u.ID <= 0 {
+		return ErrInvalidUserID
+	}
+
+	if u.EmailAddress != "" {
+		local, domain, ok := strings.Cut(u.EmailAddress, "@")
+		if !ok || local == "" || domain == "" {
+			return ErrInvalidEmail
+		}
+	}
+	return nil
+}
+
+// CreateStatus is the outcome of the creation of a user of a batch.
+type CreateStatus string
+
+const (
+	CreateCreated   CreateStatus = "created"
+	CreateDuplicate CreateStatus = "duplicate"
+	CreateInvalid   CreateStatus = "invalid"
+	// CreateFailed is returned for unexpected errors, e.g. timeouts, the user can be sent again.
+	CreateFailed CreateStatus = "failed"
+)
+
+// CreateResult is the outcome of the user at Index of a batch.
+type CreateResult struct {
+	Index  int          `json:"index"`
+	ID     int64        `json:"id,omitempty"`
+	Status CreateStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// UserLookup is the result of a lookup of many users, the users are in the order of the requested ids.
+type UserLookup struct {
+	Users    []*UserDetails
+	NotFound []int64
+	// Erased are the users whose personal data has been erased.
+	Erased []int64
+}
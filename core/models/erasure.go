@@ -0,0 +1,17 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrUserErased = errors.New("personal data of the user has been erased")
+
+// ErasureRecord is the audit record of an erasure request, it proves when the personal data of the user
+// was erased and whether the user key encrypting it was destroyed.
+type ErasureRecord struct {
+	ID           int64     `json:"id" db:"id"`
+	UserID       int64     `json:"user_id" db:"user_id"`
+	ErasedAt     time.Time `json:"erased_at" db:"erased_at"`
+	KeyDestroyed bool      `json:"key_destroyed" db:"key_destroyed"`
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidEventType = errors.New("invalid event type, it should be created, deleted, merged or erased")
+	ErrInvalidEventSeq  = errors.New("invalid event sequence number")
+)
+
+// EventType is the kind of change of a user in the change feed.
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventDeleted EventType = "deleted"
+	// EventMerged is sent with EventCreated when the user created is already merged into another user.
+	EventMerged EventType = "merged"
+	// EventErased is sent when the personal data of a user is crypto-shredded, see UserService.EraseUser.
+	EventErased EventType = "erased"
+)
+
+func (t EventType) Valid() bool {
+	switch t {
+	case EventCreated, EventDeleted, EventMerged, EventErased:
+		return true
+	}
+	return false
+}
+
+// ParseEventTypes parses a comma separated list of event types, an empty list selects every type.
+func ParseEventTypes(s string) ([]EventType, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var types []EventType
+	for _, name := range strings.Split(s, ",") {
+		eventType := EventType(strings.TrimSpace(name))
+		if !eventType.Valid() {
+			return nil, ErrInvalidEventType
+		}
+		types = append(types, eventType)
+	}
+	return types, nil
+}
+
+// UserEvent is a change of a user, it doesn't contain personal data, the user is read with GET /users/{id}.
+type UserEvent struct {
+	// Seq orders the events, e.g. "1709296200000-0", it is the time of the event in milliseconds since
+	// the unix epoch and a counter of the events of that millisecond.
+	Seq    string    `json:"seq"`
+	Type   EventType `json:"type"`
+	UserID int64     `json:"user_id"`
+	At     time.Time `json:"at"`
+}
+
+// ParseEventSeq checks the sequence number and returns the time of the event.
+func ParseEventSeq(seq string) (time.Time, error) {
+	ms, _, err := splitEventSeq(seq)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(ms)).UTC(), nil
+}
+
+// EventSeqAfter reports whether the event seq is after the event after, an invalid sequence number is before
+// every valid one.
+func EventSeqAfter(seq, after string) bool {
+	ms, counter, err := splitEventSeq(seq)
+	if err != nil {
+		return false
+	}
+	afterMs, afterCounter, err := splitEventSeq(after)
+	if err != nil {
+		return true
+	}
+	return ms > afterMs || ms == afterMs && counter > afterCounter
+}
+
+func splitEventSeq(seq string) (ms uint64, counter uint64, err error) {
+	millis, count, found := strings.Cut(seq, "-")
+	ms, err = strconv.ParseUint(millis, 10, 63)
+	if err != nil {
+		return 0, 0, ErrInvalidEventSeq
+	}
+	if found {
+		counter, err = strconv.ParseUint(count, 10, 64)
+		if err != nil {
+			return 0, 0, ErrInvalidEventSeq
+		}
+	}
+	return ms, counter, nil
+}
+
+// EventFilter selects the events of some users and types, every user or type is selected when its list is empty.
+type EventFilter struct {
+	UserIDs []int64     `json:"user_ids,omitempty"`
+	Types   []EventType `json:"types,omitempty"`
+}
+
+func (f *EventFilter) Validate() error {
+	for _, t := range f.Types {
+		if !t.Valid() {
+			return ErrInvalidEventType
+		}
+	}
+	return nil
+}
+
+func (f *EventFilter) Matches(event *UserEvent) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+	return len(f.UserIDs) == 0 || slices.Contains(f.UserIDs, event.UserID)
+}
+
+// OutboxEvent is a change of a user written in the same transaction as the change, it is published to the
+// message broker by the outbox relay. ID increases with the order of the changes.
+type OutboxEvent struct {
+	ID     int64     `json:"id"`
+	Type   EventType `json:"type"`
+	UserID int64     `json:"user_id"`
+	At     time.Time `json:"at"`
+}
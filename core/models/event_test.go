@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes("")
+	assert.NoError(t, err)
+	assert.Nil(t, types)
+
+	types, err = ParseEventTypes("created, erased")
+	assert.NoError(t, err)
+	assert.Equal(t, []EventType{EventCreated, EventErased}, types)
+
+	_, err = ParseEventTypes("created,renamed")
+	assert.ErrorIs(t, err, ErrInvalidEventType)
+}
+
+func TestParseEventSeq(t *testing.T) {
+	for _, seq := range []string{"1709296200000-0", "1709296200000"} {
+		at, err := ParseEventSeq(seq)
+		assert.NoError(t, err, seq)
+		assert.Equal(t, time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), at, seq)
+	}
+
+	for _, seq := range []string{"", "latest", "-1", "1709296200000-", "1709296200000-x"} {
+		_, err := ParseEventSeq(seq)
+		assert.ErrorIs(t, err, ErrInvalidEventSeq, seq)
+	}
+}
+
+func TestEventSeqAfter(t *testing.T) {
+	assert.True(t, EventSeqAfter("1709296200000-1", "1709296200000-0"))
+	assert.True(t, EventSeqAfter("1709296200001-0", "1709296200000-5"))
+	assert.True(t, EventSeqAfter("1709296200000-0", "0-0"))
+	assert.False(t, EventSeqAfter("1709296200000-0", "1709296200000-0"))
+	assert.False(t, EventSeqAfter("1709296200000-0", "1709296200000-1"))
+	assert.False(t, EventSeqAfter("latest", "0-0"))
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is the result of a request sent with an idempotency key, it is replayed to the retries of the
+// request until it expires.
+type IdempotencyRecord struct {
+	// Scope is the endpoint of the request, e.g. "POST /users", the same key can be used by every endpoint.
+	Scope string
+	Key   string
+	// RequestHash identifies the request, a retry with another hash is rejected.
+	RequestHash string
+	// StatusCode and Response are unset while the request is in progress.
+	StatusCode int
+	Response   []byte
+	ExpiresAt  time.Time
+	// LeaseExpiresAt ends the reservation of a request in progress, a retry takes the key over afterwards as the
+	// request holding it crashed without releasing it.
+	LeaseExpiresAt time.Time
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotency adds the record to store with the write of the request, its response is then replayed when the
+// write is committed even if the request fails afterwards, e.g. on a timeout.
+func WithIdempotency(ctx context.Context, record *IdempotencyRecord) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, record)
+}
+
+// IdempotencyFromContext returns the record to store with the write, nil when the request has no idempotency key.
+func IdempotencyFromContext(ctx context.Context) *IdempotencyRecord {
+	record, _ := ctx.Value(idempotencyKey{}).(*IdempotencyRecord)
+	return record
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// ImportStatus is the state of an import of a csv file.
+type ImportStatus string
+
+const (
+	// ImportRunning is publishing the rows of the file.
+	ImportRunning ImportStatus = "running"
+	// ImportPublished has published every row, the consumers are storing the users.
+	ImportPublished ImportStatus = "published"
+	// ImportCompleted has every published user consumed.
+	ImportCompleted ImportStatus = "completed"
+	// ImportFailed has stopped publishing on an error, the users published before are still consumed.
+	ImportFailed ImportStatus = "failed"
+)
+
+// Import is a run of the producer or an upload to POST /imports. The rows read are either published or failures,
+// the users consumed are either stored, duplicates or failures.
+type Import struct {
+	ID         string       `json:"id"`
+	Source     string       `json:"source"`
+	Status     ImportStatus `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt NullTime     `json:"finished_at"`
+	ImportProgress
+}
+
+// ImportProgress counts the rows of an import, the counts of the producer and the consumers are added up.
+type ImportProgress struct {
+	RowsRead   int64 `json:"rows_read"`
+	Published  int64 `json:"published"`
+	Consumed   int64 `json:"consumed"`
+	Stored     int64 `json:"stored"`
+	Duplicates int64 `json:"duplicates"`
+	Failures   int64 `json:"failures"`
+}
+
+// UserBatch is a message of the ingestion queue, RunID is the id of the import of the users, empty when the import
+// is not tracked.
+type UserBatch struct {
+	RunID string         `json:"run_id,omitempty"`
+	Users []*UserDetails `json:"users"`
+}
@@ -1,16 +1,29 @@
 package models
 
-import (
-	"database/sql"
-)
-
+// fields tagged with pii are encrypted before being stored or cached, see encryptionutils.FieldCipher.
 type UserDetails struct {
-	ID           int64        `json:"id" db:"id"`
-	FirstName    string       `json:"first_name" db:"first_name"`
-	LastName     string       `json:"last_name" db:"last_name"`
-	EmailAddress string       `json:"email_address" db:"email_address"`
-	CreatedAt    sql.NullTime `json:"created_at" db:"created_at"`
-	DeletedAt    sql.NullTime `json:"deleted_at" db:"deleted_at"`
-	MergedAt     sql.NullTime `json:"merged_at" db:"merged_at"`
-	ParentUserId int64        `json:"parent_user_id" db:"parent_user_id"`
+	ID           int64    `json:"id" db:"id"`
+	FirstName    string   `json:"first_name" db:"first_name" pii:"encrypt,legacy=plaintext"`
+	LastName     string   `json:"last_name" db:"last_name" pii:"encrypt,legacy=plaintext"`
+	EmailAddress string   `json:"email_address" db:"email_address" pii:"encrypt,index=EmailIndex,normalize=email"`
+	CreatedAt    NullTime `json:"created_at" db:"created_at"`
+	DeletedAt    NullTime `json:"deleted_at" db:"deleted_at"`
+	MergedAt     NullTime `json:"merged_at" db:"merged_at"`
+	ParentUserId int64    `json:"parent_user_id" db:"parent_user_id"`
+	// EmailIndex is the blind index of the email address, it is never exposed.
+	EmailIndex string `json:"-" db:"email_index"`
+	// ErasedAt is set when the personal data of the user has been erased, only the tombstone is left.
+	ErasedAt NullTime `json:"-" db:"erased_at"`
+}
+
+// Merged reports whether the user has been merged into another user.
+func (u *UserDetails) Merged() bool {
+	return u.MergedAt.Valid || u.ParentUserId > 0
+}
+
+// SetTimeFormat changes how the timestamps of the user are written in JSON.
+func (u *UserDetails) SetTimeFormat(format TimeFormat) {
+	u.CreatedAt = u.CreatedAt.In(format)
+	u.DeletedAt = u.DeletedAt.In(format)
+	u.MergedAt = u.MergedAt.In(format)
 }
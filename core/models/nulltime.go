@@ -0,0 +1,136 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidTimeFormat = errors.New("invalid time format, it should be rfc3339 or epoch_millis")
+
+// TimeFormat is how the timestamps are written in JSON.
+type TimeFormat string
+
+const (
+	// TimeFormatRFC3339 writes timestamps as RFC3339 strings, e.g. "2024-03-01T12:30:00Z", it is the default.
+	TimeFormatRFC3339 TimeFormat = "rfc3339"
+	// TimeFormatEpochMillis writes timestamps as milliseconds since the unix epoch.
+	TimeFormatEpochMillis TimeFormat = "epoch_millis"
+)
+
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	switch TimeFormat(s) {
+	case "", TimeFormatRFC3339:
+		return TimeFormatRFC3339, nil
+	case TimeFormatEpochMillis:
+		return TimeFormatEpochMillis, nil
+	default:
+		return "", ErrInvalidTimeFormat
+	}
+}
+
+type timeFormatKey struct{}
+
+func WithTimeFormat(ctx context.Context, format TimeFormat) context.Context {
+	return context.WithValue(ctx, timeFormatKey{}, format)
+}
+
+// TimeFormatFromContext returns the time format requested by the caller, RFC3339 by default.
+func TimeFormatFromContext(ctx context.Context) TimeFormat {
+	format, ok := ctx.Value(timeFormatKey{}).(TimeFormat)
+	if !ok {
+		return TimeFormatRFC3339
+	}
+	return format
+}
+
+// NullTime is a nullable timestamp, it is written in JSON as an RFC3339 string or null.
+// The {"Time":...,"Valid":...} objects written by sql.NullTime are still read.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+	// epochMillis writes the timestamp as milliseconds since the unix epoch, see In.
+	epochMillis bool
+}
+
+func NewNullTime(t time.Time) NullTime {
+	return NullTime{Time: t, Valid: true}
+}
+
+// In returns the timestamp written in JSON with the format.
+func (t NullTime) In(format TimeFormat) NullTime {
+	t.epochMillis = format == TimeFormatEpochMillis
+	return t
+}
+
+func (t NullTime) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	if t.epochMillis {
+		return strconv.AppendInt(nil, t.Time.UnixMilli(), 10), nil
+	}
+	return json.Marshal(t.Time.Format(time.RFC3339Nano))
+}
+
+// UnmarshalJSON reads null, an RFC3339 string, milliseconds since the unix epoch or a sql.NullTime object.
+func (t *NullTime) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*t = NullTime{}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if s == "" {
+			*t = NullTime{}
+			return nil
+		}
+
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q, it should be RFC3339: %w", s, err)
+		}
+		*t = NewNullTime(parsed)
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var legacy sql.NullTime
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		*t = NullTime{Time: legacy.Time, Valid: legacy.Valid}
+		return nil
+	default:
+		millis, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %s, it should be RFC3339 or epoch milliseconds", data)
+		}
+		*t = NewNullTime(time.UnixMilli(millis))
+		return nil
+	}
+}
+
+func (t *NullTime) Scan(value any) error {
+	var scanned sql.NullTime
+	if err := scanned.Scan(value); err != nil {
+		return err
+	}
+	*t = NullTime{Time: scanned.Time, Valid: scanned.Valid}
+	return nil
+}
+
+func (t NullTime) Value() (driver.Value, error) {
+	if !t.Valid {
+		return nil, nil
+	}
+	return t.Time, nil
+}
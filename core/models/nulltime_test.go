@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNullTimeJSON(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	t.Run("Success: marshal", func(t *testing.T) {
+		data, err := json.Marshal(struct {
+			Created NullTime `json:"created"`
+			Deleted NullTime `json:"deleted"`
+			Millis  NullTime `json:"millis"`
+		}{
+			Created: NewNullTime(at),
+			Millis:  NewNullTime(at).In(TimeFormatEpochMillis),
+		})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"created":"2024-03-01T12:30:00Z","deleted":null,"millis":1709296200000}`, string(data))
+	})
+
+	t.Run("Success: unmarshal", func(t *testing.T) {
+		for input, expected := range map[string]NullTime{
+			`"2024-03-01T12:30:00Z"`: NewNullTime(at),
+			`1709296200000`:          NewNullTime(at),
+			`null`:                   {},
+			`""`:                     {},
+			`{"Time":"2024-03-01T12:30:00Z","Valid":true}`:  NewNullTime(at),
+			`{"Time":"0001-01-01T00:00:00Z","Valid":false}`: {},
+		} {
+			var parsed NullTime
+			assert.NoError(t, json.Unmarshal([]byte(input), &parsed), input)
+			assert.Equal(t, expected.Valid, parsed.Valid, input)
+			assert.True(t, expected.Time.Equal(parsed.Time), input)
+		}
+	})
+
+	t.Run("Fail: unmarshal", func(t *testing.T) {
+		var parsed NullTime
+		assert.Error(t, json.Unmarshal([]byte(`"yesterday"`), &parsed))
+		assert.Error(t, json.Unmarshal([]byte(`true`), &parsed))
+	})
+
+	t.Run("Success: user time format", func(t *testing.T) {
+		user := &UserDetails{ID: 1, CreatedAt: NewNullTime(at)}
+		user.SetTimeFormat(TimeFormatEpochMillis)
+
+		data, err := json.Marshal(user)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"created_at":1709296200000,"deleted_at":null`)
+	})
+}
+
+func TestNullTimeSQL(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	var scanned NullTime
+	assert.NoError(t, scanned.Scan(at))
+	assert.Equal(t, NewNullTime(at), scanned)
+	assert.NoError(t, scanned.Scan(nil))
+	assert.Equal(t, NullTime{}, scanned)
+
+	value, err := NewNullTime(at).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, at, value)
+	value, err = NullTime{}.Value()
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestParseTimeFormat(t *testing.T) {
+	format, err := ParseTimeFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFormatRFC3339, format)
+
+	format, err = ParseTimeFormat("epoch_millis")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFormatEpochMillis, format)
+
+	_, err = ParseTimeFormat("unix")
+	assert.ErrorIs(t, err, ErrInvalidTimeFormat)
+}
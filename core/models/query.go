@@ -0,0 +1,106 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCursor   = errors.New("invalid pagination cursor")
+	ErrUnsortableField = errors.New("users can't be sorted by an encrypted field")
+)
+
+// SortField is a user_details column the user listing can be ordered by, the encrypted columns can't be.
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByCreatedAt SortField = "created_at"
+)
+
+// infinityTime is used as the cursor value of rows with a NULL created_at, postgres sorts them as '-infinity'.
+const infinityTime = "-infinity"
+
+func (s SortField) Valid() bool {
+	switch s {
+	case SortByID, SortByCreatedAt:
+		return true
+	}
+	return false
+}
+
+// UserQuery describes a single page request on the user listing, nil filters are not applied.
+type UserQuery struct {
+	Limit      int64
+	SortBy     SortField
+	Descending bool
+	After      *Cursor
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Deleted       *bool
+	Merged        *bool
+	ParentUserID  *int64
+	// Email is looked up through EmailIndex, the service fills the index from the plaintext email.
+	Email      string
+	EmailIndex string
+}
+
+// Cursor points at the last row of a page, it is handed to clients as an opaque string.
+type Cursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Value      string    `json:"v,omitempty"`
+	ID         int64     `json:"id"`
+}
+
+// CursorFor builds the cursor pointing right after the given user for the given ordering.
+func CursorFor(user *UserDetails, sortBy SortField, descending bool) *Cursor {
+	cursor := &Cursor{
+		SortBy:     sortBy,
+		Descending: descending,
+		ID:         user.ID,
+	}
+
+	if sortBy == SortByCreatedAt {
+		cursor.Value = infinityTime
+		if user.CreatedAt.Valid {
+			cursor.Value = user.CreatedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	return cursor
+}
+
+func (c *Cursor) Encode() string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		// marshalling a struct of plain fields can't fail.
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || !cursor.SortBy.Valid() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// UserPage is a single page of the user listing.
+type UserPage struct {
+	Users      []*UserDetails
+	NextCursor string
+	TotalCount int64
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrUnknownRole = errors.New("unknown role, it should be admin, support or none")
+
+// Role of the caller, it decides how much of the personal data is visible in responses.
+type Role string
+
+const (
+	// RoleAdmin sees the personal data in plaintext.
+	RoleAdmin Role = "admin"
+	// RoleSupport sees masked emails, e.g. j***@example.com.
+	RoleSupport Role = "support"
+	// RoleNone doesn't see the emails, they are not even decrypted.
+	RoleNone Role = "none"
+)
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleAdmin, RoleSupport, RoleNone:
+		return role, nil
+	default:
+		return "", ErrUnknownRole
+	}
+}
+
+// Visibility of a personal data field for a role.
+type Visibility int
+
+const (
+	// VisibilityNone hides the value, it is not even decrypted.
+	VisibilityNone Visibility = iota
+	VisibilityMasked
+	VisibilityFull
+)
+
+// EmailVisibility returns how the email is shown to the role, unknown roles don't see it.
+func (r Role) EmailVisibility() Visibility {
+	switch r {
+	case RoleAdmin:
+		return VisibilityFull
+	case RoleSupport:
+		return VisibilityMasked
+	default:
+		return VisibilityNone
+	}
+}
+
+type roleKey struct{}
+
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the role of the caller, callers without role see no personal data.
+func RoleFromContext(ctx context.Context) Role {
+	role, _ := ctx.Value(roleKey{}).(Role)
+	return role
+}
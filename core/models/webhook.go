@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("invalid webhook url, it should be an absolute http or https url")
+	ErrPrivateWebhookURL = errors.New("invalid webhook url, it should not point to a private, loopback or link-local address")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, it is not routable on the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// PublicAddr reports whether the address can be the destination of a webhook: private, loopback, link-local,
+// multicast and unspecified addresses are internal to the network of the service.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() &&
+		!addr.IsUnspecified() && !sharedAddressSpace.Contains(addr)
+}
+
+// Webhook is an endpoint of a partner called with the user events of its types, every type when EventTypes is empty.
+type Webhook struct {
+	ID         int64       `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"`
+	// Secret signs the deliveries, it is only returned when the webhook is created.
+	Secret  string `json:"secret,omitempty"`
+	Enabled bool   `json:"enabled"`
+	// ConsecutiveFailures counts the failed delivery attempts since the last successful one, the webhook is
+	// disabled when it reaches the limit.
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	DisabledAt          NullTime  `json:"disabled_at"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+
+	// the hosts resolving to an internal address are refused when the deliveries connect.
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateWebhookURL
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !PublicAddr(addr) {
+		return ErrPrivateWebhookURL
+	}
+	for _, t := range w.EventTypes {
+		if !t.Valid() {
+			return ErrInvalidEventType
+		}
+	}
+	return nil
+}
+
+// DeliveryStatus is the state of the delivery of an event to a webhook.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is waiting for its next attempt, the deliveries of a disabled webhook wait until it is enabled.
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed has used all its attempts.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is the delivery of an event to a webhook, it is also the delivery log of the webhook.
+type WebhookDelivery struct {
+	ID        int64          `json:"id"`
+	WebhookID int64          `json:"webhook_id"`
+	Event     OutboxEvent    `json:"event"`
+	Status    DeliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+	// LastStatusCode is the status of the last response, 0 when no response was received. The response body is not kept.
+	LastStatusCode int       `json:"last_status_code,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextAttemptAt  NullTime  `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DeliveryAttempt is a delivery claimed for an attempt with what is needed to send it.
+type DeliveryAttempt struct {
+	WebhookDelivery
+	URL string
+	// WrappedSecret is the secret of the webhook wrapped with the encryption keys.
+	WrappedSecret string
+}
+
+// DeliveryResult is the outcome of a delivery attempt, the delivery is attempted again at RetryAt when it is set.
+type DeliveryResult struct {
+	Delivered  bool
+	StatusCode int
+	Error      string
+	RetryAt    NullTime
+}
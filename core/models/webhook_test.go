@@ -0,0 +1,32 @@
+package models
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	for _, url := range []string{"https://partner.example.com/users", "http://93.184.216.34:8080", "https://[2606:2800:220:1::1]/"} {
+		assert.NoError(t, (&Webhook{URL: url}).Validate(), url)
+	}
+
+	for _, url := range []string{"partner.example.com", "ftp://partner.example.com", "https://"} {
+		assert.ErrorIs(t, (&Webhook{URL: url}).Validate(), ErrInvalidWebhookURL, url)
+	}
+
+	for _, url := range []string{
+		"http://localhost:8080", "http://api.localhost", "http://127.0.0.1", "http://10.0.0.1", "http://192.168.1.10",
+		"http://172.16.0.1", "http://169.254.169.254/latest/meta-data", "http://[::1]", "http://[fe80::1]",
+		"http://[::ffff:127.0.0.1]", "http://0.0.0.0", "http://100.64.0.1",
+	} {
+		assert.ErrorIs(t, (&Webhook{URL: url}).Validate(), ErrPrivateWebhookURL, url)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	assert.True(t, PublicAddr(netip.MustParseAddr("93.184.216.34")))
+	assert.False(t, PublicAddr(netip.MustParseAddr("::ffff:10.0.0.1")))
+	assert.False(t, PublicAddr(netip.Addr{}))
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// CreateUsers creates the users in a single transaction and returns the outcome of each in order, an invalid or
+// duplicate user does not stop the batch. Every valid user fails when the transaction fails.
+func (us *UserService) CreateUsers(ctx context.Context, users []*models.UserDetails) []*models.CreateResult {
+	results := make([]*models.CreateResult, 0, len(users))
+	valid := make([]*models.UserDetails, 0, len(users))
+	validResults := make([]*models.CreateResult, 0, len(users))
+	wrappedKeys := make([]string, 0, len(users))
+	for i, user := range users {
+		result := &models.CreateResult{Index: i, ID: user.ID, Status: models.CreateCreated}
+		results = append(results, result)
+
+		err := user.Validate()
+		if err != nil {
+			result.Status, result.Error = models.CreateInvalid, err.Error()
+			continue
+		}
+
+		wrappedKey, err := us.seal(user)
+		if err != nil {
+			us.logger.Error("UserService: error sealing user of batch", zap.Int64("user_id", user.ID), zap.Error(err))
+			result.Status, result.Error = models.CreateFailed, "internal server error"
+			continue
+		}
+
+		valid = append(valid, user)
+		validResults = append(validResults, result)
+		wrappedKeys = append(wrappedKeys, wrappedKey)
+	}
+
+	if len(valid) == 0 {
+		return results
+	}
+
+	errs, err := us.dataStore.CreateUsers(ctx, valid, wrappedKeys)
+	if err != nil {
+		message := "internal server error"
+		if errors.Is(err, context.DeadlineExceeded) {
+			message = "request time out please try again later"
+		} else {
+			us.logger.Error("UserService: error creating batch", zap.Int("count", len(valid)), zap.Error(err))
+		}
+		for _, result := range validResults {
+			result.Status, result.Error = models.CreateFailed, message
+		}
+		return results
+	}
+
+	for i, user := range valid {
+		if errs[i] != nil {
+			validResults[i].Status, validResults[i].Error = models.CreateDuplicate, errs[i].Error()
+			continue
+		}
+		us.created(ctx, user, wrappedKeys[i])
+	}
+	return results
+}
+
+// GetUsers returns the users with the given ids in the order of the ids, an id given twice is returned once. The users
+// are read from the cache in a single round trip, the ones not cached from the database with a single query.
+func (us *UserService) GetUsers(ctx context.Context, ids []int64) (*models.UserLookup, error) {
+	seen := make(map[int64]struct{}, len(ids))
+	unique := make([]int64, 0, len(ids))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+		keys = append(keys, strconv.FormatInt(id, 10))
+	}
+
+	cached, err := us.memStore.GetMany(ctx, keys)
+	if err != nil {
+		us.logger.Warn("UserService: error getting users from cache", zap.Int("count", len(keys)), zap.Error(err))
+		cached = map[string]*models.UserDetails{}
+	}
+
+	found := make(map[int64]*models.UserDetails, len(unique))
+	notFound := make(map[int64]bool)
+	var misses []int64
+	for i, id := range unique {
+		user, ok := cached[keys[i]]
+		switch {
+		case !ok:
+			misses = append(misses, id)
+		case user == nil:
+			notFound[id] = true
+		default:
+			found[id] = user
+		}
+	}
+
+	erased, err := us.loadUsers(ctx, misses, found, notFound)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*models.UserDetails, 0, len(found))
+	for _, id := range unique {
+		if user, ok := found[id]; ok {
+			users = append(users, user)
+		}
+	}
+
+	ciphers, err := us.keys.ciphers(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := &models.UserLookup{Users: make([]*models.UserDetails, 0, len(users))}
+	role := models.RoleFromContext(ctx)
+	format := models.TimeFormatFromContext(ctx)
+	for _, user := range users {
+		err = openForRole(ciphers[user.ID], user, role)
+		if errors.Is(err, encryptionutils.ErrNoUserKey) {
+			// the user has been erased after being cached, the entry can't be read anymore.
+			err = us.memStore.Delete(ctx, strconv.FormatInt(user.ID, 10))
+			if err != nil {
+				us.logger.Warn("UserService: error deleting erased user from cache", zap.Int64("user_id", user.ID), zap.Error(err))
+			}
+			erased[user.ID] = true
+			continue
+		}
+		if err != nil {
+			us.logger.Error("error decrypting user", zap.Int64("user_id", user.ID), zap.Error(err))
+			return nil, err
+		}
+
+		user.SetTimeFormat(format)
+		lookup.Users = append(lookup.Users, user)
+	}
+
+	for _, id := range unique {
+		if notFound[id] {
+			lookup.NotFound = append(lookup.NotFound, id)
+		}
+		if erased[id] {
+			lookup.Erased = append(lookup.Erased, id)
+		}
+	}
+	return lookup, nil
+}
+
+// loadUsers fetches the users not cached from the database and caches them, the users found are added to found and
+// the others to notFound. It returns the erased users, their tombstones are never cached.
+func (us *UserService) loadUsers(ctx context.Context, ids []int64, found map[int64]*models.UserDetails, notFound map[int64]bool) (map[int64]bool, error) {
+	erased := make(map[int64]bool)
+	if len(ids) == 0 {
+		return erased, nil
+	}
+
+	users, err := us.dataStore.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.ErasedAt.Valid {
+			erased[user.ID] = true
+			continue
+		}
+
+		err = us.memStore.Set(ctx, strconv.FormatInt(user.ID, 10), user)
+		if err != nil {
+			us.logger.Warn("UserService: error setting user in cache", zap.Int64("user_id", user.ID), zap.Error(err))
+		}
+		found[user.ID] = user
+	}
+
+	for _, id := range ids {
+		if _, ok := found[id]; ok || erased[id] {
+			continue
+		}
+
+		notFound[id] = true
+		err = us.memStore.SetMissing(ctx, strconv.FormatInt(id, 10))
+		if err != nil {
+			us.logger.Warn("UserService: error caching missing user", zap.Int64("user_id", id), zap.Error(err))
+		}
+	}
+
+	return erased, nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/redis/mockredis"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestCreateUsers(t *testing.T) {
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+
+	userIDs := func(ids ...int64) interface{} {
+		return mock.MatchedBy(func(users []*models.UserDetails) bool {
+			if len(users) != len(ids) {
+				return false
+			}
+			for i, user := range users {
+				if user.ID != ids[i] {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	t.Run("Success: valid users created in one transaction", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("CreateUsers", mock.Anything, userIDs(1, 2, 3), mock.Anything).Return([]error{nil, database.ErrDuplicate, nil}, nil).Once()
+		memStore := new(mockredis.MockRedis)
+		memStore.On("Set", mock.Anything, "1", mock.Anything).Return(nil).Once()
+		memStore.On("Set", mock.Anything, "3", mock.Anything).Return(nil).Once()
+
+		results := NewUserService(dataStore, memStore, nil, fields, zap.NewNop()).CreateUsers(context.Background(), []*models.UserDetails{
+			{ID: 1, EmailAddress: "john@example.com"},
+			{ID: 2},
+			{ID: 0},
+			{ID: 3},
+			{ID: 4, EmailAddress: "john"},
+		})
+
+		assert.Equal(t, []*models.CreateResult{
+			{Index: 0, ID: 1, Status: models.CreateCreated},
+			{Index: 1, ID: 2, Status: models.CreateDuplicate, Error: database.ErrDuplicate.Error()},
+			{Index: 2, Status: models.CreateInvalid, Error: models.ErrInvalidUserID.Error()},
+			{Index: 3, ID: 3, Status: models.CreateCreated},
+			{Index: 4, ID: 4, Status: models.CreateInvalid, Error: models.ErrInvalidEmail.Error()},
+		}, results)
+		dataStore.AssertExpectations(t)
+		memStore.AssertExpectations(t)
+	})
+
+	t.Run("Error: transaction failing fails every valid user", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("CreateUsers", mock.Anything, userIDs(1, 2), mock.Anything).Return([]error(nil), context.DeadlineExceeded).Once()
+		memStore := new(mockredis.MockRedis)
+
+		results := NewUserService(dataStore, memStore, nil, fields, zap.NewNop()).CreateUsers(context.Background(), []*models.UserDetails{
+			{ID: 1},
+			{ID: 0},
+			{ID: 2},
+		})
+
+		assert.Equal(t, []*models.CreateResult{
+			{Index: 0, ID: 1, Status: models.CreateFailed, Error: "request time out please try again later"},
+			{Index: 1, Status: models.CreateInvalid, Error: models.ErrInvalidUserID.Error()},
+			{Index: 2, ID: 2, Status: models.CreateFailed, Error: "request time out please try again later"},
+		}, results)
+		dataStore.AssertExpectations(t)
+		memStore.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestGetUsers(t *testing.T) {
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+
+	sealed := func(id int64) *models.UserDetails {
+		user := &models.UserDetails{ID: id, FirstName: "john", EmailAddress: "john@example.com"}
+		assert.NoError(t, fields.Seal(user, userAssociatedData(id)))
+		// the blind index is not read back.
+		user.EmailIndex = ""
+		return user
+	}
+	opened := func(id int64) *models.UserDetails {
+		return &models.UserDetails{ID: id, FirstName: "john", EmailAddress: "john@example.com"}
+	}
+	tombstone := &models.UserDetails{ID: 4, ErasedAt: models.NewNullTime(time.Now())}
+
+	t.Run("Success: cached users and database fallback", func(t *testing.T) {
+		memStore := new(mockredis.MockRedis)
+		memStore.On("GetMany", mock.Anything, []string{"1", "2", "3", "4", "5"}).Return(map[string]*models.UserDetails{"1": sealed(1), "2": nil}, nil).Once()
+		memStore.On("Set", mock.Anything, "3", mock.Anything).Return(nil).Once()
+		memStore.On("SetMissing", mock.Anything, "5").Return(nil).Once()
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("GetUsersByIDs", mock.Anything, []int64{3, 4, 5}).Return([]*models.UserDetails{tombstone, sealed(3)}, nil).Once()
+		dataStore.On("GetUserKeys", mock.Anything, []int64{1, 3}).Return(map[int64]string{}, nil).Once()
+
+		lookup, err := NewUserService(dataStore, memStore, nil, fields, zap.NewNop()).GetUsers(adminContext(), []int64{1, 2, 3, 4, 5, 1})
+		assert.NoError(t, err)
+		assert.Equal(t, &models.UserLookup{Users: []*models.UserDetails{opened(1), opened(3)}, NotFound: []int64{2, 5}, Erased: []int64{4}}, lookup)
+		memStore.AssertExpectations(t)
+		dataStore.AssertExpectations(t)
+	})
+
+	t.Run("Success: cache unavailable", func(t *testing.T) {
+		memStore := new(mockredis.MockRedis)
+		memStore.On("GetMany", mock.Anything, []string{"1"}).Return(map[string]*models.UserDetails(nil), errors.New("test error")).Once()
+		memStore.On("Set", mock.Anything, "1", mock.Anything).Return(errors.New("test error")).Once()
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("GetUsersByIDs", mock.Anything, []int64{1}).Return([]*models.UserDetails{sealed(1)}, nil).Once()
+		dataStore.On("GetUserKeys", mock.Anything, []int64{1}).Return(map[int64]string{}, nil).Once()
+
+		lookup, err := NewUserService(dataStore, memStore, nil, fields, zap.NewNop()).GetUsers(adminContext(), []int64{1})
+		assert.NoError(t, err)
+		assert.Equal(t, []*models.UserDetails{opened(1)}, lookup.Users)
+	})
+
+	t.Run("Fail: database error", func(t *testing.T) {
+		memStore := new(mockredis.MockRedis)
+		memStore.On("GetMany", mock.Anything, []string{"1"}).Return(map[string]*models.UserDetails{}, nil).Once()
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("GetUsersByIDs", mock.Anything, []int64{1}).Return([]*models.UserDetails(nil), errors.New("test error")).Once()
+
+		_, err := NewUserService(dataStore, memStore, nil, fields, zap.NewNop()).GetUsers(adminContext(), []int64{1})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/redis"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// readsRetainedFactor is how many more read counts than warmed users are kept, so users becoming popular
+// are not forgotten before they reach the top.
+const readsRetainedFactor = 10
+
+// WarmupProgress reports what a warm-up cached.
+type WarmupProgress struct {
+	Recent  int64 `json:"recent"`
+	Popular int64 `json:"popular"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+}
+
+// ReconcileProgress reports what a reconciliation found in the sampled keys.
+type ReconcileProgress struct {
+	Sampled int64 `json:"sampled"`
+	Stale   int64 `json:"stale"`
+	Fixed   int64 `json:"fixed"`
+	Failed  int64 `json:"failed"`
+}
+
+// CacheSync repopulates a cold cache and repairs the cached users which no longer match the database.
+type CacheSync struct {
+	dataStore dataStoreProvider
+	memStore  memoryStoreProvider
+	index     cacheIndex
+	logger    *zap.Logger
+}
+
+func NewCacheSync(dataStore dataStoreProvider, memStore memoryStoreProvider, index cacheIndex, logger *zap.Logger) *CacheSync {
+	return &CacheSync{
+		dataStore: dataStore,
+		memStore:  memStore,
+		index:     index,
+		logger:    logger,
+	}
+}
+
+// Warm caches the most recently created users and the most read users.
+// Users are cached as stored in the database, i.e. encrypted, exactly like a read miss would.
+func (cs *CacheSync) Warm(ctx context.Context, recent, popular int64) (*WarmupProgress, error) {
+	progress := &WarmupProgress{}
+	warmed := make(map[int64]struct{})
+
+	if recent > 0 {
+		users, err := cs.dataStore.FilterUsers(ctx, &models.UserQuery{
+			Limit:      recent,
+			SortBy:     models.SortByCreatedAt,
+			Descending: true,
+		})
+		if err != nil {
+			return progress, err
+		}
+
+		for _, user := range users {
+			if !cs.warm(ctx, user, progress) {
+				continue
+			}
+			warmed[user.ID] = struct{}{}
+			progress.Recent++
+		}
+	}
+
+	if popular <= 0 {
+		return progress, nil
+	}
+
+	keys, err := cs.index.TopReads(ctx, popular)
+	if err != nil {
+		return progress, err
+	}
+
+	for _, key := range keys {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			progress.Skipped++
+			continue
+		}
+		if _, ok := warmed[id]; ok {
+			continue
+		}
+
+		user, err := cs.dataStore.GetUserByID(ctx, key)
+		if err != nil {
+			if errors.Is(err, database.ErrNoData) {
+				progress.Skipped++
+				continue
+			}
+			return progress, err
+		}
+
+		// tombstones are never cached.
+		if user.ErasedAt.Valid {
+			progress.Skipped++
+			continue
+		}
+
+		if cs.warm(ctx, user, progress) {
+			progress.Popular++
+		}
+	}
+
+	err = cs.index.TrimReads(ctx, popular*readsRetainedFactor)
+	if err != nil {
+		cs.logger.Warn("CacheSync: error trimming read counts", zap.Error(err))
+	}
+
+	return progress, nil
+}
+
+func (cs *CacheSync) warm(ctx context.Context, user *models.UserDetails, progress *WarmupProgress) bool {
+	err := cs.memStore.Set(ctx, strconv.FormatInt(user.ID, 10), user)
+	if err != nil {
+		progress.Failed++
+		cs.logger.Warn("CacheSync: error setting user in cache", zap.Int64("user_id", user.ID), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// Reconcile compares up to sample random cached users with the database, stale entries are replaced with the
+// database value and entries of users which no longer exist are removed.
+func (cs *CacheSync) Reconcile(ctx context.Context, sample int) (*ReconcileProgress, error) {
+	progress := &ReconcileProgress{}
+
+	keys, err := cs.index.SampleKeys(ctx, sample)
+	if err != nil {
+		return progress, err
+	}
+
+	for _, key := range keys {
+		// the cache also holds keys which are not users.
+		if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+			continue
+		}
+
+		progress.Sampled++
+		err := cs.reconcile(ctx, key, progress)
+		if err != nil {
+			progress.Failed++
+			cs.logger.Error("failed to reconcile cached user", zap.String("user_id", key), zap.Error(err))
+		}
+	}
+
+	return progress, nil
+}
+
+func (cs *CacheSync) reconcile(ctx context.Context, key string, progress *ReconcileProgress) error {
+	cached, err := cs.memStore.Get(ctx, key)
+	missing := errors.Is(err, redis.ErrMissing)
+	switch {
+	case errors.Is(err, redis.ErrNotFound):
+		// expired since it was sampled.
+		return nil
+	case err != nil && !missing:
+		// the entry can't be read, e.g. it is not a user anymore.
+		cs.logger.Warn("CacheSync: unreadable cached user", zap.String("user_id", key), zap.Error(err))
+		progress.Stale++
+		return cs.fix(ctx, key, nil, progress)
+	}
+
+	user, err := cs.dataStore.GetUserByID(ctx, key)
+	if err != nil && !errors.Is(err, database.ErrNoData) {
+		return err
+	}
+	if user != nil && user.ErasedAt.Valid {
+		user = nil
+	}
+
+	switch {
+	case user == nil && missing:
+		return nil
+	case user != nil && !missing:
+		same, err := sameUser(cached, user)
+		if err != nil || same {
+			return err
+		}
+	}
+
+	progress.Stale++
+	return cs.fix(ctx, key, user, progress)
+}
+
+// fix caches the user, or removes the entry when the user is nil.
+func (cs *CacheSync) fix(ctx context.Context, key string, user *models.UserDetails, progress *ReconcileProgress) error {
+	var err error
+	if user == nil {
+		err = cs.memStore.Delete(ctx, key)
+	} else {
+		err = cs.memStore.Set(ctx, key, user)
+	}
+	if err != nil {
+		return err
+	}
+
+	progress.Fixed++
+	return nil
+}
+
+// sameUser compares the users as they are cached, fields which are not cached are ignored.
+func sameUser(cached, stored *models.UserDetails) (bool, error) {
+	a, err := json.Marshal(cached)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(a, b), nil
+}
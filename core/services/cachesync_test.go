@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/redis"
+	"github.com/viswals_task/pkg/redis/mockredis"
+	"go.uber.org/zap"
+)
+
+func TestCacheSyncWarm(t *testing.T) {
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+
+	recent := []*models.UserDetails{{ID: 1}, {ID: 2}}
+	popular := &models.UserDetails{ID: 3}
+	erased := &models.UserDetails{ID: 4, ErasedAt: models.NullTime{Time: time.Now(), Valid: true}}
+
+	dataStore := new(mockdatabase.MockDatabase)
+	memStore := new(mockredis.MockRedis)
+	dataStore.On("FilterUsers", mock.Anything, mock.MatchedBy(func(q *models.UserQuery) bool {
+		return q.Limit == 2 && q.SortBy == models.SortByCreatedAt && q.Descending
+	})).Return(recent, nil)
+	memStore.On("TopReads", mock.Anything, int64(4)).Return([]string{"2", "3", "4", "5", "users:reads"}, nil)
+	dataStore.On("GetUserByID", mock.Anything, "3").Return(popular, nil)
+	dataStore.On("GetUserByID", mock.Anything, "4").Return(erased, nil)
+	dataStore.On("GetUserByID", mock.Anything, "5").Return((*models.UserDetails)(nil), database.ErrNoData)
+	memStore.On("Set", mock.Anything, "1", recent[0]).Return(nil).Once()
+	memStore.On("Set", mock.Anything, "2", recent[1]).Return(nil).Once()
+	memStore.On("Set", mock.Anything, "3", popular).Return(nil).Once()
+	memStore.On("TrimReads", mock.Anything, int64(40)).Return(nil)
+
+	progress, err := NewCacheSync(dataStore, memStore, memStore, log).Warm(context.Background(), 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, &WarmupProgress{Recent: 2, Popular: 1, Skipped: 3}, progress)
+	dataStore.AssertExpectations(t)
+	memStore.AssertExpectations(t)
+}
+
+func TestCacheSyncReconcile(t *testing.T) {
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+
+	created := models.NullTime{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+	current := &models.UserDetails{ID: 1, FirstName: "v2:john", CreatedAt: created}
+	changed := &models.UserDetails{ID: 2, FirstName: "v2:jane"}
+	created3 := &models.UserDetails{ID: 3}
+
+	dataStore := new(mockdatabase.MockDatabase)
+	memStore := new(mockredis.MockRedis)
+	memStore.On("SampleKeys", mock.Anything, 10).Return([]string{"users:reads", "1", "2", "3", "4", "5", "6"}, nil)
+
+	// 1 is up to date, the email index is not cached.
+	memStore.On("Get", mock.Anything, "1").Return(&models.UserDetails{ID: 1, FirstName: "v2:john", CreatedAt: created}, nil)
+	dataStore.On("GetUserByID", mock.Anything, "1").Return(&models.UserDetails{ID: 1, FirstName: "v2:john", CreatedAt: created, EmailIndex: "index"}, nil)
+	// 2 has changed.
+	memStore.On("Get", mock.Anything, "2").Return(&models.UserDetails{ID: 2, FirstName: "v2:john"}, nil)
+	dataStore.On("GetUserByID", mock.Anything, "2").Return(changed, nil)
+	memStore.On("Set", mock.Anything, "2", changed).Return(nil).Once()
+	// 3 is cached as missing but has been created.
+	memStore.On("Get", mock.Anything, "3").Return((*models.UserDetails)(nil), redis.ErrMissing)
+	dataStore.On("GetUserByID", mock.Anything, "3").Return(created3, nil)
+	memStore.On("Set", mock.Anything, "3", created3).Return(nil).Once()
+	// 4 has been deleted.
+	memStore.On("Get", mock.Anything, "4").Return(current, nil)
+	dataStore.On("GetUserByID", mock.Anything, "4").Return((*models.UserDetails)(nil), database.ErrNoData)
+	memStore.On("Delete", mock.Anything, "4").Return(nil).Once()
+	// 5 expired since it was sampled.
+	memStore.On("Get", mock.Anything, "5").Return((*models.UserDetails)(nil), redis.ErrNotFound)
+	// 6 is cached as missing and still doesn't exist.
+	memStore.On("Get", mock.Anything, "6").Return((*models.UserDetails)(nil), redis.ErrMissing)
+	dataStore.On("GetUserByID", mock.Anything, "6").Return((*models.UserDetails)(nil), database.ErrNoData)
+
+	progress, err := NewCacheSync(dataStore, memStore, memStore, log).Reconcile(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReconcileProgress{Sampled: 6, Stale: 3, Fixed: 3}, progress)
+	dataStore.AssertExpectations(t)
+	memStore.AssertExpectations(t)
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -25,10 +26,13 @@ type Consumer struct {
 	// extending consumer to provide http server and database access.
 	userStore dataStoreProvider
 	memStore  memoryStoreProvider
-	encryp *encryptionutils.Encryption
+	events    eventLog
+	keys      *userKeys
+	// imports receives the counts of the batches sent with a run id.
+	imports importStore
 }
 
-func NewConsumer(queue queueConsumer, userStore dataStoreProvider, memStore memoryStoreProvider, encryp *encryptionutils.Encryption,logger *zap.Logger) (*Consumer, error) {
+func NewConsumer(queue queueConsumer, userStore dataStoreProvider, memStore memoryStoreProvider, events eventLog, imports importStore, fields *encryptionutils.FieldCipher, logger *zap.Logger) (*Consumer, error) {
 	// connect with the initialized queue.
 	in, err := queue.Subscribe()
 	if err != nil {
@@ -40,8 +44,10 @@ func NewConsumer(queue queueConsumer, userStore dataStoreProvider, memStore memo
 		channel:   in,
 		logger:    logger,
 		userStore: userStore,
-		encryp: encryp,
+		keys:      &userKeys{store: userStore, fields: fields},
 		memStore:  memStore,
+		events:    events,
+		imports:   imports,
 	}, nil
 }
 
@@ -49,7 +55,7 @@ func (c *Consumer) Consume(wg *sync.WaitGroup, size int) {
 	defer wg.Done()
 
 	var userDetailsInput chan []byte = make(chan []byte, size)
-	var userDetailsOutput chan []*models.UserDetails = make(chan []*models.UserDetails, size)
+	var userDetailsOutput chan *models.UserBatch = make(chan *models.UserBatch, size)
 
 	var errorChan chan error = make(chan error, 10)
 
@@ -89,42 +95,75 @@ func (c *Consumer) errorLogger(wg *sync.WaitGroup, errorChan chan error) {
 	}
 }
 
-func (c *Consumer) ToUserDetails(wg *sync.WaitGroup, inputChan chan []byte, outputChan chan []*models.UserDetails, errorChan chan error) {
+func (c *Consumer) ToUserDetails(wg *sync.WaitGroup, inputChan chan []byte, outputChan chan *models.UserBatch, errorChan chan error) {
 	defer wg.Done()
 	defer close(outputChan)
 	for data := range inputChan {
-		var users []*models.UserDetails
-		err := json.Unmarshal(data, &users)
+		batch, err := decodeBatch(data)
 		if err != nil {
+			// the users of the batch are counted as failures so that the import still completes.
+			if runID, count, ok := undecodableBatch(data); ok {
+				c.recordProgress(runID, &models.ImportProgress{Consumed: count, Failures: count})
+			}
 			errorChan <- err
 			continue
 		}
-		c.logger.Debug("Consumed data",zap.Int("size",len(users)))
-		outputChan <- users
+		c.logger.Debug("Consumed data",zap.Int("size",len(batch.Users)),zap.String("import_id",batch.RunID))
+		outputChan <- batch
 	}
 	c.logger.Info(fmt.Sprintf("User Data Marsheller stopped"))
 }
 
-func (c *Consumer) SaveUserDetails(wg *sync.WaitGroup, inputChan chan []*models.UserDetails, errorChan chan error) {
+// decodeBatch decodes a message of the ingestion queue, the messages published before the imports were tracked are
+// arrays of users.
+func decodeBatch(data []byte) (*models.UserBatch, error) {
+	batch := new(models.UserBatch)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return batch, json.Unmarshal(data, &batch.Users)
+	}
+	return batch, json.Unmarshal(data, batch)
+}
+
+// undecodableBatch recovers the import and the number of users of a message decodeBatch fails on, ok is false when
+// the message is not a batch object, e.g. truncated, the import then stays published.
+func undecodableBatch(data []byte) (runID string, count int64, ok bool) {
+	var batch struct {
+		RunID string            `json:"run_id"`
+		Users []json.RawMessage `json:"users"`
+	}
+	if json.Unmarshal(data, &batch) != nil {
+		return "", 0, false
+	}
+	return batch.RunID, int64(len(batch.Users)), true
+}
+
+func (c *Consumer) SaveUserDetails(wg *sync.WaitGroup, inputChan chan *models.UserBatch, errorChan chan error) {
 	defer wg.Done()
 	defer close(errorChan)
-	for data := range inputChan {
-		for _, user := range data {
+	for batch := range inputChan {
+		progress := &models.ImportProgress{Consumed: int64(len(batch.Users))}
+		for _, user := range batch.Users {
 
-			encryptedEmail, err := c.encryp.Encrypt(user.EmailAddress)
+			wrappedKey, fields, err := c.keys.generate(user.ID)
+			if err == nil {
+				err = fields.Seal(user, userAssociatedData(user.ID))
+			}
 			if err != nil {
 				c.logger.Error("error encrypting user", zap.Error(err))
+				progress.Failures++
 				errorChan <- err
 				continue
 			}
-			user.EmailAddress = encryptedEmail
 
 			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-			// first save user details to a database.
-			err = c.userStore.CreateUser(ctx, user)
+			// first save user details to a database, an erased user is kept as a tombstone so it is never created again.
+			err = c.userStore.CreateUser(ctx, user, wrappedKey)
 			if err != nil {
-				if errors.Is(err, database.ErrDuplicate) {
+				if errors.Is(err, database.ErrDuplicate) || errors.Is(err, database.ErrDuplicateEmail) {
 					c.logger.Warn("User already exists", zap.Error(err), zap.Any("user", user))
+					progress.Duplicates++
+				} else {
+					progress.Failures++
 				}
 
 				errorChan <- err
@@ -138,12 +177,32 @@ func (c *Consumer) SaveUserDetails(wg *sync.WaitGroup, inputChan chan []*models.
 				// not to worry as data has been already stored in a database.
 				c.logger.Warn("Failed to store user in memoryDatabase", zap.Error(err), zap.Any("user", user))
 			}
+
+			progress.Stored++
+			recordCreated(ctx, c.events, c.logger, user)
 			cancel()
 		}
+
+		c.recordProgress(batch.RunID, progress)
 	}
 	c.logger.Info(fmt.Sprintf("User Data Saver stopped"))
 }
 
+// recordProgress adds the counts of a batch to its import, the batches of untracked imports have no run id.
+func (c *Consumer) recordProgress(runID string, progress *models.ImportProgress) {
+	if c.imports == nil || runID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := c.imports.AddImportProgress(ctx, runID, progress)
+	if err != nil {
+		c.logger.Warn("Consumer: error recording import progress", zap.Error(err), zap.String("import_id", runID))
+	}
+}
+
 func (c *Consumer) Close() error {
 	return c.queue.Close()
 }
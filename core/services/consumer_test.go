@@ -1,7 +1,6 @@
 package services
 
 import (
-	"database/sql"
 	"errors"
 	"sync"
 	"testing"
@@ -12,6 +11,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/viswals_task/core/models"
 	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
 	"github.com/viswals_task/pkg/database/mockdatabase"
 	"github.com/viswals_task/pkg/rabbitmq/mockrabbitmq"
 	"github.com/viswals_task/pkg/redis/mockredis"
@@ -36,8 +36,11 @@ func TestConsumer(t *testing.T) {
 
 	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
 	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 
-	userStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(nil)
+	userStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(nil)
 	memStore.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("*models.UserDetails")).Return(nil)
 
 	var deliveryChannel = make(chan amqp.Delivery, 10)
@@ -48,8 +51,8 @@ func TestConsumer(t *testing.T) {
 			consumer: &Consumer{
 				queue:     queueStore,
 				channel:   deliveryChannel,
-				encryp:    encryp,
 				logger:    log,
+				keys:      &userKeys{store: userStore, fields: fields},
 				userStore: userStore,
 				memStore:  memStore,
 			},
@@ -96,7 +99,7 @@ func TestConsumer(t *testing.T) {
 type TestUserDetails struct {
 	name       string
 	input      []byte
-	output     []*models.UserDetails
+	output     *models.UserBatch
 	throwError bool
 }
 
@@ -124,16 +127,22 @@ func TestToUserDetails(t *testing.T) {
 				}
 			]
 		`),
-			output: []*models.UserDetails{{
+			output: &models.UserBatch{Users: []*models.UserDetails{{
 				ID:           1,
 				FirstName:    "John",
 				LastName:     "Doe",
 				EmailAddress: "john@doe.com",
-				CreatedAt:    sql.NullTime{},
-				DeletedAt:    sql.NullTime{},
-				MergedAt:     sql.NullTime{},
+				CreatedAt:    models.NullTime{},
+				DeletedAt:    models.NullTime{},
+				MergedAt:     models.NullTime{},
 				ParentUserId: 1,
-			}},
+			}}},
+			throwError: false,
+		},
+		{
+			name:       "batch with run id",
+			input:      []byte(`{"run_id":"import-1","users":[{"id":1,"first_name":"John","parent_user_id":1}]}`),
+			output:     &models.UserBatch{RunID: "import-1", Users: []*models.UserDetails{{ID: 1, FirstName: "John", ParentUserId: 1}}},
 			throwError: false,
 		},
 		{
@@ -165,14 +174,14 @@ func TestToUserDetails(t *testing.T) {
 
 	// Create a blank consumer first
 	consumer := Consumer{
-		encryp: encryp,
+		keys:   &userKeys{fields: encryptionutils.NewFieldCipher(encryp, nil)},
 		logger: log,
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
 			var inputChan = make(chan []byte, 10)
-			var outputChan = make(chan []*models.UserDetails, 10)
+			var outputChan = make(chan *models.UserBatch, 10)
 			var errorChan = make(chan error)
 			wg := new(sync.WaitGroup)
 			wg.Add(1)
@@ -196,6 +205,29 @@ func TestToUserDetails(t *testing.T) {
 	}
 }
 
+func TestToUserDetailsUndecodable(t *testing.T) {
+	imports := new(mockdatabase.MockDatabase)
+	imports.On("AddImportProgress", mock.Anything, "import-1", &models.ImportProgress{Consumed: 2, Failures: 2}).Return(nil).Once()
+
+	consumer := &Consumer{logger: zap.NewNop(), imports: imports}
+
+	var inputChan = make(chan []byte, 3)
+	var outputChan = make(chan *models.UserBatch, 3)
+	var errorChan = make(chan error, 3)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.ToUserDetails(wg, inputChan, outputChan, errorChan)
+	inputChan <- []byte(`{"run_id":"import-1","users":[{"id":1},{"id":"two"}]}`)
+	// the import of a truncated message can't be recovered.
+	inputChan <- []byte(`{"run_id":"import-1","users":[{"id":1}`)
+	close(inputChan)
+	wg.Wait()
+
+	assert.Len(t, errorChan, 2)
+	assert.Len(t, outputChan, 0)
+	imports.AssertExpectations(t)
+}
+
 type TestSaveDetails struct {
 	name       string
 	input      []*models.UserDetails
@@ -209,11 +241,14 @@ func TestSaveUserDetails(t *testing.T) {
 
 	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
 	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 
 	mockMemStore := new(mockredis.MockRedis)
 
-	mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(nil)
-	mockUserStoreWithError.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(errors.New("test error"))
+	mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(nil)
+	mockUserStoreWithError.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(errors.New("test error"))
 
 	mockMemStore.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("*models.UserDetails")).Return(nil)
 
@@ -227,15 +262,15 @@ func TestSaveUserDetails(t *testing.T) {
 				{FirstName: "John",
 					LastName:     "Doe",
 					EmailAddress: "john@doe.com",
-					CreatedAt: sql.NullTime{
+					CreatedAt: models.NullTime{
 						Time:  time.Now(),
 						Valid: true,
 					},
-					DeletedAt: sql.NullTime{
+					DeletedAt: models.NullTime{
 						Time:  time.Now(),
 						Valid: true,
 					},
-					MergedAt: sql.NullTime{
+					MergedAt: models.NullTime{
 						Time:  time.Now(),
 						Valid: true,
 					},
@@ -245,7 +280,7 @@ func TestSaveUserDetails(t *testing.T) {
 				queue:     nil,
 				channel:   nil,
 				logger:    log,
-				encryp:    encryp,
+				keys:      &userKeys{store: mockUserStore, fields: fields},
 				userStore: mockUserStore,
 				memStore:  mockMemStore,
 			},
@@ -257,16 +292,16 @@ func TestSaveUserDetails(t *testing.T) {
 				{FirstName: "John",
 					LastName:     "Doe",
 					EmailAddress: "john@doe.com",
-					CreatedAt:    sql.NullTime{},
-					DeletedAt:    sql.NullTime{},
-					MergedAt:     sql.NullTime{},
+					CreatedAt:    models.NullTime{},
+					DeletedAt:    models.NullTime{},
+					MergedAt:     models.NullTime{},
 					ParentUserId: 1},
 			},
 			consumer: &Consumer{
 				queue:     nil,
 				channel:   nil,
 				logger:    log,
-				encryp:    encryp,
+				keys:      &userKeys{store: mockUserStoreWithError, fields: fields},
 				userStore: mockUserStoreWithError,
 				memStore:  mockMemStore,
 			},
@@ -276,12 +311,12 @@ func TestSaveUserDetails(t *testing.T) {
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			var inputChan = make(chan []*models.UserDetails, 10)
+			var inputChan = make(chan *models.UserBatch, 10)
 			var errorChan = make(chan error, 10)
 			wg := new(sync.WaitGroup)
 			wg.Add(1)
 			go testCase.consumer.SaveUserDetails(wg, inputChan, errorChan)
-			inputChan <- testCase.input
+			inputChan <- &models.UserBatch{Users: testCase.input}
 			close(inputChan)
 			if testCase.throwError {
 				assert.Error(t, <-errorChan)
@@ -306,3 +341,45 @@ func TestCloseConsumer(t *testing.T) {
 	err := consumer.Close()
 	assert.NoError(t, err)
 }
+
+func TestSaveUserDetailsProgress(t *testing.T) {
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+
+	userID := func(id int64) interface{} {
+		return mock.MatchedBy(func(user *models.UserDetails) bool { return user.ID == id })
+	}
+
+	userStore := new(mockdatabase.MockDatabase)
+	userStore.On("CreateUser", mock.Anything, userID(1), mock.Anything).Return(nil).Once()
+	userStore.On("CreateUser", mock.Anything, userID(2), mock.Anything).Return(database.ErrDuplicate).Once()
+	userStore.On("CreateUser", mock.Anything, userID(3), mock.Anything).Return(errors.New("test error")).Once()
+	userStore.On("AddImportProgress", mock.Anything, "import-1", &models.ImportProgress{Consumed: 3, Stored: 1, Duplicates: 1, Failures: 1}).Return(nil).Once()
+	memStore := new(mockredis.MockRedis)
+	memStore.On("Set", mock.Anything, "1", mock.Anything).Return(nil).Once()
+
+	consumer := &Consumer{
+		logger:    zap.NewNop(),
+		keys:      &userKeys{store: userStore, fields: fields},
+		userStore: userStore,
+		memStore:  memStore,
+		imports:   userStore,
+	}
+
+	var inputChan = make(chan *models.UserBatch, 2)
+	var errorChan = make(chan error, 10)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.SaveUserDetails(wg, inputChan, errorChan)
+	inputChan <- &models.UserBatch{RunID: "import-1", Users: []*models.UserDetails{{ID: 1}, {ID: 2}, {ID: 3}}}
+	// the batches without run id are not tracked.
+	inputChan <- &models.UserBatch{}
+	close(inputChan)
+	wg.Wait()
+
+	userStore.AssertExpectations(t)
+	memStore.AssertExpectations(t)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+)
+
+// EmailIndexProgress reports how far an email index backfill went.
+type EmailIndexProgress struct {
+	Scanned int64 `json:"scanned"`
+	Indexed int64 `json:"indexed"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+	LastID  int64 `json:"last_id"`
+}
+
+// EmailIndexer fills the email blind index of the users created before it existed, without it they are not found
+// by the email filter of the listing. The users failing are left without index, a next run tries them again.
+type EmailIndexer struct {
+	store     emailIndexStore
+	fields    *encryptionutils.FieldCipher
+	keys      *userKeys
+	logger    *zap.Logger
+	batchSize int64
+}
+
+// NewEmailIndexer returns an EmailIndexer, fields must have an indexer.
+func NewEmailIndexer(store emailIndexStore, fields *encryptionutils.FieldCipher, logger *zap.Logger, batchSize int64) *EmailIndexer {
+	if batchSize <= 0 {
+		batchSize = defaultReencryptionBatchSize
+	}
+	return &EmailIndexer{
+		store:     store,
+		fields:    fields,
+		keys:      &userKeys{store: store, fields: fields},
+		logger:    logger,
+		batchSize: batchSize,
+	}
+}
+
+func (ei *EmailIndexer) Run(ctx context.Context) (*EmailIndexProgress, error) {
+	progress := &EmailIndexProgress{}
+	for {
+		users, err := ei.store.ListUsersWithoutEmailIndex(ctx, progress.LastID, ei.batchSize)
+		if err != nil {
+			return progress, err
+		}
+
+		if len(users) == 0 {
+			return progress, nil
+		}
+
+		ciphers, err := ei.keys.ciphers(ctx, users)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, user := range users {
+			progress.Scanned++
+			err := ei.index(ctx, ciphers[user.ID], user)
+			switch {
+			case errors.Is(err, database.ErrNoData):
+				// the email has been changed meanwhile, it has been indexed with it.
+				progress.Skipped++
+			case err != nil:
+				progress.Failed++
+				ei.logger.Error("failed to index email of user", zap.Int64("user_id", user.ID), zap.Error(err))
+			default:
+				progress.Indexed++
+			}
+		}
+
+		progress.LastID = users[len(users)-1].ID
+		ei.logger.Info("email index progress", zap.Any("progress", progress))
+	}
+}
+
+func (ei *EmailIndexer) index(ctx context.Context, fields *encryptionutils.FieldCipher, user *models.UserDetails) error {
+	opened := *user
+	err := fields.Open(&opened, userAssociatedData(user.ID))
+	if err != nil {
+		return err
+	}
+
+	index, err := ei.fields.BlindIndex(&opened, emailField, opened.EmailAddress)
+	if err != nil {
+		return err
+	}
+	if index == "" {
+		return database.ErrNoData
+	}
+
+	return ei.store.SetEmailIndex(ctx, user, index)
+}
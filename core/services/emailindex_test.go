@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"go.uber.org/zap"
+)
+
+func TestEmailIndexer(t *testing.T) {
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+
+	users := []*models.UserDetails{
+		{ID: 1, EmailAddress: encryptEmail(t, encryp, "John@Example.com", 1)},
+		{ID: 2, EmailAddress: encryptEmail(t, encryp, "jane@example.com", 2)},
+		{ID: 3, EmailAddress: "not a ciphertext"},
+	}
+
+	store := new(mockdatabase.MockDatabase)
+	store.On("ListUsersWithoutEmailIndex", mock.Anything, int64(0), int64(2)).Return(users[:2], nil).Once()
+	store.On("ListUsersWithoutEmailIndex", mock.Anything, int64(2), int64(2)).Return(users[2:], nil).Once()
+	store.On("ListUsersWithoutEmailIndex", mock.Anything, int64(3), int64(2)).Return([]*models.UserDetails{}, nil).Once()
+	store.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil)
+	store.On("SetEmailIndex", mock.Anything, users[0], indexer.EmailIndex("john@example.com")).Return(nil).Once()
+	store.On("SetEmailIndex", mock.Anything, users[1], indexer.EmailIndex("jane@example.com")).Return(errors.New("test error")).Once()
+
+	progress, err := NewEmailIndexer(store, fields, zap.NewNop(), 2).Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &EmailIndexProgress{Scanned: 3, Indexed: 1, Failed: 2, LastID: 3}, progress)
+	store.AssertExpectations(t)
+}
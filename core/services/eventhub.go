@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// eventHubBatchSize is the maximum number of events read at once by the hub.
+	eventHubBatchSize = 100
+	// eventSubscriptionBuffer is the number of events queued for a subscription, a subscription falling further
+	// behind reads the events it missed from the change feed.
+	eventSubscriptionBuffer = 256
+	// eventHubRetryInterval is the pause of the hub after failing to read the change feed.
+	eventHubRetryInterval = time.Second
+)
+
+// EventSubscription receives the events of the change feed after a sequence number, it must be closed.
+type EventSubscription interface {
+	// Next returns up to limit events, it waits up to wait for new events and returns none when no event was
+	// written meanwhile.
+	Next(ctx context.Context, limit int64, wait time.Duration) ([]*models.UserEvent, error)
+	Close()
+}
+
+// eventHub is the only reader of the change feed waiting for new events in the instance, it fans them out to
+// the subscriptions of the instance so the clients of the feed don't each hold a redis connection.
+type eventHub struct {
+	events eventLog
+	logger *zap.Logger
+
+	mu            sync.Mutex
+	subscriptions map[*eventSubscription]struct{}
+}
+
+func newEventHub(events eventLog, logger *zap.Logger) *eventHub {
+	return &eventHub{
+		events:        events,
+		logger:        logger,
+		subscriptions: make(map[*eventSubscription]struct{}),
+	}
+}
+
+// run sends the new events to the subscriptions until ctx is done, every read waits up to wait for new events.
+func (h *eventHub) run(ctx context.Context, wait time.Duration) {
+	var after string
+	for {
+		var err error
+		if after == "" {
+			after, err = h.start(ctx)
+		} else {
+			after, err = h.read(ctx, after, wait)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			h.logger.Error("UserService: error reading events", zap.Error(err), zap.String("after", after))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(eventHubRetryInterval):
+			}
+		}
+	}
+}
+
+// start returns the sequence number of the last event, the subscriptions read the events written before from the
+// change feed.
+func (h *eventHub) start(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	after, err := h.events.LastEventSeq(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscriptions {
+		s.lag()
+	}
+	return after, nil
+}
+
+// read sends the events after the sequence number to the subscriptions and returns the last one.
+func (h *eventHub) read(ctx context.Context, after string, wait time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait+defaultTimeout)
+	defer cancel()
+
+	events, err := h.events.ReadEvents(ctx, after, eventHubBatchSize, wait)
+	if err != nil {
+		return after, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		for s := range h.subscriptions {
+			s.offer(event)
+		}
+		after = event.Seq
+	}
+	return after, nil
+}
+
+func (h *eventHub) subscribe(after string, match func(*models.UserEvent) bool) *eventSubscription {
+	if match == nil {
+		match = func(*models.UserEvent) bool { return true }
+	}
+
+	s := &eventSubscription{
+		hub:        h,
+		match:      match,
+		after:      after,
+		catchingUp: true,
+		live:       make(chan *models.UserEvent, eventSubscriptionBuffer),
+		lagged:     make(chan struct{}, 1),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscriptions[s] = struct{}{}
+	return s
+}
+
+// eventSubscription reads the events it missed from the change feed without waiting, then receives the new events
+// matching from the hub. It reads from the change feed again when it falls behind the hub.
+type eventSubscription struct {
+	hub   *eventHub
+	match func(*models.UserEvent) bool
+	// after is the sequence number of the last event read.
+	after      string
+	catchingUp bool
+	live       chan *models.UserEvent
+
+	// lagging is set when an event is dropped, no event is queued until the subscription catches up again, it is
+	// guarded by hub.mu. lagged is signalled when it is set.
+	lagging bool
+	lagged  chan struct{}
+}
+
+// offer queues the event when it matches, it is called with hub.mu held.
+func (s *eventSubscription) offer(event *models.UserEvent) {
+	if s.lagging || !s.match(event) {
+		return
+	}
+
+	select {
+	case s.live <- event:
+	default:
+		s.lag()
+	}
+}
+
+// lag makes the subscription read the events from the change feed again, it is called with hub.mu held.
+func (s *eventSubscription) lag() {
+	if s.lagging {
+		return
+	}
+	s.lagging = true
+	select {
+	case s.lagged <- struct{}{}:
+	default:
+	}
+}
+
+// resume drops the queued events, they are read from the change feed again, and queues the new events again.
+func (s *eventSubscription) resume() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for len(s.live) > 0 {
+		<-s.live
+	}
+	s.lagging = false
+	s.catchingUp = true
+}
+
+func (s *eventSubscription) Next(ctx context.Context, limit int64, wait time.Duration) ([]*models.UserEvent, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		if s.catchingUp {
+			events, err := s.catchUp(ctx, limit)
+			if err != nil || len(events) > 0 {
+				return events, err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-s.lagged:
+			s.resume()
+		case event := <-s.live:
+			events := s.take(event, limit)
+			if len(events) > 0 {
+				return events, nil
+			}
+		}
+	}
+}
+
+// catchUp reads the matching events of the next page of the change feed without waiting, the subscription is
+// caught up once a page is empty.
+func (s *eventSubscription) catchUp(ctx context.Context, limit int64) ([]*models.UserEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	events, err := s.hub.events.ReadEvents(ctx, s.after, limit, 0)
+	if err != nil {
+		s.hub.logger.Error("UserService: error reading events", zap.Error(err), zap.String("after", s.after))
+		return nil, err
+	}
+	if len(events) == 0 {
+		s.catchingUp = false
+		return nil, nil
+	}
+
+	var matched []*models.UserEvent
+	for _, event := range events {
+		if s.match(event) {
+			matched = append(matched, event)
+		}
+		s.after = event.Seq
+	}
+	return matched, nil
+}
+
+// take returns the event and the queued ones, up to limit, skipping the events already read from the change feed.
+func (s *eventSubscription) take(event *models.UserEvent, limit int64) []*models.UserEvent {
+	var events []*models.UserEvent
+	for {
+		if models.EventSeqAfter(event.Seq, s.after) {
+			events = append(events, event)
+			s.after = event.Seq
+		}
+		if int64(len(events)) >= limit {
+			return events
+		}
+
+		select {
+		case event = <-s.live:
+		default:
+			return events
+		}
+	}
+}
+
+func (s *eventSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	delete(s.hub.subscriptions, s)
+}
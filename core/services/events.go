@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+var ErrEventsDisabled = errors.New("the change feed of the users is disabled")
+
+// recordEvent adds the change of the user to the change feed, the feed is disabled when events is nil.
+// A failure is only logged as the change is already stored, the clients of the feed miss the event.
+func recordEvent(ctx context.Context, events eventLog, logger *zap.Logger, eventType models.EventType, userID int64) {
+	if events == nil {
+		return
+	}
+
+	_, err := events.AppendEvent(ctx, eventType, userID)
+	if err != nil {
+		logger.Error("error recording user event", zap.Error(err), zap.String("type", string(eventType)), zap.Int64("user_id", userID))
+	}
+}
+
+// recordCreated records the creation of the user, and its merge when it is created already merged.
+func recordCreated(ctx context.Context, events eventLog, logger *zap.Logger, user *models.UserDetails) {
+	recordEvent(ctx, events, logger, models.EventCreated, user.ID)
+	if user.Merged() {
+		recordEvent(ctx, events, logger, models.EventMerged, user.ID)
+	}
+}
+
+// SubscribeEvents subscribes to the events after the sequence number selected by match, every event is selected
+// when match is nil. Only the new events are received when after is empty.
+func (us *UserService) SubscribeEvents(ctx context.Context, after string, match func(*models.UserEvent) bool) (EventSubscription, error) {
+	if us.events == nil {
+		return nil, ErrEventsDisabled
+	}
+
+	if after == "" {
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+
+		var err error
+		after, err = us.events.LastEventSeq(ctx)
+		if err != nil {
+			us.logger.Error("UserService: error reading last event", zap.Error(err))
+			return nil, err
+		}
+	} else if _, err := models.ParseEventSeq(after); err != nil {
+		return nil, err
+	}
+
+	return us.hub.subscribe(after, match), nil
+}
+
+// RunEvents sends the new events to the subscriptions of the instance until ctx is done, every read of the change
+// feed waits up to wait for new events. The subscriptions only receive the events written before they subscribed
+// when it is not running.
+func (us *UserService) RunEvents(ctx context.Context, wait time.Duration) {
+	if us.hub == nil {
+		return
+	}
+	us.hub.run(ctx, wait)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/redis/mockredis"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestRecordEvent(t *testing.T) {
+	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("DeleteUser", mock.Anything, "42").Return(nil)
+	mockMemStore := new(mockredis.MockRedis)
+	mockMemStore.On("Delete", mock.Anything, "42").Return(nil)
+
+	t.Run("Success: deletion is recorded", func(t *testing.T) {
+		events := new(mockredis.MockRedis)
+		events.On("AppendEvent", mock.Anything, models.EventDeleted, int64(42)).Return("1709296200000-0", nil).Once()
+
+		err := NewUserService(mockUserStore, mockMemStore, events, nil, zap.NewNop()).DeleteUser(context.Background(), "42")
+		assert.NoError(t, err)
+		events.AssertExpectations(t)
+	})
+
+	t.Run("Success: failing to record doesn't fail the deletion", func(t *testing.T) {
+		events := new(mockredis.MockRedis)
+		events.On("AppendEvent", mock.Anything, models.EventDeleted, int64(42)).Return("", errors.New("test error")).Once()
+
+		err := NewUserService(mockUserStore, mockMemStore, events, nil, zap.NewNop()).DeleteUser(context.Background(), "42")
+		assert.NoError(t, err)
+		events.AssertExpectations(t)
+	})
+
+	t.Run("Fail: deleting a missing user records nothing", func(t *testing.T) {
+		userStore := new(mockdatabase.MockDatabase)
+		userStore.On("DeleteUser", mock.Anything, "43").Return(database.ErrNoData).Once()
+		memStore := new(mockredis.MockRedis)
+		events := new(mockredis.MockRedis)
+
+		err := NewUserService(userStore, memStore, events, nil, zap.NewNop()).DeleteUser(context.Background(), "43")
+		assert.ErrorIs(t, err, database.ErrNoData)
+		events.AssertNotCalled(t, "AppendEvent", mock.Anything, mock.Anything, mock.Anything)
+		memStore.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Success: creating a merged user records the merge", func(t *testing.T) {
+		encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+		assert.NoError(t, err)
+		indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+		assert.NoError(t, err)
+		mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(nil)
+		mockMemStore.On("Set", mock.Anything, mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(nil)
+
+		events := new(mockredis.MockRedis)
+		events.On("AppendEvent", mock.Anything, models.EventCreated, int64(43)).Return("1709296200000-0", nil).Once()
+		events.On("AppendEvent", mock.Anything, models.EventMerged, int64(43)).Return("1709296200000-1", nil).Once()
+		events.On("AppendEvent", mock.Anything, models.EventCreated, int64(44)).Return("1709296200000-2", nil).Once()
+
+		service := NewUserService(mockUserStore, mockMemStore, events, encryptionutils.NewFieldCipher(encryp, indexer), zap.NewNop())
+		assert.NoError(t, service.CreateUser(context.Background(), &models.UserDetails{ID: 43, ParentUserId: 1}))
+		assert.NoError(t, service.CreateUser(context.Background(), &models.UserDetails{ID: 44, ParentUserId: -1}))
+		events.AssertExpectations(t)
+	})
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	at := time.UnixMilli(1709296200000).UTC()
+	created := &models.UserEvent{Seq: "1709296200000-0", Type: models.EventCreated, UserID: 1, At: at}
+	deleted := &models.UserEvent{Seq: "1709296200000-1", Type: models.EventDeleted, UserID: 1, At: at}
+	erased := &models.UserEvent{Seq: "1709296200000-2", Type: models.EventErased, UserID: 1, At: at}
+	notDeleted := func(event *models.UserEvent) bool { return event.Type != models.EventDeleted }
+
+	t.Run("Success: missed events then new events of the hub", func(t *testing.T) {
+		events := new(mockredis.MockRedis)
+		events.On("LastEventSeq", mock.Anything).Return("1709296100000-0", nil).Once()
+		events.On("ReadEvents", mock.Anything, "1709296100000-0", int64(10), time.Duration(0)).Return([]*models.UserEvent{created, deleted}, nil).Once()
+		events.On("ReadEvents", mock.Anything, deleted.Seq, int64(10), time.Duration(0)).Return([]*models.UserEvent(nil), nil).Once()
+		events.On("ReadEvents", mock.Anything, "1709296100000-0", int64(eventHubBatchSize), time.Second).Return([]*models.UserEvent{created, deleted, erased}, nil).Once()
+
+		service := NewUserService(nil, nil, events, nil, zap.NewNop())
+		subscription, err := service.SubscribeEvents(context.Background(), "", notDeleted)
+		assert.NoError(t, err)
+		defer subscription.Close()
+
+		received, err := subscription.Next(context.Background(), 10, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []*models.UserEvent{created}, received)
+
+		// the hub sends the events to every subscription, the events already read are skipped.
+		after, err := service.hub.read(context.Background(), "1709296100000-0", time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, erased.Seq, after)
+
+		received, err = subscription.Next(context.Background(), 10, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []*models.UserEvent{erased}, received)
+
+		received, err = subscription.Next(context.Background(), 10, time.Millisecond)
+		assert.NoError(t, err)
+		assert.Empty(t, received)
+		events.AssertExpectations(t)
+	})
+
+	t.Run("Success: lagging subscription reads the change feed again", func(t *testing.T) {
+		events := new(mockredis.MockRedis)
+		events.On("ReadEvents", mock.Anything, created.Seq, int64(10), time.Duration(0)).Return([]*models.UserEvent(nil), nil).Once()
+		events.On("ReadEvents", mock.Anything, created.Seq, int64(eventHubBatchSize), time.Second).Return([]*models.UserEvent{deleted}, nil).Once()
+		events.On("ReadEvents", mock.Anything, created.Seq, int64(10), time.Duration(0)).Return([]*models.UserEvent{deleted}, nil).Once()
+
+		service := NewUserService(nil, nil, events, nil, zap.NewNop())
+		subscription, err := service.SubscribeEvents(context.Background(), created.Seq, nil)
+		assert.NoError(t, err)
+		defer subscription.Close()
+
+		// the hub restarting makes the subscriptions read the events written meanwhile, none is queued until then.
+		service.hub.mu.Lock()
+		subscription.(*eventSubscription).lag()
+		service.hub.mu.Unlock()
+		_, err = service.hub.read(context.Background(), created.Seq, time.Second)
+		assert.NoError(t, err)
+		assert.Len(t, subscription.(*eventSubscription).live, 0)
+
+		received, err := subscription.Next(context.Background(), 10, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []*models.UserEvent{deleted}, received)
+		events.AssertExpectations(t)
+	})
+
+	t.Run("Fail: invalid sequence number", func(t *testing.T) {
+		service := NewUserService(nil, nil, new(mockredis.MockRedis), nil, zap.NewNop())
+		_, err := service.SubscribeEvents(context.Background(), "latest", nil)
+		assert.ErrorIs(t, err, models.ErrInvalidEventSeq)
+	})
+
+	t.Run("Fail: change feed disabled", func(t *testing.T) {
+		service := NewUserService(nil, nil, nil, nil, zap.NewNop())
+		_, err := service.SubscribeEvents(context.Background(), "", nil)
+		assert.ErrorIs(t, err, ErrEventsDisabled)
+	})
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"github.com/viswals_task/core/models"
+)
+
+// exportBatchSize is the number of users fetched from the database cursor and decrypted at a time.
+var exportBatchSize = 500
+
+// ExportUsers calls write with every user matching the filters of the query, in its order, decrypted like the users
+// of GetAllUsers. The limit of the query is ignored, the users are streamed a batch at a time.
+func (us *UserService) ExportUsers(ctx context.Context, query *models.UserQuery, write func(*models.UserDetails) error) error {
+	exportQuery, err := us.pageQuery(query)
+	if err != nil {
+		return err
+	}
+	exportQuery.Limit = 0
+
+	return us.dataStore.StreamUsers(ctx, exportQuery, exportBatchSize, func(users []*models.UserDetails) error {
+		err := us.openUsers(ctx, users)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			err := write(user)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
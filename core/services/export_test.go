@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestExportUsers(t *testing.T) {
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+
+	sealed := func(id int64) *models.UserDetails {
+		user := &models.UserDetails{ID: id, FirstName: "john", EmailAddress: "john@example.com"}
+		assert.NoError(t, fields.Seal(user, userAssociatedData(id)))
+		return user
+	}
+
+	t.Run("Success: users decrypted batch by batch", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("StreamUsers", mock.Anything, mock.MatchedBy(func(query *models.UserQuery) bool {
+			return query.EmailIndex == indexer.EmailIndex("john@example.com") && query.Email == "" && query.Limit == 0
+		}), exportBatchSize).Return([][]*models.UserDetails{{sealed(1), sealed(2)}, {sealed(3)}}, nil).Once()
+		dataStore.On("GetUserKeys", mock.Anything, []int64{1, 2}).Return(map[int64]string{}, nil).Once()
+		dataStore.On("GetUserKeys", mock.Anything, []int64{3}).Return(map[int64]string{}, nil).Once()
+
+		var emails []string
+		ctx := models.WithRole(context.Background(), models.RoleSupport)
+		err := NewUserService(dataStore, nil, nil, fields, zap.NewNop()).ExportUsers(ctx, &models.UserQuery{Limit: 50, Email: "john@example.com"}, func(user *models.UserDetails) error {
+			emails = append(emails, user.EmailAddress)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"j***@example.com", "j***@example.com", "j***@example.com"}, emails)
+		dataStore.AssertExpectations(t)
+	})
+
+	t.Run("Fail: write error stops the export", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+		dataStore.On("StreamUsers", mock.Anything, mock.Anything, exportBatchSize).Return([][]*models.UserDetails{{sealed(1), sealed(2)}, {sealed(3)}}, nil).Once()
+		dataStore.On("GetUserKeys", mock.Anything, []int64{1, 2}).Return(map[int64]string{}, nil).Once()
+
+		written := 0
+		err := NewUserService(dataStore, nil, nil, fields, zap.NewNop()).ExportUsers(adminContext(), &models.UserQuery{}, func(user *models.UserDetails) error {
+			written++
+			return errors.New("test error")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, written)
+		dataStore.AssertExpectations(t)
+	})
+
+	t.Run("Fail: encrypted sort field", func(t *testing.T) {
+		err := NewUserService(new(mockdatabase.MockDatabase), nil, nil, fields, zap.NewNop()).ExportUsers(adminContext(), &models.UserQuery{SortBy: models.SortField("last_name")}, func(*models.UserDetails) error { return nil })
+		assert.ErrorIs(t, err, models.ErrUnsortableField)
+	})
+}
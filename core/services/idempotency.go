@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// IdempotencyService stores the responses of the requests sent with an idempotency key, they are replayed to the
+// retries of the requests for the window. A request in progress holds its key for the lease, which has to be longer
+// than the requests.
+type IdempotencyService struct {
+	store  idempotencyStore
+	window time.Duration
+	lease  time.Duration
+	logger *zap.Logger
+}
+
+func NewIdempotencyService(store idempotencyStore, window, lease time.Duration, logger *zap.Logger) *IdempotencyService {
+	return &IdempotencyService{
+		store:  store,
+		window: window,
+		lease:  lease,
+		logger: logger,
+	}
+}
+
+// Reserve stores the key of a request in progress, the record stored for the key is returned when it is already used,
+// the request must not be handled then. The key of a request which did not complete within its lease is taken over.
+func (is *IdempotencyService) Reserve(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	record.ExpiresAt = now.Add(is.window)
+	record.LeaseExpiresAt = now.Add(is.lease)
+	stored, err := is.store.ReserveIdempotencyKey(ctx, record)
+	if err != nil {
+		is.logger.Error("IdempotencyService: error reserving idempotency key", zap.Error(err), zap.String("scope", record.Scope))
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Complete stores the response of the request, it is replayed to the retries.
+func (is *IdempotencyService) Complete(ctx context.Context, record *models.IdempotencyRecord) error {
+	err := is.store.CompleteIdempotencyKey(ctx, record)
+	if err != nil {
+		is.logger.Error("IdempotencyService: error storing idempotent response", zap.Error(err), zap.String("scope", record.Scope))
+	}
+	return err
+}
+
+// Release frees the key of a request which failed without response so that it can be retried.
+func (is *IdempotencyService) Release(ctx context.Context, record *models.IdempotencyRecord) error {
+	err := is.store.ReleaseIdempotencyKey(ctx, record)
+	if err != nil {
+		is.logger.Error("IdempotencyService: error releasing idempotency key", zap.Error(err), zap.String("scope", record.Scope))
+	}
+	return err
+}
+
+// Run deletes the expired keys every interval until ctx is done.
+func (is *IdempotencyService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruned, err := is.store.PruneIdempotencyKeys(ctx, time.Now())
+		if err != nil {
+			is.logger.Error("IdempotencyService: error pruning expired keys", zap.Error(err))
+			continue
+		}
+		if pruned > 0 {
+			is.logger.Info("expired idempotency keys pruned", zap.Int64("count", pruned))
+		}
+	}
+}
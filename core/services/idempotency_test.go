@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	store := new(mockdatabase.MockDatabase)
+	service := NewIdempotencyService(store, 24*time.Hour, time.Minute, zap.NewNop())
+
+	var reserved *models.IdempotencyRecord
+	store.On("ReserveIdempotencyKey", mock.Anything, mock.AnythingOfType("*models.IdempotencyRecord")).Run(func(args mock.Arguments) {
+		reserved = args.Get(1).(*models.IdempotencyRecord)
+	}).Return((*models.IdempotencyRecord)(nil), nil).Once()
+
+	start := time.Now()
+	stored, err := service.Reserve(context.Background(), &models.IdempotencyRecord{Scope: "POST /users", Key: "key-1"})
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// the key is held for the lease, its response is kept for the window.
+	assert.WithinDuration(t, start.Add(time.Minute), reserved.LeaseExpiresAt, time.Second)
+	assert.WithinDuration(t, start.Add(24*time.Hour), reserved.ExpiresAt, time.Second)
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/csvutils"
+	"go.uber.org/zap"
+	"io"
+)
+
+// importColumns is the number of columns of the user csv files, see Producer.CsvToStruct.
+const importColumns = 8
+
+var ErrInvalidCSV = errors.New("invalid csv file")
+
+// Importer publishes the users of csv files to the ingestion queue and tracks the imports, it is used by cmd/producer
+// and POST /imports. The imports are not tracked without store.
+type Importer struct {
+	queue     queuePublisher
+	store     importStore
+	batchSize int
+	logger    *zap.Logger
+}
+
+func NewImporter(queue queuePublisher, store importStore, batchSize int, logger *zap.Logger) *Importer {
+	return &Importer{
+		queue:     queue,
+		store:     store,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// Import streams the csv file to the queue batch by batch and returns the id of the import, the file is never held in
+// memory. The batches published before an error are consumed, the id is returned with the error to follow them.
+func (im *Importer) Import(file io.Reader, name string) (string, error) {
+	id, err := newImportID()
+	if err != nil {
+		return "", err
+	}
+
+	logger := im.logger.With(zap.String("import_id", id), zap.String("file", name))
+
+	csvReader, err := csvutils.NewReader(file)
+	if err != nil {
+		return id, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+	}
+
+	if csvReader.FieldsPerRecord != importColumns {
+		return id, fmt.Errorf("%w: expected %d columns, got %d", ErrInvalidCSV, importColumns, csvReader.FieldsPerRecord)
+	}
+
+	if im.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err = im.store.CreateImport(ctx, &models.Import{ID: id, Source: name})
+		cancel()
+		if err != nil {
+			logger.Error("Importer: error creating import", zap.Error(err))
+			return id, err
+		}
+	}
+
+	logger.Info("import started")
+	producer := NewProducer(csvReader, im.queue, logger)
+	producer.runID = id
+	producer.imports = im.store
+	err = producer.Start(im.batchSize)
+	if err != nil {
+		logger.Error("Importer: import stopped", zap.Error(err))
+		im.finish(logger, id, models.ImportFailed, err.Error())
+		return id, err
+	}
+
+	logger.Info("import published")
+	im.finish(logger, id, models.ImportPublished, "")
+	return id, nil
+}
+
+func (im *Importer) finish(logger *zap.Logger, id string, status models.ImportStatus, reason string) {
+	if im.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := im.store.FinishImport(ctx, id, status, reason)
+	if err != nil {
+		logger.Error("Importer: error finishing import", zap.Error(err), zap.String("status", string(status)))
+	}
+}
+
+func (im *Importer) GetImport(ctx context.Context, id string) (*models.Import, error) {
+	return im.store.GetImport(ctx, id)
+}
+
+// ListImports returns the last imports, most recent first.
+func (im *Importer) ListImports(ctx context.Context, limit int64) ([]*models.Import, error) {
+	return im.store.ListImports(ctx, limit)
+}
+
+func newImportID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
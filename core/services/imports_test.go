@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/rabbitmq/mockrabbitmq"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	header := "id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n"
+	row := "1,john,doe,john@example.com,1737481973,-1,-1,-1\n"
+	invalidRow := "one,john,doe,john@example.com,1737481973,-1,-1,-1\n"
+
+	t.Run("Success: rows published in batches", func(t *testing.T) {
+		queue := new(mockrabbitmq.MockRabbitMQ)
+		queue.On("Publish", mock.Anything, mock.AnythingOfType("[]uint8")).Return(nil).Times(2)
+
+		id, err := NewImporter(queue, nil, 2, zap.NewNop()).Import(strings.NewReader(header+row+row+row), "users.csv")
+		assert.NoError(t, err)
+		assert.Len(t, id, 32)
+		queue.AssertExpectations(t)
+	})
+
+	t.Run("Success: import tracked", func(t *testing.T) {
+		queue := new(mockrabbitmq.MockRabbitMQ)
+		var runIDs []string
+		queue.On("Publish", mock.Anything, mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+			batch, err := decodeBatch(args.Get(1).([]byte))
+			assert.NoError(t, err)
+			runIDs = append(runIDs, batch.RunID)
+		}).Return(nil).Times(2)
+
+		store := new(mockdatabase.MockDatabase)
+		store.On("CreateImport", mock.Anything, mock.MatchedBy(func(imp *models.Import) bool { return imp.Source == "users.csv" })).Return(nil).Once()
+		store.On("AddImportProgress", mock.Anything, mock.Anything, &models.ImportProgress{RowsRead: 2, Published: 2}).Return(nil).Once()
+		store.On("AddImportProgress", mock.Anything, mock.Anything, &models.ImportProgress{RowsRead: 2, Published: 1, Failures: 1}).Return(nil).Once()
+		store.On("FinishImport", mock.Anything, mock.Anything, models.ImportPublished, "").Return(nil).Once()
+
+		id, err := NewImporter(queue, store, 2, zap.NewNop()).Import(strings.NewReader(header+row+row+row+invalidRow), "users.csv")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{id, id}, runIDs)
+		queue.AssertExpectations(t)
+		store.AssertExpectations(t)
+	})
+
+	testCases := map[string]string{
+		"Fail: empty file":          "",
+		"Fail: wrong column number": "id,first_name\n1,john\n",
+	}
+
+	for name, file := range testCases {
+		t.Run(name, func(t *testing.T) {
+			queue := new(mockrabbitmq.MockRabbitMQ)
+			store := new(mockdatabase.MockDatabase)
+
+			_, err := NewImporter(queue, store, 2, zap.NewNop()).Import(strings.NewReader(file), "users.csv")
+			assert.ErrorIs(t, err, ErrInvalidCSV)
+			queue.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything)
+			store.AssertNotCalled(t, "CreateImport", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("Fail: queue unavailable", func(t *testing.T) {
+		queue := new(mockrabbitmq.MockRabbitMQ)
+		queue.On("Publish", mock.Anything, mock.AnythingOfType("[]uint8")).Return(errors.New("test error")).Once()
+		store := new(mockdatabase.MockDatabase)
+		store.On("CreateImport", mock.Anything, mock.Anything).Return(nil).Once()
+		store.On("FinishImport", mock.Anything, mock.Anything, models.ImportFailed, "test error").Return(nil).Once()
+
+		id, err := NewImporter(queue, store, 2, zap.NewNop()).Import(strings.NewReader(header+row), "users.csv")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidCSV)
+		assert.NotEmpty(t, id)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: import not created", func(t *testing.T) {
+		queue := new(mockrabbitmq.MockRabbitMQ)
+		store := new(mockdatabase.MockDatabase)
+		store.On("CreateImport", mock.Anything, mock.Anything).Return(errors.New("test error")).Once()
+
+		_, err := NewImporter(queue, store, 2, zap.NewNop()).Import(strings.NewReader(header+row), "users.csv")
+		assert.Error(t, err)
+		queue.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything)
+	})
+}
+
+func TestListImports(t *testing.T) {
+	store := new(mockdatabase.MockDatabase)
+	store.On("ListImports", mock.Anything, int64(10)).Return([]*models.Import{{ID: "import-1"}}, nil).Once()
+
+	imports, err := NewImporter(nil, store, 2, zap.NewNop()).ListImports(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*models.Import{{ID: "import-1"}}, imports)
+}
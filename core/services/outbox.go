@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// outboxPruneInterval is the interval between two deletions of the published events.
+const outboxPruneInterval = 10 * time.Minute
+
+// OutboxRelay publishes the outbox events to the message broker with the routing key user.<type>, e.g. user.created.
+// Every event is published at least once, the consumers drop duplicates with the message id, the id of the event.
+// The events are published in the order of the changes, so the events of a user are received in order.
+type OutboxRelay struct {
+	store     outboxStore
+	publisher eventPublisher
+	batchSize int
+	// retention is how long the published events are kept before being pruned.
+	retention time.Duration
+	logger    *zap.Logger
+}
+
+func NewOutboxRelay(store outboxStore, publisher eventPublisher, batchSize int, retention time.Duration, logger *zap.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		batchSize: batchSize,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run relays the events until ctx is done, it waits for the interval once every event has been published
+// or after a failure.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pruned time.Time
+	for {
+		published, err := r.Relay(ctx)
+		if err != nil {
+			r.logger.Error("OutboxRelay: error publishing events", zap.Error(err))
+		}
+
+		// a full batch means more events are waiting.
+		if err == nil && published == r.batchSize {
+			continue
+		}
+
+		if time.Since(pruned) >= outboxPruneInterval {
+			r.prune(ctx)
+			pruned = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes a batch of events and returns the number of events published.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	return r.store.RelayOutbox(ctx, r.batchSize, r.publish)
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, events []*models.OutboxEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		err = r.publisher.Publish(ctx, "user."+string(event.Type), strconv.FormatInt(event.ID, 10), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *OutboxRelay) prune(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+
+	pruned, err := r.store.PruneOutbox(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		r.logger.Error("OutboxRelay: error pruning published events", zap.Error(err))
+		return
+	}
+	if pruned > 0 {
+		r.logger.Debug("OutboxRelay: pruned published events", zap.Int64("pruned", pruned))
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/rabbitmq/mockrabbitmq"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	events := []*models.OutboxEvent{
+		{ID: 7, Type: models.EventCreated, UserID: 1, At: at},
+		{ID: 8, Type: models.EventDeleted, UserID: 1, At: at},
+	}
+
+	t.Run("Success: publish events in order", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		store.On("RelayOutbox", mock.Anything, 10).Return(events, nil).Once()
+		exchange := new(mockrabbitmq.MockExchange)
+		first := exchange.On("Publish", mock.Anything, "user.created", "7", []byte(`{"id":7,"type":"created","user_id":1,"at":"2024-03-01T12:30:00Z"}`)).Return(nil).Once()
+		exchange.On("Publish", mock.Anything, "user.deleted", "8", []byte(`{"id":8,"type":"deleted","user_id":1,"at":"2024-03-01T12:30:00Z"}`)).Return(nil).Once().NotBefore(first)
+
+		published, err := NewOutboxRelay(store, exchange, 10, time.Hour, zap.NewNop()).Relay(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, published)
+		exchange.AssertExpectations(t)
+	})
+
+	t.Run("Fail: events not confirmed are published again", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		store.On("RelayOutbox", mock.Anything, 10).Return(events, nil).Once()
+		exchange := new(mockrabbitmq.MockExchange)
+		exchange.On("Publish", mock.Anything, "user.created", "7", mock.Anything).Return(errors.New("test error")).Once()
+
+		published, err := NewOutboxRelay(store, exchange, 10, time.Hour, zap.NewNop()).Relay(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, 0, published)
+		exchange.AssertExpectations(t)
+	})
+
+	t.Run("Success: run until cancelled and prune published events", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		store := new(mockdatabase.MockDatabase)
+		// a full batch is followed by another one without waiting.
+		store.On("RelayOutbox", mock.Anything, 2).Return(events, nil).Once()
+		store.On("RelayOutbox", mock.Anything, 2).Return([]*models.OutboxEvent{}, nil).Once()
+		store.On("PruneOutbox", mock.Anything, mock.MatchedBy(func(before time.Time) bool {
+			return time.Since(before) >= time.Hour
+		})).Return(int64(3), nil).Once().Run(func(mock.Arguments) { cancel() })
+		exchange := new(mockrabbitmq.MockExchange)
+		exchange.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
+
+		done := make(chan struct{})
+		go func() {
+			NewOutboxRelay(store, exchange, 2, time.Hour, zap.NewNop()).Run(ctx, time.Hour)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("relay did not stop")
+		}
+		store.AssertExpectations(t)
+		exchange.AssertExpectations(t)
+	})
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"database/sql"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -22,6 +21,9 @@ type Producer struct {
 	csvReader *csv.Reader
 	queue     queuePublisher
 	logger    *zap.Logger
+	// runID is the id of the import sent with the batches, its progress is added to imports when set.
+	runID   string
+	imports importStore
 }
 
 func NewProducer(csvReader *csv.Reader, queue queuePublisher, logger *zap.Logger) *Producer {
@@ -49,13 +51,16 @@ func (p *Producer) Start(batchSize int) error {
 				return err
 			}
 		}
+		read := int64(len(rows))
 		if invalidData != nil {
+			read++
 			p.logger.Warn("Invalid Data Found in csv.",zap.Any("data",invalidData))
 		}
 
 		// transform fetched rows to user struct
 		data := p.CsvToStruct(rows)
 		if data == nil {
+			p.recordProgress(&models.ImportProgress{RowsRead: read, Failures: read})
 			continue
 		}
 
@@ -69,6 +74,7 @@ func (p *Producer) Start(batchSize int) error {
 		}
 		p.logger.Debug("Published Messages",zap.Int("count",len(data)))
 		cancel()
+		p.recordProgress(&models.ImportProgress{RowsRead: read, Published: int64(len(data)), Failures: read - int64(len(data))})
 
 		if isLastRecord {
 			break
@@ -79,7 +85,7 @@ func (p *Producer) Start(batchSize int) error {
 }
 
 func (p *Producer) Publish(ctx context.Context, data []*models.UserDetails) error {
-	jsonData, err := json.Marshal(data)
+	jsonData, err := json.Marshal(&models.UserBatch{RunID: p.runID, Users: data})
 	if err != nil {
 		p.logger.Error("error marshaling data to queue",zap.Error(err))
 		return err
@@ -92,6 +98,21 @@ func (p *Producer) Publish(ctx context.Context, data []*models.UserDetails) erro
 	return nil
 }
 
+// recordProgress adds the counts of a batch to the import, the import is only missing counts when it fails.
+func (p *Producer) recordProgress(progress *models.ImportProgress) {
+	if p.imports == nil || p.runID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := p.imports.AddImportProgress(ctx, p.runID, progress)
+	if err != nil {
+		p.logger.Warn("Producer: error recording import progress", zap.Error(err), zap.String("import_id", p.runID))
+	}
+}
+
 func (p *Producer) CsvToStruct(data [][]string) []*models.UserDetails {
 	var result []*models.UserDetails
 
@@ -123,11 +144,11 @@ func (p *Producer) CsvToStruct(data [][]string) []*models.UserDetails {
 			continue
 		} else {
 			if miliSec == -1 {
-				userDetails.CreatedAt = sql.NullTime{
+				userDetails.CreatedAt = models.NullTime{
 					Valid: false,
 				}
 			} else {
-				userDetails.CreatedAt = sql.NullTime{
+				userDetails.CreatedAt = models.NullTime{
 					Time:  time.UnixMilli(miliSec),
 					Valid: true,
 				}
@@ -141,11 +162,11 @@ func (p *Producer) CsvToStruct(data [][]string) []*models.UserDetails {
 			continue
 		} else {
 			if miliSec == -1 {
-				userDetails.DeletedAt = sql.NullTime{
+				userDetails.DeletedAt = models.NullTime{
 					Valid: false,
 				}
 			} else {
-				userDetails.DeletedAt = sql.NullTime{
+				userDetails.DeletedAt = models.NullTime{
 					Time:  time.UnixMilli(miliSec),
 					Valid: true,
 				}
@@ -159,11 +180,11 @@ func (p *Producer) CsvToStruct(data [][]string) []*models.UserDetails {
 			continue
 		} else {
 			if miliSec == -1 {
-				userDetails.MergedAt = sql.NullTime{
+				userDetails.MergedAt = models.NullTime{
 					Valid: false,
 				}
 			} else {
-				userDetails.MergedAt = sql.NullTime{
+				userDetails.MergedAt = models.NullTime{
 					Time:  time.UnixMilli(miliSec),
 					Valid: true,
 				}
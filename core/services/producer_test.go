@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -71,9 +70,9 @@ func TestPublish(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test",
-					CreatedAt:    sql.NullTime{},
-					DeletedAt:    sql.NullTime{},
-					MergedAt:     sql.NullTime{},
+					CreatedAt:    models.NullTime{},
+					DeletedAt:    models.NullTime{},
+					MergedAt:     models.NullTime{},
 					ParentUserId: 0,
 				},
 			},
@@ -90,9 +89,9 @@ func TestPublish(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test",
-					CreatedAt:    sql.NullTime{},
-					DeletedAt:    sql.NullTime{},
-					MergedAt:     sql.NullTime{},
+					CreatedAt:    models.NullTime{},
+					DeletedAt:    models.NullTime{},
+					MergedAt:     models.NullTime{},
 					ParentUserId: 0,
 				},
 			},
@@ -133,15 +132,15 @@ func TestCsvParser(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test@test.com",
-					CreatedAt: sql.NullTime{
+					CreatedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					DeletedAt: sql.NullTime{
+					DeletedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					MergedAt: sql.NullTime{
+					MergedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
@@ -162,12 +161,12 @@ func TestCsvParser(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test@test.com",
-					CreatedAt:    sql.NullTime{},
-					DeletedAt: sql.NullTime{
+					CreatedAt:    models.NullTime{},
+					DeletedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					MergedAt: sql.NullTime{
+					MergedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
@@ -177,12 +176,12 @@ func TestCsvParser(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test@test.com",
-					CreatedAt: sql.NullTime{
+					CreatedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					DeletedAt: sql.NullTime{},
-					MergedAt: sql.NullTime{
+					DeletedAt: models.NullTime{},
+					MergedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
@@ -192,15 +191,15 @@ func TestCsvParser(t *testing.T) {
 					FirstName:    "test",
 					LastName:     "test",
 					EmailAddress: "test@test.com",
-					CreatedAt: sql.NullTime{
+					CreatedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					DeletedAt: sql.NullTime{
+					DeletedAt: models.NullTime{
 						Time:  time.UnixMilli(1737481973),
 						Valid: true,
 					},
-					MergedAt:     sql.NullTime{},
+					MergedAt:     models.NullTime{},
 					ParentUserId: -1,
 				},
 			},
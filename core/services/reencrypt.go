@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
+	"go.uber.org/zap"
+)
+
+var (
+	defaultReencryptionBatchSize int64 = 500
+)
+
+// ReencryptionProgress reports how far a re-encryption run went.
+type ReencryptionProgress struct {
+	Scanned     int64 `json:"scanned"`
+	Reencrypted int64 `json:"reencrypted"`
+	Skipped     int64 `json:"skipped"`
+	Failed      int64 `json:"failed"`
+	// LastID is the checkpoint, the users up to it are re-encrypted. It stays before the first user failing so the
+	// next run tries it again.
+	LastID int64 `json:"last_id"`
+}
+
+// Reencryptor walks user_details in id order and re-encrypts the personal data which is not encrypted with the
+// key of its user, values stored in plaintext before their field was encrypted get encrypted.
+// Users created before user keys existed get a key and keys not wrapped with the active master key are wrapped again.
+// The checkpoint is saved after every batch so an interrupted run resumes from there, it never moves past a user
+// failing to re-encrypt.
+type Reencryptor struct {
+	dataStore   dataStoreProvider
+	checkpoints checkpointStore
+	// memStore is optional, cached entries of re-encrypted users are removed when set.
+	memStore  memoryStoreProvider
+	fields    *encryptionutils.FieldCipher
+	keys      *userKeys
+	logger    *zap.Logger
+	batchSize int64
+}
+
+func NewReencryptor(dataStore dataStoreProvider, checkpoints checkpointStore, memStore memoryStoreProvider, fields *encryptionutils.FieldCipher, logger *zap.Logger, batchSize int64) *Reencryptor {
+	if batchSize <= 0 {
+		batchSize = defaultReencryptionBatchSize
+	}
+	return &Reencryptor{
+		dataStore:   dataStore,
+		checkpoints: checkpoints,
+		memStore:    memStore,
+		fields:      fields,
+		keys:        &userKeys{store: dataStore, fields: fields},
+		logger:      logger,
+		batchSize:   batchSize,
+	}
+}
+
+// checkpointName is per active key, rotating to a new key starts a new walk of the table.
+func (r *Reencryptor) checkpointName() string {
+	return "reencrypt:" + r.fields.ActiveKeyID()
+}
+
+// Reset forgets the checkpoint of the active key so the next run starts from the first user.
+func (r *Reencryptor) Reset(ctx context.Context) error {
+	return r.checkpoints.SaveCheckpoint(ctx, r.checkpointName(), 0)
+}
+
+func (r *Reencryptor) Run(ctx context.Context) (*ReencryptionProgress, error) {
+	lastID, err := r.checkpoints.GetCheckpoint(ctx, r.checkpointName())
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &ReencryptionProgress{LastID: lastID}
+	// cursor is the last user processed, it moves past the users failing unlike the checkpoint.
+	cursor := lastID
+	failed := false
+	if lastID > 0 {
+		r.logger.Info("resuming re-encryption from checkpoint", zap.Int64("last_id", lastID), zap.String("key_id", r.fields.ActiveKeyID()))
+	}
+
+	for {
+		query := &models.UserQuery{
+			Limit:  r.batchSize,
+			SortBy: models.SortByID,
+			After:  &models.Cursor{SortBy: models.SortByID, ID: cursor},
+		}
+
+		users, err := r.dataStore.FilterUsers(ctx, query)
+		if err != nil {
+			return progress, err
+		}
+
+		if len(users) == 0 {
+			break
+		}
+
+		userIDs := make([]int64, 0, len(users))
+		for _, user := range users {
+			userIDs = append(userIDs, user.ID)
+		}
+
+		wrappedKeys, err := r.dataStore.GetUserKeys(ctx, userIDs)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, user := range users {
+			progress.Scanned++
+			err := r.reencrypt(ctx, user, wrappedKeys[user.ID])
+			switch {
+			case errors.Is(err, errAlreadyReencrypted):
+				progress.Skipped++
+			case err != nil:
+				progress.Failed++
+				failed = true
+				r.logger.Error("failed to re-encrypt user", zap.Int64("user_id", user.ID), zap.Error(err))
+			default:
+				progress.Reencrypted++
+			}
+
+			if !failed {
+				progress.LastID = user.ID
+			}
+		}
+
+		cursor = users[len(users)-1].ID
+
+		if progress.LastID > lastID {
+			lastID = progress.LastID
+			err = r.checkpoints.SaveCheckpoint(ctx, r.checkpointName(), lastID)
+			if err != nil {
+				return progress, fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+
+		r.logger.Info("re-encryption progress", zap.Any("progress", progress))
+
+		if int64(len(users)) < r.batchSize {
+			break
+		}
+	}
+
+	return progress, nil
+}
+
+var errAlreadyReencrypted = errors.New("already encrypted with the active key")
+
+func (r *Reencryptor) reencrypt(ctx context.Context, user *models.UserDetails, wrappedKey string) error {
+	fields, keyChanged, err := r.keys.current(ctx, user.ID, wrappedKey)
+	if err != nil {
+		if errors.Is(err, database.ErrNoData) {
+			// the key has been changed or the user erased meanwhile, the next run picks up what is left.
+			return errAlreadyReencrypted
+		}
+		return err
+	}
+
+	if !fields.NeedsReencryption(user) {
+		if keyChanged {
+			return nil
+		}
+		return errAlreadyReencrypted
+	}
+
+	updated := *user
+	err = fields.Reencrypt(&updated, userAssociatedData(user.ID))
+	if err != nil {
+		return err
+	}
+
+	err = r.dataStore.UpdateUserPII(ctx, user, &updated)
+	if err != nil {
+		if errors.Is(err, database.ErrNoData) {
+			// the user has been changed or deleted meanwhile, it is written with its key anyway.
+			return errAlreadyReencrypted
+		}
+		return err
+	}
+
+	if r.memStore != nil {
+		err = r.memStore.Delete(ctx, fmt.Sprint(user.ID))
+		if err != nil {
+			r.logger.Warn("Reencryptor: error deleting user from cache", zap.Int64("user_id", user.ID), zap.Error(err))
+		}
+	}
+
+	return nil
+}
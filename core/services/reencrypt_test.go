@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/redis/mockredis"
+	"go.uber.org/zap"
+)
+
+func TestReencryptor(t *testing.T) {
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+
+	oldRing, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	oldFields := encryptionutils.NewFieldCipher(oldRing, nil)
+
+	keys, err := encryptionutils.ParseKeys("2025:0123456789abcdef0123456789abcdef")
+	assert.NoError(t, err)
+	keys[encryptionutils.DefaultKeyID] = []byte("testtesttesttest")
+	newRing, err := encryptionutils.NewKeyring("2025", keys)
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(newRing, nil)
+	userKeys := &userKeys{fields: fields}
+
+	// user 2 is already encrypted with its key, the key of user 3 is still wrapped with the old master key.
+	rawKey, currentKey, err := fields.GenerateUserKey(userKeyAssociatedData(2))
+	assert.NoError(t, err)
+	userFields, err := fields.ForUser(rawKey)
+	assert.NoError(t, err)
+	_, oldKey, err := oldFields.GenerateUserKey(userKeyAssociatedData(3))
+	assert.NoError(t, err)
+
+	users := []*models.UserDetails{
+		{ID: 1, FirstName: "john", EmailAddress: encryptEmail(t, oldRing, "test@test.com", 1)},
+		{ID: 2, EmailAddress: encryptEmail(t, newRing, "test@test.com", 2)},
+		{ID: 3},
+		{ID: 4, EmailAddress: "not a ciphertext"},
+	}
+	assert.NoError(t, userFields.Reencrypt(users[1], userAssociatedData(2)))
+
+	afterID := func(id int64) interface{} {
+		return mock.MatchedBy(func(q *models.UserQuery) bool { return q.After != nil && q.After.ID == id })
+	}
+
+	t.Run("Success: re-encrypt batches and resume from checkpoint", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+		memStore := new(mockredis.MockRedis)
+
+		var createdKey string
+		dataStore.On("GetCheckpoint", mock.Anything, "reencrypt:2025").Return(int64(0), nil)
+		dataStore.On("FilterUsers", mock.Anything, afterID(0)).Return(users[:3], nil)
+		dataStore.On("FilterUsers", mock.Anything, afterID(3)).Return(users[3:], nil)
+		dataStore.On("GetUserKeys", mock.Anything, []int64{1, 2, 3}).Return(map[int64]string{2: currentKey, 3: oldKey}, nil)
+		dataStore.On("GetUserKeys", mock.Anything, []int64{4}).Return(map[int64]string{}, nil)
+		dataStore.On("CreateUserKey", mock.Anything, int64(1), mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+			createdKey = args.String(2)
+		}).Return(nil)
+		dataStore.On("CreateUserKey", mock.Anything, int64(4), mock.AnythingOfType("string")).Return(nil)
+		dataStore.On("UpdateUserKey", mock.Anything, int64(3), oldKey, mock.MatchedBy(func(wrapped string) bool {
+			return !fields.NeedsRewrap(wrapped)
+		})).Return(nil)
+		dataStore.On("UpdateUserPII", mock.Anything, users[0], mock.MatchedBy(func(updated *models.UserDetails) bool {
+			userFields, err := userKeys.open(1, createdKey)
+			if err != nil || userFields.NeedsReencryption(updated) {
+				return false
+			}
+
+			opened := *updated
+			err = userFields.Open(&opened, userAssociatedData(1))
+			return err == nil && opened.FirstName == "john" && opened.EmailAddress == "test@test.com"
+		})).Return(nil)
+		dataStore.On("SaveCheckpoint", mock.Anything, "reencrypt:2025", int64(3)).Return(nil)
+		// user 4 fails, the checkpoint stays before it so the next run tries it again.
+		memStore.On("Delete", mock.Anything, "1").Return(nil)
+
+		reencryptor := NewReencryptor(dataStore, dataStore, memStore, fields, log, 3)
+		progress, err := reencryptor.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, &ReencryptionProgress{Scanned: 4, Reencrypted: 2, Skipped: 1, Failed: 1, LastID: 3}, progress)
+
+		dataStore.AssertExpectations(t)
+		dataStore.AssertNotCalled(t, "SaveCheckpoint", mock.Anything, "reencrypt:2025", int64(4))
+		memStore.AssertExpectations(t)
+	})
+
+	t.Run("Fail: checkpoint can't be saved", func(t *testing.T) {
+		dataStore := new(mockdatabase.MockDatabase)
+
+		dataStore.On("GetCheckpoint", mock.Anything, "reencrypt:2025").Return(int64(1), nil)
+		dataStore.On("FilterUsers", mock.Anything, afterID(1)).Return(users[1:2], nil)
+		dataStore.On("GetUserKeys", mock.Anything, []int64{2}).Return(map[int64]string{2: currentKey}, nil)
+		dataStore.On("SaveCheckpoint", mock.Anything, "reencrypt:2025", int64(2)).Return(errors.New("test error"))
+
+		reencryptor := NewReencryptor(dataStore, dataStore, nil, fields, log, 2)
+		progress, err := reencryptor.Run(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, int64(1), progress.Scanned)
+
+		dataStore.AssertExpectations(t)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/viswals_task/core/models"
+	"time"
 )
 
 type queuePublisher interface {
@@ -18,16 +19,98 @@ type queueConsumer interface {
 
 type dataStoreProvider interface {
 	GetUserByID(context.Context, string) (*models.UserDetails, error)
-	CreateUser(context.Context, *models.UserDetails) error
+	GetUsersByIDs(ctx context.Context, ids []int64) ([]*models.UserDetails, error)
+	CreateUser(ctx context.Context, user *models.UserDetails, wrappedKey string) error
+	CreateUsers(ctx context.Context, users []*models.UserDetails, wrappedKeys []string) ([]error, error)
 	//CreateBulkUsers(context.Context, []*models.UserDetails) error
-	GetAllUsers(context.Context) ([]*models.UserDetails, error)
+	FilterUsers(context.Context, *models.UserQuery) ([]*models.UserDetails, error)
+	StreamUsers(ctx context.Context, query *models.UserQuery, batchSize int, fn func([]*models.UserDetails) error) error
+	CountUsers(context.Context, *models.UserQuery) (int64, error)
 	DeleteUser(context.Context, string) error
 	ListUsers(context.Context, int64, int64) ([]*models.UserDetails, error)
+	UpdateUserPII(ctx context.Context, old, updated *models.UserDetails) error
+	EraseUser(ctx context.Context, userID int64) (*models.ErasureRecord, error)
+	userKeyStore
+}
+
+type userKeyStore interface {
+	GetUserKeys(ctx context.Context, userIDs []int64) (map[int64]string, error)
+	CreateUserKey(ctx context.Context, userID int64, wrappedKey string) error
+	UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string) error
+}
+
+type checkpointStore interface {
+	GetCheckpoint(ctx context.Context, name string) (int64, error)
+	SaveCheckpoint(ctx context.Context, name string, lastID int64) error
 }
 
 type memoryStoreProvider interface {
 	Get(context.Context, string) (*models.UserDetails, error)
+	GetMany(ctx context.Context, keys []string) (map[string]*models.UserDetails, error)
 	Set(context.Context, string, *models.UserDetails) error
+	SetMissing(ctx context.Context, key string) error
 	//SetBulk(context.Context, []*models.UserDetails) error
 	Delete(context.Context, string) error
 }
+
+// cacheIndex exposes the keys of the cache, e.g. redis.Redis.
+type cacheIndex interface {
+	TopReads(ctx context.Context, n int64) ([]string, error)
+	TrimReads(ctx context.Context, keep int64) error
+	SampleKeys(ctx context.Context, n int) ([]string, error)
+}
+
+// eventLog is the change feed of the users, e.g. redis.Redis.
+type eventLog interface {
+	AppendEvent(ctx context.Context, eventType models.EventType, userID int64) (string, error)
+	LastEventSeq(ctx context.Context) (string, error)
+	ReadEvents(ctx context.Context, after string, count int64, block time.Duration) ([]*models.UserEvent, error)
+}
+
+// outboxStore reads the outbox written with the changes of the users, e.g. database.Database.
+type outboxStore interface {
+	RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.OutboxEvent) error) (int, error)
+	PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+// eventPublisher publishes to a message broker, e.g. rabbitmq.Exchange.
+type eventPublisher interface {
+	Publish(ctx context.Context, routingKey string, messageID string, data []byte) error
+}
+
+// webhookStore stores the webhooks and their deliveries, e.g. database.Database.
+type webhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *models.Webhook, wrappedSecret string) error
+	GetWebhook(ctx context.Context, id int64) (*models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]*models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	EnqueueDeliveries(ctx context.Context, event *models.OutboxEvent) (int64, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.DeliveryAttempt, error)
+	RecordDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt, result *models.DeliveryResult, disableAfter int) (bool, error)
+	ListDeliveries(ctx context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error)
+}
+
+// idempotencyStore stores the responses of the requests sent with an idempotency key, e.g. database.Database.
+type idempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	PruneIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// importStore tracks the imports of csv files, e.g. database.Database.
+type importStore interface {
+	CreateImport(ctx context.Context, imp *models.Import) error
+	AddImportProgress(ctx context.Context, id string, progress *models.ImportProgress) error
+	FinishImport(ctx context.Context, id string, status models.ImportStatus, reason string) error
+	GetImport(ctx context.Context, id string) (*models.Import, error)
+	ListImports(ctx context.Context, limit int64) ([]*models.Import, error)
+}
+
+// emailIndexStore finds the users without email blind index, e.g. database.Database.
+type emailIndexStore interface {
+	ListUsersWithoutEmailIndex(ctx context.Context, afterID int64, limit int64) ([]*models.UserDetails, error)
+	SetEmailIndex(ctx context.Context, user *models.UserDetails, index string) error
+	userKeyStore
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// userKeyCacheTTL bounds how long an instance which did not erase a user can still read its key,
+	// it is the default TTL of the local cache of the users.
+	userKeyCacheTTL = 30 * time.Second
+	// userKeyCacheEntries bounds the number of wrapped keys cached.
+	userKeyCacheEntries = 10000
+)
+
+// userKeys encrypts the personal data of every user with a key of its own, stored in user_keys wrapped by the
+// master keys. Destroying the key of a user makes its personal data unrecoverable wherever the ciphertexts were
+// copied to, cache entries and database backups included.
+type userKeys struct {
+	store  userKeyStore
+	fields *encryptionutils.FieldCipher
+	// cache is optional, without it every read looks the keys up in the store.
+	cache *keyCache
+}
+
+// generate returns the key of a new user wrapped for storage and the cipher encrypting with it.
+func (k *userKeys) generate(userID int64) (string, *encryptionutils.FieldCipher, error) {
+	raw, wrapped, err := k.fields.GenerateUserKey(userKeyAssociatedData(userID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	fields, err := k.fields.ForUser(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return wrapped, fields, nil
+}
+
+// ciphers returns the cipher of each user by user id. Users created before user keys existed use the master keys,
+// so do erased users whose key is destroyed: their values encrypted with it can't be decrypted anymore.
+func (k *userKeys) ciphers(ctx context.Context, users []*models.UserDetails) (map[int64]*encryptionutils.FieldCipher, error) {
+	userIDs := make([]int64, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+
+	wrappedKeys, err := k.wrappedKeys(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers := make(map[int64]*encryptionutils.FieldCipher, len(users))
+	for _, userID := range userIDs {
+		ciphers[userID] = k.fields
+		wrapped, ok := wrappedKeys[userID]
+		if !ok {
+			continue
+		}
+
+		ciphers[userID], err = k.open(userID, wrapped)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ciphers, nil
+}
+
+// wrappedKeys returns the wrapped keys of the users by user id, only the keys not cached are looked up in the store.
+// Users without key are never cached: their key may be created by cmd/reencrypt at any time.
+func (k *userKeys) wrappedKeys(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	if k.cache == nil {
+		return k.store.GetUserKeys(ctx, userIDs)
+	}
+
+	wrappedKeys, missing := k.cache.getMany(userIDs)
+	if len(missing) == 0 {
+		return wrappedKeys, nil
+	}
+
+	stored, err := k.store.GetUserKeys(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for userID, wrapped := range stored {
+		wrappedKeys[userID] = wrapped
+		k.cache.add(userID, wrapped)
+	}
+	return wrappedKeys, nil
+}
+
+// remember caches the key of a user just stored.
+func (k *userKeys) remember(userID int64, wrapped string) {
+	if k.cache != nil {
+		k.cache.add(userID, wrapped)
+	}
+}
+
+// forget drops the cached key of a deleted or erased user.
+func (k *userKeys) forget(userID int64) {
+	if k.cache != nil {
+		k.cache.forget(userID)
+	}
+}
+
+func (k *userKeys) cipher(ctx context.Context, user *models.UserDetails) (*encryptionutils.FieldCipher, error) {
+	ciphers, err := k.ciphers(ctx, []*models.UserDetails{user})
+	if err != nil {
+		return nil, err
+	}
+	return ciphers[user.ID], nil
+}
+
+// current returns the cipher of the user having the wrapped key (empty if it has none yet) making sure its key is
+// stored wrapped with the active master key, it reports whether the stored key was created or wrapped again.
+func (k *userKeys) current(ctx context.Context, userID int64, wrapped string) (*encryptionutils.FieldCipher, bool, error) {
+	if wrapped == "" {
+		raw, wrapped, err := k.fields.GenerateUserKey(userKeyAssociatedData(userID))
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = k.store.CreateUserKey(ctx, userID, wrapped)
+		if err != nil {
+			return nil, false, err
+		}
+
+		fields, err := k.fields.ForUser(raw)
+		return fields, true, err
+	}
+
+	raw, err := k.fields.UnwrapUserKey(wrapped, userKeyAssociatedData(userID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	fields, err := k.fields.ForUser(raw)
+	if err != nil || !k.fields.NeedsRewrap(wrapped) {
+		return fields, false, err
+	}
+
+	rewrapped, err := k.fields.WrapUserKey(raw, userKeyAssociatedData(userID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = k.store.UpdateUserKey(ctx, userID, wrapped, rewrapped)
+	if err != nil {
+		return nil, false, err
+	}
+	return fields, true, nil
+}
+
+func (k *userKeys) open(userID int64, wrapped string) (*encryptionutils.FieldCipher, error) {
+	raw, err := k.fields.UnwrapUserKey(wrapped, userKeyAssociatedData(userID))
+	if err != nil {
+		return nil, err
+	}
+	return k.fields.ForUser(raw)
+}
+
+// userKeyAssociatedData binds a wrapped key to its user, the key of a user can't be copied to another.
+func userKeyAssociatedData(userID int64) []byte {
+	return []byte("user_key:" + strconv.FormatInt(userID, 10))
+}
+
+type cachedKey struct {
+	userID  int64
+	wrapped string
+	expires time.Time
+}
+
+// keyCache is a small in-process cache of the wrapped keys, the least recently added keys are evicted first.
+type keyCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	order *list.List
+	items map[int64]*list.Element
+	now   func() time.Time
+}
+
+func newKeyCache(maxEntries int, ttl time.Duration) *keyCache {
+	return &keyCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		items:      make(map[int64]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// getMany returns the cached keys by user id and the ids of the users whose key is not cached.
+func (c *keyCache) getMany(userIDs []int64) (map[int64]string, []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	found := make(map[int64]string, len(userIDs))
+	var missing []int64
+	for _, userID := range userIDs {
+		element, ok := c.items[userID]
+		if ok && now.After(element.Value.(*cachedKey).expires) {
+			c.remove(element)
+			ok = false
+		}
+		if !ok {
+			missing = append(missing, userID)
+			continue
+		}
+		found[userID] = element.Value.(*cachedKey).wrapped
+	}
+	return found, missing
+}
+
+func (c *keyCache) add(userID int64, wrapped string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[userID]; ok {
+		c.remove(element)
+	}
+
+	c.items[userID] = c.order.PushFront(&cachedKey{userID: userID, wrapped: wrapped, expires: c.now().Add(c.ttl)})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *keyCache) forget(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[userID]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *keyCache) remove(element *list.Element) {
+	e := c.order.Remove(element).(*cachedKey)
+	delete(c.items, e.userID)
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestKeyCache(t *testing.T) {
+	now := time.Now()
+	cache := newKeyCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.add(1, "key1")
+	cache.add(2, "key2")
+
+	found, missing := cache.getMany([]int64{1, 2, 3})
+	assert.Equal(t, map[int64]string{1: "key1", 2: "key2"}, found)
+	assert.Equal(t, []int64{3}, missing)
+
+	t.Run("Success: the oldest key is evicted", func(t *testing.T) {
+		cache.add(3, "key3")
+		found, missing := cache.getMany([]int64{1, 2, 3})
+		assert.Equal(t, map[int64]string{2: "key2", 3: "key3"}, found)
+		assert.Equal(t, []int64{1}, missing)
+	})
+
+	t.Run("Success: forgotten key", func(t *testing.T) {
+		cache.forget(2)
+		found, missing := cache.getMany([]int64{2, 3})
+		assert.Equal(t, map[int64]string{3: "key3"}, found)
+		assert.Equal(t, []int64{2}, missing)
+	})
+
+	t.Run("Success: expired key", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		found, missing := cache.getMany([]int64{3})
+		assert.Empty(t, found)
+		assert.Equal(t, []int64{3}, missing)
+	})
+}
@@ -2,81 +2,214 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/viswals_task/core/models"
 	"github.com/viswals_task/internal/encryptionutils"
 	"github.com/viswals_task/pkg/database"
+	"github.com/viswals_task/pkg/redis"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type UserService struct {
 	dataStore dataStoreProvider
 	memStore  memoryStoreProvider
-	encryp *encryptionutils.Encryption
-	logger    *zap.Logger
+	// events is the change feed, it is disabled when nil.
+	events eventLog
+	// hub fans the new events out to the subscriptions of the instance, it is nil when the change feed is disabled.
+	hub *eventHub
+	// fields applies the encryption policy of models.UserDetails.
+	fields *encryptionutils.FieldCipher
+	keys   *userKeys
+	// loads collapses the concurrent database lookups of a user.
+	loads  singleflight.Group
+	logger *zap.Logger
 }
 
-func NewUserService(dataStore dataStoreProvider, memStore memoryStoreProvider, encryption *encryptionutils.Encryption,logger *zap.Logger) *UserService {
+func NewUserService(dataStore dataStoreProvider, memStore memoryStoreProvider, events eventLog, fields *encryptionutils.FieldCipher, logger *zap.Logger) *UserService {
+	var hub *eventHub
+	if events != nil {
+		hub = newEventHub(events, logger)
+	}
+
 	return &UserService{
 		dataStore: dataStore,
 		memStore:  memStore,
-		encryp: encryption,
+		events:    events,
+		hub:       hub,
+		fields:    fields,
+		keys:      &userKeys{store: dataStore, fields: fields, cache: newKeyCache(userKeyCacheEntries, userKeyCacheTTL)},
 		logger:    logger,
 	}
 }
 
 func (us *UserService) GetUser(ctx context.Context, userID string) (*models.UserDetails, error) {
 	// first try to fetch data from cache.
-	var user *models.UserDetails
-	var err error
-	user, err = us.memStore.Get(ctx, userID)
+	user, err := us.memStore.Get(ctx, userID)
+	if errors.Is(err, redis.ErrMissing) {
+		return nil, database.ErrNoData
+	}
+	if err != nil {
+		if !errors.Is(err, redis.ErrNotFound) {
+			us.logger.Warn("UserService: error getting user from cache", zap.String("user_id", userID), zap.Error(err))
+		}
+
+		// if not cached fetch data from database
+		user, err = us.loadUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := us.keys.cipher(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = openForRole(fields, user, models.RoleFromContext(ctx))
+	if errors.Is(err, encryptionutils.ErrNoUserKey) {
+		// the user has been erased after being cached, the entry can't be read anymore.
+		err = us.memStore.Delete(ctx, userID)
+		if err != nil {
+			us.logger.Warn("UserService: error deleting erased user from cache", zap.String("user_id", userID), zap.Error(err))
+		}
+		return nil, models.ErrUserErased
+	}
 	if err != nil {
-		us.logger.Warn("UserService: error getting user from cache", zap.String("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+
+	user.SetTimeFormat(models.TimeFormatFromContext(ctx))
+	return user, nil
+}
 
-		// if error fetch data from database
-		user, err = us.dataStore.GetUserByID(ctx, userID)
+// loadUser fetches the user from the database and caches it, concurrent loads of the same user share a single query.
+// Missing users are cached as missing for a short time.
+func (us *UserService) loadUser(ctx context.Context, userID string) (*models.UserDetails, error) {
+	loaded, err, _ := us.loads.Do(userID, func() (interface{}, error) {
+		// the query is shared, it must not be cancelled with the request which started it.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultTimeout)
+		defer cancel()
+
+		user, err := us.dataStore.GetUserByID(ctx, userID)
+		if errors.Is(err, database.ErrNoData) {
+			setErr := us.memStore.SetMissing(ctx, userID)
+			if setErr != nil {
+				us.logger.Warn("UserService: error caching missing user", zap.String("user_id", userID), zap.Error(setErr))
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
 
+		// only the tombstone of an erased user is left, it is never cached.
+		if user.ErasedAt.Valid {
+			return nil, models.ErrUserErased
+		}
+
 		// if data is successfully fetched, update the cache.
 		err = us.memStore.Set(ctx, userID, user)
 		if err != nil {
 			// log the error and we can safely ignore this error.
 			us.logger.Warn("UserService: error setting user in cache", zap.String("user_id", userID), zap.Error(err))
 		}
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every caller decrypts its own copy.
+	user := *loaded.(*models.UserDetails)
+	return &user, nil
+}
+
+func (us *UserService) GetAllUsers(ctx context.Context, query *models.UserQuery) (*models.UserPage, error) {
+	pageQuery, err := us.pageQuery(query)
+	if err != nil {
+		return nil, err
 	}
-	decryptedEmail, err := us.encryp.Decrypt(user.EmailAddress)
+
+	page, err := us.page(ctx, query.Limit, pageQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	user.EmailAddress = decryptedEmail
+	total, err := us.dataStore.CountUsers(ctx, pageQuery)
+	if err != nil {
+		return nil, err
+	}
 
-	return user, nil
+	page.TotalCount = total
+	return page, nil
 }
 
-func (us *UserService) GetAllUsers(ctx context.Context) ([]*models.UserDetails, error) {
-	// fetch and return data from db for now.
-	users, err := us.dataStore.GetAllUsers(ctx)
+// GetAllUsersSSE returns a page of users like GetAllUsers without counting them, the pages are streamed one after the other.
+func (us *UserService) GetAllUsersSSE(ctx context.Context, query *models.UserQuery) (*models.UserPage, error) {
+	pageQuery, err := us.pageQuery(query)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, user := range users {
-		decryptedEmail, err := us.encryp.Decrypt(user.EmailAddress)
+	return us.page(ctx, query.Limit, pageQuery)
+}
+
+// pageQuery returns the query run for a page of the user listing.
+func (us *UserService) pageQuery(query *models.UserQuery) (*models.UserQuery, error) {
+	// fetch one more row than requested to know if there is a next page.
+	pageQuery := *query
+	pageQuery.Limit = query.Limit + 1
+	if pageQuery.SortBy == "" {
+		pageQuery.SortBy = models.SortByID
+	}
+
+	// encrypted columns can't be ordered in a meaningful way.
+	if us.fields.IsEncryptedColumn(&models.UserDetails{}, string(pageQuery.SortBy)) {
+		return nil, models.ErrUnsortableField
+	}
+
+	// emails are only searchable through their blind index.
+	if query.Email != "" {
+		index, err := us.fields.BlindIndex(&models.UserDetails{}, emailField, query.Email)
 		if err != nil {
-			us.logger.Error("error decrypting email", zap.String("email", user.EmailAddress), zap.Error(err))
 			return nil, err
 		}
+		// without index the filter would not be applied.
+		if index == "" {
+			return nil, models.ErrInvalidEmail
+		}
+		pageQuery.Email = ""
+		pageQuery.EmailIndex = index
+	}
+
+	return &pageQuery, nil
+}
+
+func (us *UserService) page(ctx context.Context, limit int64, pageQuery *models.UserQuery) (*models.UserPage, error) {
+	users, err := us.dataStore.FilterUsers(ctx, pageQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.UserPage{Users: users}
 
-		user.EmailAddress = decryptedEmail
+	if int64(len(users)) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = models.CursorFor(page.Users[len(page.Users)-1], pageQuery.SortBy, pageQuery.Descending).Encode()
 	}
-	return users, nil
+
+	err = us.openUsers(ctx, page.Users)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
+// DeleteUser deletes the user, database.ErrNoData is returned when it does not exist, nothing is recorded then.
 func (us *UserService) DeleteUser(ctx context.Context, userID string) error {
 	// delete user from db first
 	err := us.dataStore.DeleteUser(ctx, userID)
@@ -91,65 +224,143 @@ func (us *UserService) DeleteUser(ctx context.Context, userID string) error {
 		// the data will be automatically expired with TTL.
 	}
 
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err == nil {
+		us.keys.forget(id)
+		recordEvent(ctx, us.events, us.logger, models.EventDeleted, id)
+	}
+
 	return nil
 }
 
+// EraseUser crypto-shreds the personal data of the user: its key is destroyed, its cache entry removed and
+// its row kept as a tombstone. The returned audit record proves when the erasure occurred.
+func (us *UserService) EraseUser(ctx context.Context, userID int64) (*models.ErasureRecord, error) {
+	record, err := us.dataStore.EraseUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	us.keys.forget(userID)
+
+	// a cached user not encrypted with its own key could still be read, so the erasure fails if it can't be removed.
+	// erasing again is safe.
+	err = us.memStore.Delete(ctx, strconv.FormatInt(userID, 10))
+	if err != nil {
+		us.logger.Error("UserService: error deleting erased user from cache", zap.Int64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+
+	recordEvent(ctx, us.events, us.logger, models.EventErased, userID)
+
+	us.logger.Info("user erased", zap.Int64("user_id", userID), zap.Int64("audit_id", record.ID), zap.Bool("key_destroyed", record.KeyDestroyed))
+	return record, nil
+}
+
+// CreateUser validates the user and creates it with a new key.
 func (us *UserService) CreateUser(ctx context.Context, user *models.UserDetails) error {
-	// encrypt users email id
-	newEmail, err := us.encryp.Encrypt(user.EmailAddress)
+	err := user.Validate()
 	if err != nil {
 		return err
 	}
 
-	user.EmailAddress = newEmail
+	wrappedKey, err := us.seal(user)
+	if err != nil {
+		return err
+	}
 
 	// first, insert the data in a database.
-	err = us.dataStore.CreateUser(ctx, user)
+	err = us.dataStore.CreateUser(ctx, user, wrappedKey)
 	if err != nil {
 		return err
 	}
 
-	// upon successful insertion update the cache
-	err = us.memStore.Set(ctx, fmt.Sprint(user.ID), user)
+	us.created(ctx, user, wrappedKey)
+	return nil
+}
+
+// seal indexes and encrypts the personal data of the user with a new key of the user, it returns the wrapped key.
+func (us *UserService) seal(user *models.UserDetails) (string, error) {
+	wrappedKey, fields, err := us.keys.generate(user.ID)
 	if err != nil {
-		us.logger.Warn("UserService: error setting user in cache", zap.Error(err), zap.Any("user", user))
+		return "", err
 	}
 
-	return nil
+	err = fields.Seal(user, userAssociatedData(user.ID))
+	if err != nil {
+		return "", err
+	}
+	return wrappedKey, nil
 }
 
-func (us *UserService) GetAllUsersSSE(ctx context.Context, limit, offset int64) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
+// created caches the user inserted in the database and records its creation.
+func (us *UserService) created(ctx context.Context, user *models.UserDetails, wrappedKey string) {
+	us.keys.remember(user.ID, wrappedKey)
+
+	// upon successful insertion update the cache
+	err := us.memStore.Set(ctx, fmt.Sprint(user.ID), user)
+	if err != nil {
+		us.logger.Warn("UserService: error setting user in cache", zap.Error(err), zap.Any("user", user))
+	}
 
-	var isLastData bool
+	recordCreated(ctx, us.events, us.logger, user)
+}
 
-	users, err := us.dataStore.ListUsers(ctx, limit, offset)
+func (us *UserService) openUsers(ctx context.Context, users []*models.UserDetails) error {
+	ciphers, err := us.keys.ciphers(ctx, users)
 	if err != nil {
-		if errors.Is(err, database.ErrNoData) {
-			isLastData = true
-		}
-		return nil, err
+		return err
 	}
 
+	role := models.RoleFromContext(ctx)
+	format := models.TimeFormatFromContext(ctx)
 	for _, user := range users {
-		decryptedEmail, err := us.encryp.Decrypt(user.EmailAddress)
+		err := openForRole(ciphers[user.ID], user, role)
 		if err != nil {
-			us.logger.Error("error decrypting email", zap.String("email", user.EmailAddress), zap.Error(err))
-			return nil, err
+			us.logger.Error("error decrypting user", zap.Int64("user_id", user.ID), zap.Error(err))
+			return err
 		}
+		user.SetTimeFormat(format)
+	}
+	return nil
+}
 
-		user.EmailAddress = decryptedEmail
+// emailField is the field of the email in models.UserDetails, how it is shown depends on the role of the caller.
+const emailField = "EmailAddress"
+
+// openForRole decrypts the user in place, the email is masked or, when the role can't see it, left empty without being decrypted.
+func openForRole(fields *encryptionutils.FieldCipher, user *models.UserDetails, role models.Role) error {
+	visibility := role.EmailVisibility()
+	if visibility == models.VisibilityNone {
+		return fields.OpenExcept(user, userAssociatedData(user.ID), emailField)
 	}
 
-	data, err := json.Marshal(users)
+	err := fields.Open(user, userAssociatedData(user.ID))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if isLastData {
-		return data, database.ErrNoData
+	if visibility == models.VisibilityMasked {
+		user.EmailAddress = maskEmail(user.EmailAddress)
 	}
+	return nil
+}
+
+// maskEmail keeps the first character of the local part and the domain, e.g. j***@example.com.
+func maskEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+
+	first, _ := utf8.DecodeRuneInString(local)
+	return string(first) + "***@" + domain
+}
 
-	return data, nil
+// userAssociatedData binds the encrypted fields to the user, a ciphertext copied to another user fails to decrypt.
+func userAssociatedData(userID int64) []byte {
+	return []byte("user_details:" + strconv.FormatInt(userID, 10))
 }
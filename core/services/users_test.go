@@ -2,19 +2,32 @@ package services
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/viswals_task/core/models"
 	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database"
 	"github.com/viswals_task/pkg/database/mockdatabase"
+	"github.com/viswals_task/pkg/redis"
 	"github.com/viswals_task/pkg/redis/mockredis"
 	"go.uber.org/zap"
+	"sync"
 	"testing"
+	"time"
 )
 
+func encryptEmail(t *testing.T, encryp *encryptionutils.Encryption, email string, userID int64) string {
+	encrypted, err := encryp.Encrypt(email, userAssociatedData(userID))
+	assert.NoError(t, err)
+	return encrypted
+}
+
+// adminContext is the context of a caller allowed to see the personal data in plaintext.
+func adminContext() context.Context {
+	return models.WithRole(context.Background(), models.RoleAdmin)
+}
+
 type GetUserTestCase struct {
 	name       string
 	service    *UserService
@@ -28,15 +41,19 @@ func TestGetUser(t *testing.T) {
 	mockMemStore := new(mockredis.MockRedis)
 	mockMemStoreNoData := new(mockredis.MockRedis)
 	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
 	mockUserStoreError := new(mockdatabase.MockDatabase)
-	encryp,err := encryptionutils.New([]byte("testtesttesttest"))
-	assert.NoError(t,err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 
 	actualEmail := "test@test.com"
-	encryptedEmail, err := encryp.Encrypt(actualEmail)
+	encryptedEmail, err := encryp.Encrypt(actualEmail, userAssociatedData(1))
 	assert.NoError(t, err)
 
-	decrypted, err := encryp.Decrypt(encryptedEmail)
+	decrypted, err := encryp.Decrypt(encryptedEmail, userAssociatedData(1))
 	assert.NoError(t, err)
 	assert.Equal(t, decrypted, actualEmail)
 
@@ -45,9 +62,9 @@ func TestGetUser(t *testing.T) {
 		FirstName:    "test",
 		LastName:     "test",
 		EmailAddress: encryptedEmail,
-		CreatedAt:    sql.NullTime{},
-		DeletedAt:    sql.NullTime{},
-		MergedAt:     sql.NullTime{},
+		CreatedAt:    models.NullTime{},
+		DeletedAt:    models.NullTime{},
+		MergedAt:     models.NullTime{},
 		ParentUserId: 0,
 	}
 
@@ -64,53 +81,38 @@ func TestGetUser(t *testing.T) {
 
 	testCases := []GetUserTestCase{
 		{
-			name: "Success: get data from cache",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStore,
-				encryp: encryp,
-				logger:    log,
-			},
-			input: "1",
+			name:    "Success: get data from cache",
+			service: NewUserService(mockUserStore, mockMemStore, nil, fields, log),
+			input:   "1",
 			output: &models.UserDetails{
 				ID:           1,
 				FirstName:    "test",
 				LastName:     "test",
 				EmailAddress: actualEmail,
-				CreatedAt:    sql.NullTime{},
-				DeletedAt:    sql.NullTime{},
-				MergedAt:     sql.NullTime{},
+				CreatedAt:    models.NullTime{},
+				DeletedAt:    models.NullTime{},
+				MergedAt:     models.NullTime{},
 				ParentUserId: 0,
 			},
 			throwError: false,
 		}, {
-			name: "Success: get data from database",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStoreNoData,
-				encryp: encryp,
-				logger:    log,
-			},
-			input: "1",
+			name:    "Success: get data from database",
+			service: NewUserService(mockUserStore, mockMemStoreNoData, nil, fields, log),
+			input:   "1",
 			output: &models.UserDetails{
 				ID:           1,
 				FirstName:    "test",
 				LastName:     "test",
 				EmailAddress: actualEmail,
-				CreatedAt:    sql.NullTime{},
-				DeletedAt:    sql.NullTime{},
-				MergedAt:     sql.NullTime{},
+				CreatedAt:    models.NullTime{},
+				DeletedAt:    models.NullTime{},
+				MergedAt:     models.NullTime{},
 				ParentUserId: 0,
 			},
 			throwError: false,
 		}, {
-			name: "Fail: get data from database",
-			service: &UserService{
-				dataStore: mockUserStoreError,
-				memStore:  mockMemStoreNoData,
-				encryp: encryp,
-				logger:    log,
-			},
+			name:       "Fail: get data from database",
+			service:    NewUserService(mockUserStoreError, mockMemStoreNoData, nil, fields, log),
 			input:      "1",
 			output:     nil,
 			throwError: true,
@@ -120,7 +122,7 @@ func TestGetUser(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			outputData.EmailAddress = encryptedEmail
-			d, err := testCase.service.GetUser(context.Background(), testCase.input)
+			d, err := testCase.service.GetUser(adminContext(), testCase.input)
 			if testCase.throwError {
 				assert.Error(t, err)
 			} else {
@@ -139,69 +141,154 @@ func TestGetUser(t *testing.T) {
 type GetAllUserTestCase struct {
 	name       string
 	service    *UserService
-	output     []*models.UserDetails
+	query      *models.UserQuery
+	output     *models.UserPage
 	throwError bool
 }
 
+func TestGetUserCacheMiss(t *testing.T) {
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, nil)
+	var nilOutput *models.UserDetails
+
+	t.Run("Success: concurrent lookups share one database query", func(t *testing.T) {
+		mockUserStore := new(mockdatabase.MockDatabase)
+		mockMemStore := new(mockredis.MockRedis)
+		service := NewUserService(mockUserStore, mockMemStore, nil, fields, log)
+
+		mockMemStore.On("Get", mock.Anything, "1").Return(nilOutput, redis.ErrNotFound)
+		mockUserStore.On("GetUserByID", mock.Anything, "1").After(100*time.Millisecond).Return(&models.UserDetails{
+			ID:           1,
+			FirstName:    "test",
+			EmailAddress: encryptEmail(t, encryp, "test@test.com", 1),
+		}, nil).Once()
+		mockUserStore.On("GetUserKeys", mock.Anything, []int64{1}).Return(map[int64]string{}, nil)
+		mockMemStore.On("Set", mock.Anything, "1", mock.AnythingOfType("*models.UserDetails")).Return(nil).Once()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user, err := service.GetUser(adminContext(), "1")
+				assert.NoError(t, err)
+				assert.Equal(t, "test@test.com", user.EmailAddress)
+			}()
+		}
+		wg.Wait()
+
+		mockUserStore.AssertExpectations(t)
+		mockMemStore.AssertExpectations(t)
+	})
+
+	t.Run("Success: missing user is cached as missing", func(t *testing.T) {
+		mockUserStore := new(mockdatabase.MockDatabase)
+		mockMemStore := new(mockredis.MockRedis)
+		service := NewUserService(mockUserStore, mockMemStore, nil, fields, log)
+
+		mockMemStore.On("Get", mock.Anything, "2").Return(nilOutput, redis.ErrNotFound).Once()
+		mockUserStore.On("GetUserByID", mock.Anything, "2").Return(nilOutput, database.ErrNoData).Once()
+		mockMemStore.On("SetMissing", mock.Anything, "2").Return(nil).Once()
+
+		_, err := service.GetUser(adminContext(), "2")
+		assert.ErrorIs(t, err, database.ErrNoData)
+
+		// the next lookup is answered by the cache.
+		mockMemStore.On("Get", mock.Anything, "2").Return(nilOutput, redis.ErrMissing).Once()
+
+		_, err = service.GetUser(adminContext(), "2")
+		assert.ErrorIs(t, err, database.ErrNoData)
+
+		mockUserStore.AssertExpectations(t)
+		mockMemStore.AssertExpectations(t)
+	})
+}
+
 func TestGetAllUser(t *testing.T) {
 	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
 	mockUserStoreError := new(mockdatabase.MockDatabase)
-	encryp,err := encryptionutils.New([]byte("testtesttesttest"))
-	assert.NoError(t,err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 	log, err := zap.NewDevelopment()
 	assert.NoError(t, err)
 	actualEmail := "test@test.com"
-	encryptedEmail, err := encryp.Encrypt(actualEmail)
-	assert.NoError(t, err)
 
-	outputData := []*models.UserDetails{
-		{
-			ID:           1,
+	newOutputData := func() []*models.UserDetails {
+		return []*models.UserDetails{
+			{
+				ID:           1,
+				FirstName:    "test",
+				LastName:     "test",
+				EmailAddress: encryptEmail(t, encryp, actualEmail, 1),
+				CreatedAt:    models.NullTime{},
+				DeletedAt:    models.NullTime{},
+				MergedAt:     models.NullTime{},
+				ParentUserId: 0,
+			}, {
+				ID:           2,
+				FirstName:    "test",
+				LastName:     "test",
+				EmailAddress: encryptEmail(t, encryp, actualEmail, 2),
+				CreatedAt:    models.NullTime{},
+				DeletedAt:    models.NullTime{},
+				MergedAt:     models.NullTime{},
+				ParentUserId: 0,
+			},
+		}
+	}
+
+	expectedUser := func(id int64) *models.UserDetails {
+		return &models.UserDetails{
+			ID:           id,
 			FirstName:    "test",
 			LastName:     "test",
-			EmailAddress: encryptedEmail,
-			CreatedAt:    sql.NullTime{},
-			DeletedAt:    sql.NullTime{},
-			MergedAt:     sql.NullTime{},
+			EmailAddress: actualEmail,
+			CreatedAt:    models.NullTime{},
+			DeletedAt:    models.NullTime{},
+			MergedAt:     models.NullTime{},
 			ParentUserId: 0,
-		},
+		}
 	}
 
 	var nilOutput []*models.UserDetails = nil
 
-	mockUserStore.On("GetAllUsers", mock.Anything).Return(outputData, nil)
-	mockUserStoreError.On("GetAllUsers", mock.Anything).Return(nilOutput, errors.New("test error"))
+	// the service asks for one extra row to detect the next page.
+	mockUserStore.On("FilterUsers", mock.Anything, mock.MatchedBy(func(q *models.UserQuery) bool { return q.Limit == 3 })).Return(newOutputData(), nil)
+	mockUserStore.On("FilterUsers", mock.Anything, mock.MatchedBy(func(q *models.UserQuery) bool { return q.Limit == 2 })).Return(newOutputData(), nil)
+	mockUserStore.On("CountUsers", mock.Anything, mock.AnythingOfType("*models.UserQuery")).Return(int64(2), nil)
+	mockUserStoreError.On("FilterUsers", mock.Anything, mock.AnythingOfType("*models.UserQuery")).Return(nilOutput, errors.New("test error"))
 
 	testcase := []GetAllUserTestCase{
 		{
-			name: "Success: get data from db",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  nil,
-				encryp: encryp,
-				logger:    log,
-			},
-			output: []*models.UserDetails{
-				{
-					ID:           1,
-					FirstName:    "test",
-					LastName:     "test",
-					EmailAddress: actualEmail,
-					CreatedAt:    sql.NullTime{},
-					DeletedAt:    sql.NullTime{},
-					MergedAt:     sql.NullTime{},
-					ParentUserId: 0,
-				},
+			name:    "Success: get last page from db",
+			service: NewUserService(mockUserStore, nil, nil, fields, log),
+			query:   &models.UserQuery{Limit: 2, SortBy: models.SortByID},
+			output: &models.UserPage{
+				Users:      []*models.UserDetails{expectedUser(1), expectedUser(2)},
+				TotalCount: 2,
 			},
 			throwError: false,
 		}, {
-			name: "Fail: get data from database",
-			service: &UserService{
-				dataStore: mockUserStoreError,
-				memStore:  nil,
-				encryp: encryp,
-				logger:    log,
+			name:    "Success: get page with next cursor from db",
+			service: NewUserService(mockUserStore, nil, nil, fields, log),
+			query:   &models.UserQuery{Limit: 1, SortBy: models.SortByID},
+			output: &models.UserPage{
+				Users:      []*models.UserDetails{expectedUser(1)},
+				NextCursor: (&models.Cursor{SortBy: models.SortByID, ID: 1}).Encode(),
+				TotalCount: 2,
 			},
+			throwError: false,
+		}, {
+			name:       "Fail: get data from database",
+			service:    NewUserService(mockUserStoreError, nil, nil, fields, log),
+			query:      &models.UserQuery{Limit: 1, SortBy: models.SortByID},
 			output:     nil,
 			throwError: true,
 		},
@@ -209,7 +296,7 @@ func TestGetAllUser(t *testing.T) {
 
 	for _, testCase := range testcase {
 		t.Run(testCase.name, func(t *testing.T) {
-			output, err := testCase.service.GetAllUsers(context.Background())
+			output, err := testCase.service.GetAllUsers(adminContext(), testCase.query)
 			if testCase.throwError {
 				assert.Error(t, err)
 			} else {
@@ -224,6 +311,33 @@ func TestGetAllUser(t *testing.T) {
 
 }
 
+func TestGetAllUserByEmail(t *testing.T) {
+	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+
+	// lookup should be case insensitive and never send the plaintext email to the database.
+	byIndex := mock.MatchedBy(func(q *models.UserQuery) bool {
+		return q.Email == "" && q.EmailIndex == indexer.EmailIndex("test@test.com")
+	})
+	mockUserStore.On("FilterUsers", mock.Anything, byIndex).Return([]*models.UserDetails{}, nil)
+	mockUserStore.On("CountUsers", mock.Anything, byIndex).Return(int64(0), nil)
+
+	service := NewUserService(mockUserStore, nil, nil, fields, log)
+
+	page, err := service.GetAllUsers(context.Background(), &models.UserQuery{Limit: 1, Email: " Test@Test.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), page.TotalCount)
+
+	mockUserStore.AssertExpectations(t)
+}
+
 type DeleteUserTestCase struct {
 	name       string
 	service    *UserService
@@ -234,10 +348,14 @@ type DeleteUserTestCase struct {
 func TestDeleteUser(t *testing.T) {
 	mockMemStore := new(mockredis.MockRedis)
 	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
 	mockUserStoreError := new(mockdatabase.MockDatabase)
 	mockMemStoreError := new(mockredis.MockRedis)
-	encryp,err := encryptionutils.New([]byte("testtesttesttest"))
-	assert.NoError(t,err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 
 	log, err := zap.NewDevelopment()
 	assert.NoError(t, err)
@@ -249,33 +367,18 @@ func TestDeleteUser(t *testing.T) {
 
 	testCases := []DeleteUserTestCase{
 		{
-			name: "Success: delete data from both cache and db",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStore,
-				encryp: encryp,
-				logger:    log,
-			},
+			name:       "Success: delete data from both cache and db",
+			service:    NewUserService(mockUserStore, mockMemStore, nil, fields, log),
 			input:      "1",
 			throwError: false,
 		}, {
-			name: "Success: delete data from db Only",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStoreError,
-				encryp: encryp,
-				logger:    log,
-			},
+			name:       "Success: delete data from db Only",
+			service:    NewUserService(mockUserStore, mockMemStoreError, nil, fields, log),
 			input:      "1",
 			throwError: false,
 		}, {
-			name: "Fail: delete data from both cache and db",
-			service: &UserService{
-				dataStore: mockUserStoreError,
-				memStore:  mockMemStoreError,
-				encryp: encryp,
-				logger:    log,
-			},
+			name:       "Fail: delete data from both cache and db",
+			service:    NewUserService(mockUserStoreError, mockMemStoreError, nil, fields, log),
 			input:      "1",
 			throwError: true,
 		},
@@ -307,59 +410,56 @@ type CreateUserTestCase struct {
 func TestCreateUser(t *testing.T) {
 	mockMemStore := new(mockredis.MockRedis)
 	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
 	mockUserStoreError := new(mockdatabase.MockDatabase)
 	mockMemStoreError := new(mockredis.MockRedis)
-	encryp,err := encryptionutils.New([]byte("testtesttesttest"))
-	assert.NoError(t,err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
 	log, err := zap.NewDevelopment()
 	assert.NoError(t, err)
 
-	input := &models.UserDetails{
-		ID:           0,
-		FirstName:    "test",
-		LastName:     "test",
-		EmailAddress: "test@test.com",
-		CreatedAt:    sql.NullTime{},
-		DeletedAt:    sql.NullTime{},
-		MergedAt:     sql.NullTime{},
-		ParentUserId: 0,
+	// the user is sealed in place, every case gets its own.
+	input := func() *models.UserDetails {
+		return &models.UserDetails{
+			ID:           1,
+			FirstName:    "test",
+			LastName:     "test",
+			EmailAddress: "test@test.com",
+			CreatedAt:    models.NullTime{},
+			DeletedAt:    models.NullTime{},
+			MergedAt:     models.NullTime{},
+			ParentUserId: 0,
+		}
 	}
 
-	mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(nil)
-	mockUserStoreError.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails")).Return(errors.New("test error"))
+	mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(nil)
+	mockUserStoreError.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Return(errors.New("test error"))
 	mockMemStore.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("*models.UserDetails")).Return(nil)
 	mockMemStoreError.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("*models.UserDetails")).Return(errors.New("test error"))
 
 	testCases := []CreateUserTestCase{
 		{
-			name: "Success: create data from both cache and db",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStore,
-				encryp: encryp,
-				logger:    log,
-			},
-			input:      input,
+			name:       "Success: create data from both cache and db",
+			service:    NewUserService(mockUserStore, mockMemStore, nil, fields, log),
+			input:      input(),
 			throwError: false,
 		}, {
-			name: "Success: create data on DB Only",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  mockMemStoreError,
-				encryp: encryp,
-				logger:    log,
-			},
-			input:      input,
+			name:       "Success: create data on DB Only",
+			service:    NewUserService(mockUserStore, mockMemStoreError, nil, fields, log),
+			input:      input(),
 			throwError: false,
 		}, {
-			name: "Fail: create data from both cache and db",
-			service: &UserService{
-				dataStore: mockUserStoreError,
-				memStore:  mockMemStoreError,
-				encryp: encryp,
-				logger:    log,
-			},
-			input:      input,
+			name:       "Fail: create data from both cache and db",
+			service:    NewUserService(mockUserStoreError, mockMemStoreError, nil, fields, log),
+			input:      input(),
+			throwError: true,
+		}, {
+			name:       "Fail: invalid user is not created",
+			service:    NewUserService(mockUserStoreError, mockMemStoreError, nil, fields, log),
+			input:      &models.UserDetails{ID: 0, EmailAddress: "test@test.com"},
 			throwError: true,
 		},
 	}
@@ -382,103 +482,215 @@ func TestCreateUser(t *testing.T) {
 
 }
 
+func TestEraseUser(t *testing.T) {
+	mockUserStore := new(mockdatabase.MockDatabase)
+	mockMemStore := new(mockredis.MockRedis)
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	service := NewUserService(mockUserStore, mockMemStore, nil, encryptionutils.NewFieldCipher(encryp, indexer), log)
+
+	var wrappedKey string
+	var cached *models.UserDetails
+	mockUserStore.On("CreateUser", mock.Anything, mock.AnythingOfType("*models.UserDetails"), mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		wrappedKey = args.String(2)
+	}).Return(nil)
+	mockMemStore.On("Set", mock.Anything, "1", mock.AnythingOfType("*models.UserDetails")).Run(func(args mock.Arguments) {
+		user := *args.Get(2).(*models.UserDetails)
+		cached = &user
+	}).Return(nil)
+
+	err = service.CreateUser(context.Background(), &models.UserDetails{ID: 1, FirstName: "John", EmailAddress: "john@doe.com"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, wrappedKey)
+
+	// the personal data can't be decrypted with the master key alone.
+	_, err = encryp.Decrypt(cached.EmailAddress, userAssociatedData(1))
+	assert.ErrorIs(t, err, encryptionutils.ErrNoUserKey)
+
+	// the cache returns a new copy on every read.
+	fromCache := func() *models.UserDetails {
+		user := *cached
+		return &user
+	}
+	keys := mockUserStore.On("GetUserKeys", mock.Anything, []int64{1}).Return(map[int64]string{1: wrappedKey}, nil)
+
+	t.Run("Success: read with the user key", func(t *testing.T) {
+		mockMemStore.On("Get", mock.Anything, "1").Return(fromCache(), nil).Once()
+		user, err := service.GetUser(adminContext(), "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "John", user.FirstName)
+		assert.Equal(t, "john@doe.com", user.EmailAddress)
+
+		// the key stored with the user is cached, reads don't look it up.
+		mockUserStore.AssertNotCalled(t, "GetUserKeys", mock.Anything, []int64{1})
+	})
+
+	record := &models.ErasureRecord{ID: 7, UserID: 1, ErasedAt: time.Now(), KeyDestroyed: true}
+	mockUserStore.On("EraseUser", mock.Anything, int64(1)).Return(record, nil)
+	mockMemStore.On("Delete", mock.Anything, "1").Return(nil)
+
+	t.Run("Success: erase user", func(t *testing.T) {
+		erased, err := service.EraseUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, record, erased)
+	})
+
+	t.Run("Fail: a copy left after the key is destroyed can't be read", func(t *testing.T) {
+		keys.Unset()
+		mockUserStore.On("GetUserKeys", mock.Anything, []int64{1}).Return(map[int64]string{}, nil)
+		mockMemStore.On("Get", mock.Anything, "1").Return(fromCache(), nil).Once()
+
+		_, err := service.GetUser(adminContext(), "1")
+		assert.ErrorIs(t, err, models.ErrUserErased)
+	})
+
+	t.Run("Fail: tombstone is not returned", func(t *testing.T) {
+		memStore := new(mockredis.MockRedis)
+		var nilOutput *models.UserDetails
+		memStore.On("Get", mock.Anything, "1").Return(nilOutput, errors.New("test error"))
+		mockUserStore.On("GetUserByID", mock.Anything, "1").Return(&models.UserDetails{ID: 1, ErasedAt: models.NullTime{Time: time.Now(), Valid: true}}, nil)
+
+		_, err := NewUserService(mockUserStore, memStore, nil, nil, log).GetUser(context.Background(), "1")
+		assert.ErrorIs(t, err, models.ErrUserErased)
+		memStore.AssertExpectations(t)
+	})
+
+	mockUserStore.AssertExpectations(t)
+	mockMemStore.AssertExpectations(t)
+}
+
+func TestGetUserRoles(t *testing.T) {
+	mockUserStore := new(mockdatabase.MockDatabase)
+	mockMemStore := new(mockredis.MockRedis)
+	log, err := zap.NewDevelopment()
+	assert.NoError(t, err)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	service := NewUserService(mockUserStore, mockMemStore, nil, encryptionutils.NewFieldCipher(encryp, nil), log)
+
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil)
+
+	testCases := []struct {
+		name   string
+		role   models.Role
+		output string
+	}{
+		{name: "admin sees plaintext", role: models.RoleAdmin, output: "john@doe.com"},
+		{name: "support sees masked email", role: models.RoleSupport, output: "j***@doe.com"},
+		{name: "unknown role doesn't see email", role: "guest", output: ""},
+		{name: "no role doesn't see email", output: ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			if testCase.role != "" {
+				ctx = models.WithRole(ctx, testCase.role)
+			}
+
+			users := []*models.UserDetails{
+				{ID: 1, FirstName: "John", EmailAddress: encryptEmail(t, encryp, "john@doe.com", 1)},
+				// the email is not decrypted when the role can't see it.
+				{ID: 2, FirstName: "Jane", EmailAddress: "not a ciphertext"},
+			}
+			err := service.openUsers(ctx, users)
+			if testCase.role.EmailVisibility() == models.VisibilityNone {
+				assert.NoError(t, err)
+				assert.Equal(t, "", users[1].EmailAddress)
+			} else {
+				assert.Error(t, err)
+			}
+
+			mockMemStore.On("Get", mock.Anything, "1").Return(&models.UserDetails{ID: 1, FirstName: "John", EmailAddress: encryptEmail(t, encryp, "john@doe.com", 1)}, nil).Once()
+			user, err := service.GetUser(ctx, "1")
+			assert.NoError(t, err)
+			assert.Equal(t, "John", user.FirstName)
+			assert.Equal(t, testCase.output, user.EmailAddress)
+		})
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	for input, output := range map[string]string{
+		"john@example.com": "j***@example.com",
+		"é@example.com":    "é***@example.com",
+		"@example.com":     "***",
+		"not an email":     "***",
+		"":                 "",
+	} {
+		assert.Equal(t, output, maskEmail(input), input)
+	}
+}
+
 type GetAllUserSSETestCase struct {
 	name       string
 	service    *UserService
-	limit      int64
-	offset     int64
-	output     []byte
+	query      *models.UserQuery
+	output     *models.UserPage
 	throwError bool
 }
 
 func TestGetAllUserSSE(t *testing.T) {
 	mockUserStore := new(mockdatabase.MockDatabase)
+	mockUserStore.On("GetUserKeys", mock.Anything, mock.Anything).Return(map[int64]string{}, nil).Maybe()
 	mockUserStoreError := new(mockdatabase.MockDatabase)
 	log, err := zap.NewDevelopment()
 	assert.NoError(t, err)
-	encryp,err := encryptionutils.New([]byte("testtesttesttest"))
-	assert.NoError(t,err)
-	actualEmail := "test@test.com"
-	encryptedEmail, err := encryp.Encrypt(actualEmail)
+	encryp, err := encryptionutils.New([]byte("testtesttesttest"))
 	assert.NoError(t, err)
+	indexer, err := encryptionutils.NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(encryp, indexer)
+	actualEmail := "test@test.com"
 
-	limit := int64(1)
-	offset := int64(1)
-
+	after := &models.Cursor{SortBy: models.SortByID, ID: 1}
 	outputData := []*models.UserDetails{
 		{
-			ID:           1,
-			FirstName:    "test",
-			LastName:     "test",
-			EmailAddress: encryptedEmail,
-			CreatedAt:    sql.NullTime{},
-			DeletedAt:    sql.NullTime{},
-			MergedAt:     sql.NullTime{},
-			ParentUserId: 0,
-		}, {
 			ID:           2,
 			FirstName:    "test",
 			LastName:     "test",
-			EmailAddress: encryptedEmail,
-			CreatedAt:    sql.NullTime{},
-			DeletedAt:    sql.NullTime{},
-			MergedAt:     sql.NullTime{},
-			ParentUserId: 0,
+			EmailAddress: encryptEmail(t, encryp, actualEmail, 2),
 		}, {
 			ID:           3,
 			FirstName:    "test",
 			LastName:     "test",
-			EmailAddress: encryptedEmail,
-			CreatedAt:    sql.NullTime{},
-			DeletedAt:    sql.NullTime{},
-			MergedAt:     sql.NullTime{},
-			ParentUserId: 0,
+			EmailAddress: encryptEmail(t, encryp, actualEmail, 3),
 		},
 	}
 
 	var nilOutput []*models.UserDetails = nil
 
-	mockUserStore.On("ListUsers", mock.Anything, mock.AnythingOfType("int64"), mock.AnythingOfType("int64")).Return(outputData[offset:offset+limit], nil)
-	mockUserStoreError.On("ListUsers", mock.Anything, mock.AnythingOfType("int64"), mock.AnythingOfType("int64")).Return(nilOutput, errors.New("test error"))
+	// one more user than the limit is fetched to know if there is a next page, users are never counted.
+	mockUserStore.On("FilterUsers", mock.Anything, &models.UserQuery{Limit: 2, SortBy: models.SortByID, After: after}).Return(outputData, nil)
+	mockUserStoreError.On("FilterUsers", mock.Anything, mock.Anything).Return(nilOutput, errors.New("test error"))
 
-	expectedOutput := []*models.UserDetails{
-		{
-			ID:           2,
-			FirstName:    "test",
-			LastName:     "test",
-			EmailAddress: actualEmail,
-			CreatedAt:    sql.NullTime{},
-			DeletedAt:    sql.NullTime{},
-			MergedAt:     sql.NullTime{},
-			ParentUserId: 0,
+	expectedOutput := &models.UserPage{
+		Users: []*models.UserDetails{
+			{
+				ID:           2,
+				FirstName:    "test",
+				LastName:     "test",
+				EmailAddress: actualEmail,
+			},
 		},
+		NextCursor: (&models.Cursor{SortBy: models.SortByID, ID: 2}).Encode(),
 	}
-	expectedData, err := json.Marshal(expectedOutput)
-	assert.NoError(t, err)
 
 	testcase := []GetAllUserSSETestCase{
 		{
-			name: "Success: get data from db",
-			service: &UserService{
-				dataStore: mockUserStore,
-				memStore:  nil,
-				encryp: encryp,
-				logger:    log,
-			},
-			limit:      limit,
-			offset:     offset,
-			output:     expectedData,
+			name:       "Success: get data from db",
+			service:    NewUserService(mockUserStore, nil, nil, fields, log),
+			query:      &models.UserQuery{Limit: 1, SortBy: models.SortByID, After: after},
+			output:     expectedOutput,
 			throwError: false,
 		}, {
-			name: "Fail: get data from database",
-			service: &UserService{
-				dataStore: mockUserStoreError,
-				memStore:  nil,
-				encryp: encryp,
-				logger:    log,
-			},
-			limit:      limit,
-			offset:     offset,
+			name:       "Fail: get data from database",
+			service:    NewUserService(mockUserStoreError, nil, nil, fields, log),
+			query:      &models.UserQuery{Limit: 1},
 			output:     nil,
 			throwError: true,
 		},
@@ -486,7 +698,7 @@ func TestGetAllUserSSE(t *testing.T) {
 
 	for _, testCase := range testcase {
 		t.Run(testCase.name, func(t *testing.T) {
-			output, err := testCase.service.GetAllUsersSSE(context.Background(), testCase.limit, testCase.offset)
+			output, err := testCase.service.GetAllUsersSSE(adminContext(), testCase.query)
 			if testCase.throwError {
 				assert.Error(t, err)
 			} else {
@@ -498,5 +710,4 @@ func TestGetAllUserSSE(t *testing.T) {
 
 	mockUserStoreError.AssertExpectations(t)
 	mockUserStore.AssertExpectations(t)
-
 }
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateDestination = errors.New("webhook destination is a private, loopback or link-local address")
+
+var (
+	defaultWebhookMaxAttempts  = 10
+	defaultWebhookDisableAfter = 50
+	defaultWebhookBaseBackoff  = 10 * time.Second
+	defaultWebhookMaxBackoff   = time.Hour
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookBatchSize    = 20
+
+	// legacyWebhookSecretData is the associated data of the secrets of the webhooks created when the secrets were
+	// wrapped as user keys.
+	legacyWebhookSecretData = []byte("webhook-secret")
+)
+
+// webhookSecretName is the kind of the wrapped webhook secrets, see encryptionutils.FieldCipher.WrapSecret.
+const webhookSecretName = "webhook"
+
+// webhookSecretBytes is the size of the generated secrets.
+const webhookSecretBytes = 32
+
+// signature headers of the deliveries, the signature is the HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventHeader     = "X-Webhook-Event"
+)
+
+// WebhookConfig configures the deliveries, zero values use the defaults.
+type WebhookConfig struct {
+	// MaxAttempts is the number of attempts of a delivery before it fails.
+	MaxAttempts int
+	// DisableAfter is the number of failed attempts in a row after which a webhook is disabled.
+	DisableAfter int
+	// BaseBackoff is the delay before the second attempt, it doubles with every attempt up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Timeout of an attempt, a delivery is claimed for twice the timeout.
+	Timeout time.Duration
+	// BatchSize is the number of deliveries attempted concurrently.
+	BatchSize int
+}
+
+func (c WebhookConfig) withDefaults() WebhookConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if c.DisableAfter <= 0 {
+		c.DisableAfter = defaultWebhookDisableAfter
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = defaultWebhookBaseBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultWebhookTimeout
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultWebhookBatchSize
+	}
+	return c
+}
+
+// WebhookService manages the webhooks of the partners and delivers them the user events of the outbox.
+type WebhookService struct {
+	store webhookStore
+	// fields wraps the secrets of the webhooks.
+	fields *encryptionutils.FieldCipher
+	client *http.Client
+	config WebhookConfig
+	logger *zap.Logger
+}
+
+func NewWebhookService(store webhookStore, fields *encryptionutils.FieldCipher, config WebhookConfig, logger *zap.Logger) *WebhookService {
+	config = config.withDefaults()
+	return &WebhookService{
+		store:  store,
+		fields: fields,
+		client: newWebhookClient(config.Timeout),
+		config: config,
+		logger: logger,
+	}
+}
+
+// newWebhookClient returns the client of the deliveries, it only connects to public addresses, checked on the address
+// dialed so a host can't resolve to an internal one after the webhook was registered, and it doesn't follow redirects.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect to the destination in place of the dialer.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicOnly is the net.Dialer Control refusing the connections to the addresses not allowed by models.PublicAddr.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !models.PublicAddr(addr) {
+		return ErrPrivateDestination
+	}
+	return nil
+}
+
+// CreateWebhook stores the webhook, a secret is generated when none is given. The returned webhook is the only one
+// containing the secret.
+func (ws *WebhookService) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	err := webhook.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if webhook.Secret == "" {
+		secret := make([]byte, webhookSecretBytes)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	wrappedSecret, err := ws.fields.WrapSecret([]byte(webhook.Secret), webhookSecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ws.store.CreateWebhook(ctx, webhook, wrappedSecret)
+	if err != nil {
+		ws.logger.Error("WebhookService: error creating webhook", zap.Error(err))
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (ws *WebhookService) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	return ws.store.GetWebhook(ctx, id)
+}
+
+func (ws *WebhookService) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return ws.store.ListWebhooks(ctx)
+}
+
+// UpdateWebhook replaces the url, event types and state of the webhook, the secret can't be changed.
+func (ws *WebhookService) UpdateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	err := webhook.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = ""
+	err = ws.store.UpdateWebhook(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, id int64) error {
+	return ws.store.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries returns the delivery log of the webhook, most recent first.
+func (ws *WebhookService) ListDeliveries(ctx context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error) {
+	_, err := ws.store.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.store.ListDeliveries(ctx, webhookID, limit)
+}
+
+// Enqueue creates the deliveries of the outbox events received until the channel is closed. A message is
+// acknowledged once its deliveries are stored, it is received again otherwise.
+func (ws *WebhookService) Enqueue(ctx context.Context, messages <-chan amqp.Delivery) {
+	for message := range messages {
+		var event models.OutboxEvent
+		err := json.Unmarshal(message.Body, &event)
+		if err != nil {
+			ws.logger.Error("WebhookService: dropping invalid event", zap.Error(err), zap.ByteString("body", message.Body))
+			_ = message.Nack(false, false)
+			continue
+		}
+
+		_, err = ws.store.EnqueueDeliveries(ctx, &event)
+		if err != nil {
+			ws.logger.Error("WebhookService: error enqueuing deliveries", zap.Error(err), zap.Int64("event_id", event.ID))
+			_ = message.Nack(false, true)
+			// give the database time to recover before the event is received again.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ws.config.BaseBackoff):
+			}
+			continue
+		}
+
+		_ = message.Ack(false)
+	}
+}
+
+// Run attempts the due deliveries until ctx is done, it waits for the interval when none is due or after a failure.
+func (ws *WebhookService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		attempted, err := ws.Deliver(ctx)
+		if err != nil {
+			ws.logger.Error("WebhookService: error delivering events", zap.Error(err))
+		}
+
+		// a full batch means more deliveries are due.
+		if err == nil && attempted == ws.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver attempts a batch of due deliveries concurrently and returns the number of attempts.
+func (ws *WebhookService) Deliver(ctx context.Context) (int, error) {
+	attempts, err := ws.store.ClaimDeliveries(ctx, ws.config.BatchSize, 2*ws.config.Timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, attempt := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ws.attempt(ctx, attempt)
+		}()
+	}
+	wg.Wait()
+
+	return len(attempts), nil
+}
+
+func (ws *WebhookService) attempt(ctx context.Context, attempt *models.DeliveryAttempt) {
+	result := ws.send(ctx, attempt)
+	if !result.Delivered && attempt.Attempts+1 < ws.config.MaxAttempts {
+		result.RetryAt = models.NewNullTime(time.Now().Add(ws.backoff(attempt.Attempts)))
+	}
+
+	disabled, err := ws.store.RecordDeliveryAttempt(ctx, attempt, result, ws.config.DisableAfter)
+	if err != nil {
+		// the delivery is attempted again once its claim expires.
+		ws.logger.Error("WebhookService: error recording delivery attempt", zap.Error(err), zap.Int64("delivery_id", attempt.ID))
+		return
+	}
+
+	if disabled {
+		ws.logger.Warn("WebhookService: webhook disabled after failing repeatedly", zap.Int64("webhook_id", attempt.WebhookID), zap.String("url", attempt.URL))
+	}
+}
+
+// backoff returns the delay before the next attempt of a delivery attempted attempts times before.
+func (ws *WebhookService) backoff(attempts int) time.Duration {
+	delay := ws.config.BaseBackoff
+	for i := 0; i < attempts && delay < ws.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, ws.config.MaxBackoff)
+}
+
+// send posts the event to the webhook, any 2xx response is a success, redirects are failures.
+func (ws *WebhookService) send(ctx context.Context, attempt *models.DeliveryAttempt) *models.DeliveryResult {
+	secret, err := ws.fields.UnwrapSecret(attempt.WrappedSecret, webhookSecretName)
+	if err != nil {
+		// the secrets of the webhooks created before WrapSecret existed are wrapped as user keys.
+		secret, err = ws.fields.UnwrapUserKey(attempt.WrappedSecret, legacyWebhookSecretData)
+	}
+	if err != nil {
+		ws.logger.Error("WebhookService: error unwrapping webhook secret", zap.Error(err), zap.Int64("webhook_id", attempt.WebhookID))
+		return &models.DeliveryResult{Error: "secret can't be read"}
+	}
+
+	body, err := json.Marshal(attempt.Event)
+	if err != nil {
+		return &models.DeliveryResult{Error: err.Error()}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, attempt.URL, bytes.NewReader(body))
+	if err != nil {
+		return &models.DeliveryResult{Error: err.Error()}
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(attempt.Event.Type))
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(attempt.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+
+	res, err := ws.client.Do(req)
+	if err != nil {
+		return &models.DeliveryResult{Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return &models.DeliveryResult{Delivered: true, StatusCode: res.StatusCode}
+	}
+
+	// the body of the response is not kept, it is controlled by the partner.
+	return &models.DeliveryResult{StatusCode: res.StatusCode, Error: fmt.Sprintf("unexpected status %d", res.StatusCode)}
+}
+
+// SignWebhook returns the signature of a delivery, e.g. sha256=5257a869..., partners compare it with the
+// X-Webhook-Signature header.
+func SignWebhook(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/internal/encryptionutils"
+	"github.com/viswals_task/pkg/database/mockdatabase"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acknowledger records the acknowledgements of the deliveries.
+type acknowledger struct {
+	acked, nacked, requeued int
+}
+
+func (a *acknowledger) Ack(uint64, bool) error {
+	a.acked++
+	return nil
+}
+
+func (a *acknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.nacked++
+	if requeue {
+		a.requeued++
+	}
+	return nil
+}
+
+func (a *acknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+func TestSignWebhook(t *testing.T) {
+	assert.Equal(t, "sha256=e0b35a1f6e068fc9409ba30758755392c7974d61dccfc2d1a8a6cd31d153f08a", SignWebhook([]byte("secret"), 1709296200, []byte(`{"id":7}`)))
+}
+
+func TestCreateWebhook(t *testing.T) {
+	ring, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(ring, nil)
+
+	t.Run("Success: generate and wrap secret", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		var wrappedSecret string
+		store.On("CreateWebhook", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+			wrappedSecret = args.String(2)
+		})
+
+		webhook, err := NewWebhookService(store, fields, WebhookConfig{}, zap.NewNop()).CreateWebhook(context.Background(), &models.Webhook{URL: "https://partner.example.com/users"})
+		assert.NoError(t, err)
+		assert.Len(t, webhook.Secret, 2*webhookSecretBytes)
+
+		secret, err := fields.UnwrapSecret(wrappedSecret, webhookSecretName)
+		assert.NoError(t, err)
+		assert.Equal(t, webhook.Secret, string(secret))
+	})
+
+	t.Run("Fail: invalid webhook", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		service := NewWebhookService(store, fields, WebhookConfig{}, zap.NewNop())
+
+		_, err := service.CreateWebhook(context.Background(), &models.Webhook{URL: "ftp://partner.example.com"})
+		assert.ErrorIs(t, err, models.ErrInvalidWebhookURL)
+		_, err = service.CreateWebhook(context.Background(), &models.Webhook{URL: "https://partner.example.com", EventTypes: []models.EventType{"renamed"}})
+		assert.ErrorIs(t, err, models.ErrInvalidEventType)
+		_, err = service.CreateWebhook(context.Background(), &models.Webhook{URL: "http://10.0.0.1/users"})
+		assert.ErrorIs(t, err, models.ErrPrivateWebhookURL)
+		store.AssertNotCalled(t, "CreateWebhook", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	ring, err := encryptionutils.New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	fields := encryptionutils.NewFieldCipher(ring, nil)
+	wrappedSecret, err := fields.WrapSecret([]byte("secret"), webhookSecretName)
+	assert.NoError(t, err)
+
+	event := models.OutboxEvent{ID: 7, Type: models.EventCreated, UserID: 1, At: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)}
+	config := WebhookConfig{MaxAttempts: 3, DisableAfter: 5, BaseBackoff: time.Minute}
+
+	newAttempt := func(url string, attempts int) *models.DeliveryAttempt {
+		return &models.DeliveryAttempt{
+			WebhookDelivery: models.WebhookDelivery{ID: 3, WebhookID: 2, Event: event, Attempts: attempts},
+			URL:             url,
+			WrappedSecret:   wrappedSecret,
+		}
+	}
+
+	t.Run("Success: deliver signed event", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+			assert.NoError(t, err)
+			assert.Equal(t, SignWebhook([]byte("secret"), timestamp, body), req.Header.Get(WebhookSignatureHeader))
+			assert.Equal(t, "created", req.Header.Get(WebhookEventHeader))
+			assert.Equal(t, "3", req.Header.Get(WebhookDeliveryHeader))
+			assert.JSONEq(t, `{"id":7,"type":"created","user_id":1,"at":"2024-03-01T12:30:00Z"}`, string(body))
+			res.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, defaultWebhookBatchSize, 2*defaultWebhookTimeout).Return([]*models.DeliveryAttempt{newAttempt(server.URL, 0)}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, &models.DeliveryResult{Delivered: true, StatusCode: http.StatusNoContent}, 5).Return(false, nil).Once()
+
+		attempted, err := newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Success: secret wrapped as user key by previous versions", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+			assert.NoError(t, err)
+			assert.Equal(t, SignWebhook([]byte("secret"), timestamp, body), req.Header.Get(WebhookSignatureHeader))
+			res.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		legacySecret, err := fields.WrapUserKey([]byte("secret"), legacyWebhookSecretData)
+		assert.NoError(t, err)
+		attempt := newAttempt(server.URL, 0)
+		attempt.WrappedSecret = legacySecret
+
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt{attempt}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, &models.DeliveryResult{Delivered: true, StatusCode: http.StatusNoContent}, 5).Return(false, nil).Once()
+
+		_, err = newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.NoError(t, err)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: retry failed delivery with backoff", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt{newAttempt(server.URL, 1)}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, mock.MatchedBy(func(result *models.DeliveryResult) bool {
+			// the second attempt waits twice the base backoff.
+			wait := time.Until(result.RetryAt.Time)
+			return !result.Delivered && result.StatusCode == http.StatusServiceUnavailable &&
+				result.Error == "unexpected status 503" && wait > time.Minute && wait <= 2*time.Minute
+		}), 5).Return(false, nil).Once()
+
+		_, err := newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.NoError(t, err)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: last attempt fails delivery and disables webhook", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		// nothing listens on the port of a closed server.
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt{newAttempt(server.URL, 2)}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, mock.MatchedBy(func(result *models.DeliveryResult) bool {
+			return !result.Delivered && result.StatusCode == 0 && result.Error != "" && !result.RetryAt.Valid
+		}), 5).Return(true, nil).Once()
+
+		_, err := newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.NoError(t, err)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: private destination is not dialed", func(t *testing.T) {
+		called := false
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			called = true
+		}))
+		defer server.Close()
+
+		// the server of the test listens on a loopback address.
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt{newAttempt(server.URL, 0)}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, mock.MatchedBy(func(result *models.DeliveryResult) bool {
+			return !result.Delivered && result.StatusCode == 0 && strings.Contains(result.Error, ErrPrivateDestination.Error()) && result.RetryAt.Valid
+		}), 5).Return(false, nil).Once()
+
+		_, err := NewWebhookService(store, fields, config, zap.NewNop()).Deliver(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, called)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: redirect is not followed", func(t *testing.T) {
+		server := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data", http.StatusFound))
+		defer server.Close()
+
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt{newAttempt(server.URL, 0)}, nil).Once()
+		store.On("RecordDeliveryAttempt", mock.Anything, mock.Anything, mock.MatchedBy(func(result *models.DeliveryResult) bool {
+			return !result.Delivered && result.StatusCode == http.StatusFound && result.Error == "unexpected status 302"
+		}), 5).Return(false, nil).Once()
+
+		_, err := newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.NoError(t, err)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Fail: deliveries can't be claimed", func(t *testing.T) {
+		store := new(mockdatabase.MockDatabase)
+		store.On("ClaimDeliveries", mock.Anything, mock.Anything, mock.Anything).Return([]*models.DeliveryAttempt(nil), errors.New("test error")).Once()
+
+		_, err := newLocalWebhookService(store, fields, config).Deliver(context.Background())
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	service := NewWebhookService(nil, nil, WebhookConfig{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}, zap.NewNop())
+	for attempts, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, want, service.backoff(attempts), "attempts %d", attempts)
+	}
+}
+
+func TestEnqueueDeliveries(t *testing.T) {
+	event := &models.OutboxEvent{ID: 7, Type: models.EventDeleted, UserID: 1, At: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)}
+	body, err := json.Marshal(event)
+	assert.NoError(t, err)
+
+	ack := new(acknowledger)
+	messages := make(chan amqp.Delivery, 3)
+	messages <- amqp.Delivery{Acknowledger: ack, Body: body}
+	messages <- amqp.Delivery{Acknowledger: ack, Body: []byte("{")}
+	messages <- amqp.Delivery{Acknowledger: ack, Body: body}
+	close(messages)
+
+	store := new(mockdatabase.MockDatabase)
+	store.On("EnqueueDeliveries", mock.Anything, event).Return(int64(0), errors.New("test error")).Once()
+	store.On("EnqueueDeliveries", mock.Anything, event).Return(int64(2), nil).Once()
+
+	NewWebhookService(store, nil, WebhookConfig{BaseBackoff: time.Millisecond}, zap.NewNop()).Enqueue(context.Background(), messages)
+	assert.Equal(t, 1, ack.acked)
+	// the event failing to be stored is received again, the invalid one is dropped.
+	assert.Equal(t, 2, ack.nacked)
+	assert.Equal(t, 1, ack.requeued)
+	store.AssertExpectations(t)
+}
+
+// newLocalWebhookService delivers to the servers of the tests, which listen on loopback addresses.
+func newLocalWebhookService(store webhookStore, fields *encryptionutils.FieldCipher, config WebhookConfig) *WebhookService {
+	service := NewWebhookService(store, fields, config, zap.NewNop())
+	service.client = &http.Client{Timeout: service.config.Timeout, CheckRedirect: service.client.CheckRedirect}
+	return service
+}
@@ -0,0 +1,89 @@
+package authutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid role token")
+	ErrExpiredToken = errors.New("role token has expired")
+)
+
+// RoleTokens signs and verifies the role tokens issued by the gateway authenticating the callers.
+// A token is <payload>.<signature>, both base64url encoded without padding: the payload is the JSON
+// {"role":"support","exp":1735689600} and the signature its HMAC-SHA256 keyed by the shared secret.
+type RoleTokens struct {
+	secret []byte
+	now    func() time.Time
+}
+
+type roleClaims struct {
+	Role models.Role `json:"role"`
+	// Exp is the expiry of the token in unix seconds.
+	Exp int64 `json:"exp"`
+}
+
+func NewRoleTokens(secret []byte) (*RoleTokens, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("role token secret should be at least 32 bytes")
+	}
+	return &RoleTokens{secret: secret, now: time.Now}, nil
+}
+
+// Sign returns a token granting the role until expires.
+func (rt *RoleTokens) Sign(role models.Role, expires time.Time) (string, error) {
+	payload, err := json.Marshal(roleClaims{Role: role, Exp: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(rt.sign(encoded)), nil
+}
+
+// Verify returns the role granted by the token, the token must be signed with the secret, not expired and grant a known role.
+func (rt *RoleTokens) Verify(token string) (models.Role, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, rt.sign(encoded)) {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	var claims roleClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !rt.now().Before(time.Unix(claims.Exp, 0)) {
+		return "", ErrExpiredToken
+	}
+
+	role, err := models.ParseRole(string(claims.Role))
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	return role, nil
+}
+
+func (rt *RoleTokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, rt.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,66 @@
+package authutils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoleTokens(t *testing.T) {
+	tokens, err := NewRoleTokens([]byte("secretsecretsecretsecretsecret32"))
+	assert.NoError(t, err)
+
+	t.Run("Success: verify signed token", func(t *testing.T) {
+		token, err := tokens.Sign(models.RoleSupport, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		role, err := tokens.Verify(token)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleSupport, role)
+	})
+
+	t.Run("Fail: expired token", func(t *testing.T) {
+		token, err := tokens.Sign(models.RoleAdmin, time.Now().Add(-time.Second))
+		assert.NoError(t, err)
+
+		_, err = tokens.Verify(token)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+
+	t.Run("Fail: token signed with another secret", func(t *testing.T) {
+		other, err := NewRoleTokens([]byte("othersecretothersecretothersecre"))
+		assert.NoError(t, err)
+		token, err := other.Sign(models.RoleAdmin, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		_, err = tokens.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Fail: tampered role", func(t *testing.T) {
+		token, err := tokens.Sign(models.RoleSupport, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		admin, err := tokens.Sign(models.RoleAdmin, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		payload, _, _ := strings.Cut(admin, ".")
+		_, signature, _ := strings.Cut(token, ".")
+		_, err = tokens.Verify(payload + "." + signature)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Fail: unknown role", func(t *testing.T) {
+		token, err := tokens.Sign(models.Role("root"), time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		_, err = tokens.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Fail: short secret", func(t *testing.T) {
+		_, err := NewRoleTokens([]byte("short"))
+		assert.Error(t, err)
+	})
+}
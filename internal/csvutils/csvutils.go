@@ -13,7 +13,13 @@ func OpenFile(filePath string) (*csv.Reader, error) {
 		return nil, err
 	}
 
-	csvReader := csv.NewReader(file)
+	return NewReader(file)
+}
+
+// NewReader reads the header line of the csv and returns a reader of the following records, every record must have
+// as many fields as the header.
+func NewReader(r io.Reader) (*csv.Reader, error) {
+	csvReader := csv.NewReader(r)
 	// identify fields per record and by pass first metadata line.
 	record, err := csvReader.Read()
 	if err != nil {
@@ -43,7 +49,8 @@ func ReadRows(reader *csv.Reader, n int) ([][]string, []string,error) {
 		if err != nil && errors.Is(err,io.EOF){
 			return records,nil,err
 		}else if err != nil && errors.Is(err,csv.ErrFieldCount){
-			return records[:len(records)-1],records[len(records)-1],nil
+			// the record with the wrong number of fields is returned apart, the next call continues after it.
+			return records,record,nil
 		}
 		records = append(records, record)
 	}
@@ -0,0 +1,44 @@
+package encryptionutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Indexer computes blind indexes, a keyed HMAC of a value which is deterministic and so can be used
+// for lookups and unique constraints on encrypted columns without storing the plaintext.
+// The key must be different from the encryption key.
+type Indexer struct {
+	key []byte
+}
+
+func NewIndexer(k []byte) (*Indexer, error) {
+	if len(k) < 16 {
+		return nil, errors.New("blind index key should be at least 16 bytes")
+	}
+	return &Indexer{
+		key: k,
+	}, nil
+}
+
+func (i *Indexer) Index(data string) string {
+	mac := hmac.New(sha256.New, i.key)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmailIndex returns the blind index of the normalised email, empty email does not have an index.
+func (i *Indexer) EmailIndex(email string) string {
+	email = NormalizeEmail(email)
+	if email == "" {
+		return ""
+	}
+	return i.Index(email)
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -6,51 +6,299 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"regexp"
+	"strings"
 )
 
+var (
+	ErrInvalidKeyLength = errors.New("invalid key length, key should be 16, 24 or 32 bytes")
+	ErrDecryption       = errors.New("ciphertext can't be decrypted or has been tampered with")
+	ErrUnknownKey       = errors.New("ciphertext is encrypted with a key which is not in the keyring")
+	ErrNoEnvelope       = errors.New("ciphertext uses envelope encryption which is not configured")
+)
+
+// ciphertexts are versioned with a text prefix, values without a prefix are from the legacy AES-CFB format.
+// base64 never produces ':' so the prefix can't be confused with a legacy value.
+//   - v1:<base64>          AES-GCM with the legacy key
+//   - v2:<key id>:<base64> AES-GCM with the key of the keyring having the key id
+//   - v3:<base64>          AES-GCM with an embedded data key wrapped by a KeyProvider, see envelope.go
+//   - v4:<base64>          AES-GCM with the key of the user, see userkeys.go
+const (
+	versionGCM   = "v1:"
+	versionKeyed = "v2:"
+)
+
+// DefaultKeyID is the id of the key given to New, it is also the legacy key of the keyring when present.
+const DefaultKeyID = "default"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type key struct {
+	raw  []byte
+	aead cipher.AEAD
+}
+
+func newKey(k []byte) (*key, error) {
+	switch len(k) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidKeyLength
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key{
+		raw:  k,
+		aead: aead,
+	}, nil
+}
+
+// Encryption is a keyring, values are always encrypted with the active key
+// and the older keys are only used for decryption.
 type Encryption struct {
-	key []byte
+	keys     map[string]*key
+	activeID string
+	// legacyID is the key used for ciphertexts without key id.
+	legacyID string
+	// envelope, when set, replaces the active key of the keyring for encryption.
+	envelope *envelope
+	// user, when set, is the key of a single user replacing both the envelope and the active key for encryption.
+	user *key
 }
 
 func New(k []byte) (*Encryption, error) {
-	if len(k)%16 != 0 {
-		return nil, errors.New("invalid key length")
+	return NewKeyring(DefaultKeyID, map[string][]byte{DefaultKeyID: k})
+}
+
+func NewKeyring(activeID string, keys map[string][]byte) (*Encryption, error) {
+	e := &Encryption{
+		keys:     make(map[string]*key, len(keys)),
+		activeID: activeID,
+		legacyID: activeID,
 	}
-	return &Encryption{
-		key: k,
-	}, nil
+
+	for id, k := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+
+		parsed, err := newKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		e.keys[id] = parsed
+	}
+
+	if _, ok := e.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", activeID)
+	}
+
+	if _, ok := e.keys[DefaultKeyID]; ok {
+		e.legacyID = DefaultKeyID
+	}
+
+	return e, nil
+}
+
+// ParseKeys parses a comma separated list of id:key pairs, e.g. "2024:key-one,2025:key-two".
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, k, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, should be in the form id:key", pair)
+		}
+
+		if _, exists := keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		keys[id] = []byte(k)
+	}
+	return keys, nil
 }
 
-func(e *Encryption) Encrypt(data string) (string, error) {
-	block, err := aes.NewCipher(e.key)
+// LoadKeyring builds the keyring from its configuration. defaultKey, when not empty, is registered as DefaultKeyID,
+// keys is parsed with ParseKeys and activeID defaults to DefaultKeyID. legacyID is optional.
+func LoadKeyring(defaultKey, keys, activeID, legacyID string) (*Encryption, error) {
+	parsed, err := ParseKeys(keys)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	plainText := []byte(data)
-	cipherText := make([]byte, aes.BlockSize+len(plainText))
-	iv := cipherText[:aes.BlockSize]
+	if defaultKey != "" {
+		if _, ok := parsed[DefaultKeyID]; ok {
+			return nil, fmt.Errorf("key id %q is reserved for the default key", DefaultKeyID)
+		}
+		parsed[DefaultKeyID] = []byte(defaultKey)
+	}
 
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	if activeID == "" {
+		activeID = DefaultKeyID
+	}
+
+	e, err := NewKeyring(activeID, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacyID != "" {
+		err = e.SetLegacyKey(legacyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// SetLegacyKey sets the key used to decrypt ciphertexts written before key ids were introduced.
+func (e *Encryption) SetLegacyKey(id string) error {
+	if _, ok := e.keys[id]; !ok {
+		return fmt.Errorf("legacy key %q is not in the keyring", id)
+	}
+	e.legacyID = id
+	return nil
+}
+
+// ActiveKeyID returns the id of the key used for encryption, EnvelopeKeyID with envelope encryption
+// and UserKeyID for the Encryption of a user.
+func (e *Encryption) ActiveKeyID() string {
+	if e.user != nil {
+		return UserKeyID
+	}
+	return e.activeID
+}
+
+// NeedsReencryption reports whether the ciphertext is not encrypted with the active key in the current format.
+func (e *Encryption) NeedsReencryption(data string) bool {
+	if e.user != nil {
+		return !strings.HasPrefix(data, versionUser)
+	}
+	if e.envelope != nil {
+		return !strings.HasPrefix(data, versionEnvelope)
+	}
+	return !strings.HasPrefix(data, versionKeyed+e.activeID+":")
+}
+
+// IsVersioned reports whether data has the version prefix of the ciphertexts written by Encrypt.
+func IsVersioned(data string) bool {
+	for _, version := range []string{versionGCM, versionKeyed, versionEnvelope, versionUser} {
+		if strings.HasPrefix(data, version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encrypt encrypts data with AES-GCM and the active key, the associated data is authenticated but not stored
+// so the same associated data must be given to Decrypt.
+func (e *Encryption) Encrypt(data string, associatedData []byte) (string, error) {
+	if e.user != nil {
+		return e.encryptUser(data, associatedData)
+	}
+	if e.envelope != nil {
+		return e.envelope.encrypt(data, associatedData)
+	}
+
+	aead := e.keys[e.activeID].aead
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], plainText)
+	cipherText := aead.Seal(nonce, nonce, []byte(data), associatedData)
 
 	// Encode cipherText to Base64 to ensure it is text-safe
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+	return versionKeyed + e.activeID + ":" + base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+// Decrypt decrypts versioned and legacy ciphertexts, associated data is ignored for legacy AES-CFB ciphertexts.
+func (e *Encryption) Decrypt(data string, associatedData []byte) (string, error) {
+	if plain, ok, err := e.decryptUser(data, associatedData); ok {
+		return plain, err
+	}
+
+	if encoded, ok := strings.CutPrefix(data, versionEnvelope); ok {
+		if e.envelope == nil {
+			return "", ErrNoEnvelope
+		}
+		return e.envelope.decrypt(encoded, associatedData)
+	}
+
+	if keyed, ok := strings.CutPrefix(data, versionKeyed); ok {
+		id, encoded, ok := strings.Cut(keyed, ":")
+		if !ok {
+			return "", ErrDecryption
+		}
+
+		k, ok := e.keys[id]
+		if !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownKey, id)
+		}
+		return openEncoded(k.aead, encoded, associatedData)
+	}
+
+	legacy, ok := e.keys[e.legacyID]
+	if !ok {
+		return "", fmt.Errorf("%w: no legacy key", ErrUnknownKey)
+	}
+
+	if encoded, ok := strings.CutPrefix(data, versionGCM); ok {
+		return openEncoded(legacy.aead, encoded, associatedData)
+	}
+
+	return decryptLegacy(legacy, data)
+}
+
+func openEncoded(aead cipher.AEAD, encoded string, associatedData []byte) (string, error) {
+	cipherText, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return open(aead, cipherText, associatedData)
+}
+
+// open decrypts nonce | ciphertext.
+func open(aead cipher.AEAD, cipherText []byte, associatedData []byte) (string, error) {
+	if len(cipherText) < aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, cipherText := cipherText[:aead.NonceSize()], cipherText[aead.NonceSize():]
+
+	plainText, err := aead.Open(nil, nonce, cipherText, associatedData)
+	if err != nil {
+		return "", ErrDecryption
+	}
+
+	return string(plainText), nil
 }
 
-func (e *Encryption) Decrypt(data string) (string, error) {
+// decryptLegacy decrypts values written by the previous unauthenticated AES-CFB implementation.
+func decryptLegacy(legacy *key, data string) (string, error) {
 	// Decode Base64-encoded cipherText
 	cipherText, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(e.key)
+	block, err := aes.NewCipher(legacy.raw)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,145 @@
+package encryptionutils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encryptLegacy reproduces the previous AES-CFB format to check backward compatibility.
+func encryptLegacy(t *testing.T, key []byte, data string) string {
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+
+	cipherText := make([]byte, aes.BlockSize+len(data))
+	iv := cipherText[:aes.BlockSize]
+	_, err = io.ReadFull(rand.Reader, iv)
+	assert.NoError(t, err)
+
+	stream := cipher.NewCFBEncrypter(block, iv)
+	stream.XORKeyStream(cipherText[aes.BlockSize:], []byte(data))
+
+	return base64.StdEncoding.EncodeToString(cipherText)
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name       string
+		key        []byte
+		throwError bool
+	}{
+		{name: "AES-128 key", key: []byte("0123456789abcdef"), throwError: false},
+		{name: "AES-192 key", key: []byte("0123456789abcdef01234567"), throwError: false},
+		{name: "AES-256 key", key: []byte("0123456789abcdef0123456789abcdef"), throwError: false},
+		{name: "48 bytes key", key: []byte("0123456789abcdef0123456789abcdef0123456789abcdef"), throwError: true},
+		{name: "empty key", key: nil, throwError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.key)
+			if tc.throwError {
+				assert.ErrorIs(t, err, ErrInvalidKeyLength)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := []byte("testtesttesttest")
+	encryp, err := New(key)
+	assert.NoError(t, err)
+
+	encrypted, err := encryp.Encrypt("test@test.com", []byte("1"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, versionKeyed+DefaultKeyID+":"))
+
+	t.Run("Success: decrypt with same associated data", func(t *testing.T) {
+		decrypted, err := encryp.Decrypt(encrypted, []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "test@test.com", decrypted)
+	})
+
+	t.Run("Fail: decrypt with other associated data", func(t *testing.T) {
+		_, err := encryp.Decrypt(encrypted, []byte("2"))
+		assert.ErrorIs(t, err, ErrDecryption)
+	})
+
+	t.Run("Fail: decrypt tampered ciphertext", func(t *testing.T) {
+		prefix := versionKeyed + DefaultKeyID + ":"
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, prefix))
+		assert.NoError(t, err)
+		raw[len(raw)-1] ^= 0xff
+
+		_, err = encryp.Decrypt(prefix+base64.StdEncoding.EncodeToString(raw), []byte("1"))
+		assert.ErrorIs(t, err, ErrDecryption)
+	})
+
+	t.Run("Success: decrypt legacy ciphertext", func(t *testing.T) {
+		decrypted, err := encryp.Decrypt(encryptLegacy(t, key, "test@test.com"), []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "test@test.com", decrypted)
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	keys, err := ParseKeys("default:testtesttesttest, 2025:0123456789abcdef0123456789abcdef")
+	assert.NoError(t, err)
+
+	oldRing, err := New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+
+	newRing, err := NewKeyring("2025", keys)
+	assert.NoError(t, err)
+	assert.Equal(t, "2025", newRing.ActiveKeyID())
+
+	oldEncrypted, err := oldRing.Encrypt("test@test.com", []byte("1"))
+	assert.NoError(t, err)
+
+	newEncrypted, err := newRing.Encrypt("test@test.com", []byte("1"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(newEncrypted, "v2:2025:"))
+
+	t.Run("Success: decrypt with older key of the keyring", func(t *testing.T) {
+		decrypted, err := newRing.Decrypt(oldEncrypted, []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "test@test.com", decrypted)
+	})
+
+	t.Run("Success: decrypt legacy ciphertext with legacy key", func(t *testing.T) {
+		decrypted, err := newRing.Decrypt(encryptLegacy(t, []byte("testtesttesttest"), "test@test.com"), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "test@test.com", decrypted)
+	})
+
+	t.Run("Fail: decrypt with key missing from the keyring", func(t *testing.T) {
+		_, err := oldRing.Decrypt(newEncrypted, []byte("1"))
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("Success: detect values to re-encrypt", func(t *testing.T) {
+		assert.True(t, newRing.NeedsReencryption(oldEncrypted))
+		assert.False(t, newRing.NeedsReencryption(newEncrypted))
+	})
+
+	t.Run("Fail: active key missing from the keyring", func(t *testing.T) {
+		_, err := NewKeyring("2026", keys)
+		assert.Error(t, err)
+	})
+
+	t.Run("Fail: malformed keys", func(t *testing.T) {
+		_, err := ParseKeys("default")
+		assert.Error(t, err)
+
+		_, err = ParseKeys("a:testtesttesttest,a:testtesttesttest")
+		assert.Error(t, err)
+	})
+}
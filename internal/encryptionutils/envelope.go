@@ -0,0 +1,186 @@
+package encryptionutils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// envelope ciphertexts embed the wrapped data key they are encrypted with:
+//
+//	v3:<base64(uint16 wrapped key length | wrapped key | nonce | ciphertext)>
+const versionEnvelope = "v3:"
+
+// EnvelopeKeyID is reported as active key id when values are encrypted with data keys of a KeyProvider.
+const EnvelopeKeyID = "envelope"
+
+var (
+	defaultDataKeyUses       = 1000
+	defaultProviderTimeout   = 10 * time.Second
+	maxUnwrappedKeysInMemory = 4096
+)
+
+type dataKey struct {
+	wrapped []byte
+	key     *key
+	uses    int
+}
+
+// envelope generates data keys, wraps them with the KeyProvider and caches unwrapped keys
+// so the provider is only called once per data key and process.
+type envelope struct {
+	provider KeyProvider
+	// maxUses is the number of values encrypted with a data key before a new one is generated,
+	// 1 means a data key per value.
+	maxUses int
+
+	mu        sync.Mutex
+	current   *dataKey
+	unwrapped map[string]*key
+}
+
+// NewEnvelope returns an Encryption which encrypts every value with a data key wrapped by the provider's master key.
+// A data key is reused for dataKeyUses values (default 1000) before a new one is generated.
+// The optional keyring is only used to decrypt values written before envelope encryption was enabled.
+func NewEnvelope(provider KeyProvider, dataKeyUses int, keyring *Encryption) *Encryption {
+	if dataKeyUses <= 0 {
+		dataKeyUses = defaultDataKeyUses
+	}
+
+	e := &Encryption{
+		keys:     make(map[string]*key),
+		activeID: EnvelopeKeyID,
+		envelope: &envelope{
+			provider:  provider,
+			maxUses:   dataKeyUses,
+			unwrapped: make(map[string]*key),
+		},
+	}
+
+	if keyring != nil {
+		e.keys = keyring.keys
+		e.legacyID = keyring.legacyID
+	}
+
+	return e
+}
+
+func (en *envelope) dataKey() (*dataKey, error) {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+
+	if en.current != nil && en.current.uses < en.maxUses {
+		en.current.uses++
+		return en.current, nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, err
+	}
+
+	k, err := newKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProviderTimeout)
+	defer cancel()
+
+	wrapped, err := en.provider.WrapKey(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) > 0xffff {
+		return nil, errors.New("wrapped data key is too long")
+	}
+
+	en.current = &dataKey{wrapped: wrapped, key: k, uses: 1}
+	// values encrypted by this process don't need the provider to be decrypted.
+	if len(en.unwrapped) >= maxUnwrappedKeysInMemory {
+		clear(en.unwrapped)
+	}
+	en.unwrapped[string(wrapped)] = k
+	return en.current, nil
+}
+
+func (en *envelope) unwrap(wrapped []byte) (*key, error) {
+	en.mu.Lock()
+	k, ok := en.unwrapped[string(wrapped)]
+	en.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProviderTimeout)
+	defer cancel()
+
+	raw, err := en.provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err = newKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	en.mu.Lock()
+	if len(en.unwrapped) >= maxUnwrappedKeysInMemory {
+		clear(en.unwrapped)
+	}
+	en.unwrapped[string(wrapped)] = k
+	en.mu.Unlock()
+
+	return k, nil
+}
+
+func (en *envelope) encrypt(data string, associatedData []byte) (string, error) {
+	dk, err := en.dataKey()
+	if err != nil {
+		return "", err
+	}
+
+	aead := dk.key.aead
+	out := make([]byte, 2, 2+len(dk.wrapped)+aead.NonceSize()+len(data)+aead.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(dk.wrapped)))
+	out = append(out, dk.wrapped...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, []byte(data), associatedData)
+
+	return versionEnvelope + base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (en *envelope) decrypt(encoded string, associatedData []byte) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	if len(raw) < 2 {
+		return "", errors.New("ciphertext too short")
+	}
+
+	wrappedLen := int(binary.BigEndian.Uint16(raw))
+	if len(raw) < 2+wrappedLen {
+		return "", errors.New("ciphertext too short")
+	}
+
+	k, err := en.unwrap(raw[2 : 2+wrappedLen])
+	if err != nil {
+		return "", err
+	}
+
+	return open(k.aead, raw[2+wrappedLen:], associatedData)
+}
@@ -0,0 +1,300 @@
+package encryptionutils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FieldCipher applies the encryption policy declared with the `pii` struct tag on string fields:
+//
+//	Name  string `pii:"encrypt"`                                  // encrypted
+//	Code  string `pii:"encrypt,index=CodeIndex"`                  // encrypted and blind indexed as is into CodeIndex
+//	Email string `pii:"encrypt,index=EmailIndex,normalize=email"` // blind indexed once normalised, see NormalizeEmail
+//	Note  string `pii:"encrypt,legacy=plaintext"`                  // encrypted, unversioned values were written in plaintext
+//
+// Empty values are left empty so they keep meaning "not set", so are the indexes of values normalised to empty.
+type FieldCipher struct {
+	encryp  *Encryption
+	indexer *Indexer
+}
+
+// NewFieldCipher returns a FieldCipher, indexer can be nil when Seal and BlindIndex are never used.
+func NewFieldCipher(encryp *Encryption, indexer *Indexer) *FieldCipher {
+	return &FieldCipher{
+		encryp:  encryp,
+		indexer: indexer,
+	}
+}
+
+type fieldPolicy struct {
+	index  int
+	name   string
+	column string
+	// indexField is the field receiving the blind index, -1 when not indexed.
+	indexField int
+	// normalize is applied to the values before computing their blind index, nil when they are indexed as is.
+	normalize func(string) string
+	// legacyPlaintext is set for fields stored in plaintext before being encrypted,
+	// their values without version prefix are read as is.
+	legacyPlaintext bool
+}
+
+// normalizers are the normalisations of the blind indexes, selected with the normalize option.
+var normalizers = map[string]func(string) string{
+	"email": NormalizeEmail,
+}
+
+var policies sync.Map // reflect.Type -> []fieldPolicy
+
+func policyOf(t reflect.Type) ([]fieldPolicy, error) {
+	if cached, ok := policies.Load(t); ok {
+		return cached.([]fieldPolicy), nil
+	}
+
+	var fields []fieldPolicy
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("pii")
+		if !ok {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		if options[0] != "encrypt" || field.Type.Kind() != reflect.String {
+			return nil, fmt.Errorf("field %s: pii tag is only supported as encrypt on string fields", field.Name)
+		}
+
+		policy := fieldPolicy{index: i, name: field.Name, column: field.Tag.Get("db"), indexField: -1}
+		for _, option := range options[1:] {
+			if option == "legacy=plaintext" {
+				policy.legacyPlaintext = true
+				continue
+			}
+
+			if name, ok := strings.CutPrefix(option, "normalize="); ok {
+				policy.normalize, ok = normalizers[name]
+				if !ok {
+					return nil, fmt.Errorf("field %s: unknown normalization %q", field.Name, name)
+				}
+				continue
+			}
+
+			target, ok := strings.CutPrefix(option, "index=")
+			if !ok {
+				return nil, fmt.Errorf("field %s: unknown pii option %q", field.Name, option)
+			}
+
+			indexField, ok := t.FieldByName(target)
+			if !ok || indexField.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("field %s: index field %s is not a string field", field.Name, target)
+			}
+			policy.indexField = indexField.Index[0]
+		}
+
+		fields = append(fields, policy)
+	}
+
+	policies.Store(t, fields)
+	return fields, nil
+}
+
+func structOf(v interface{}) (reflect.Value, []fieldPolicy, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, errors.New("encryption policy can only be applied to a pointer to a struct")
+	}
+
+	fields, err := policyOf(value.Elem().Type())
+	return value.Elem(), fields, err
+}
+
+// Seal fills the blind indexes and encrypts the fields of v in place.
+func (f *FieldCipher) Seal(v interface{}, associatedData []byte) error {
+	value, fields, err := structOf(v)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		plain := value.Field(field.index).String()
+
+		if field.indexField >= 0 {
+			value.Field(field.indexField).SetString(f.blindIndex(field, plain))
+		}
+
+		if plain == "" {
+			continue
+		}
+
+		encrypted, err := f.encryp.Encrypt(plain, associatedData)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", field.name, err)
+		}
+		value.Field(field.index).SetString(encrypted)
+	}
+
+	return nil
+}
+
+// Open decrypts the fields of v in place.
+func (f *FieldCipher) Open(v interface{}, associatedData []byte) error {
+	return f.OpenExcept(v, associatedData)
+}
+
+// OpenExcept decrypts the fields of v in place except the named ones which are cleared without being decrypted.
+func (f *FieldCipher) OpenExcept(v interface{}, associatedData []byte, except ...string) error {
+	value, fields, err := structOf(v)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if slices.Contains(except, field.name) {
+			value.Field(field.index).SetString("")
+			continue
+		}
+
+		encrypted := value.Field(field.index).String()
+		if encrypted == "" || (field.legacyPlaintext && !IsVersioned(encrypted)) {
+			continue
+		}
+
+		plain, err := f.encryp.Decrypt(encrypted, associatedData)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %w", field.name, err)
+		}
+		value.Field(field.index).SetString(plain)
+	}
+
+	return nil
+}
+
+// NeedsReencryption reports whether any encrypted field of v is not encrypted with the active key.
+func (f *FieldCipher) NeedsReencryption(v interface{}) bool {
+	value, fields, err := structOf(v)
+	if err != nil {
+		return false
+	}
+
+	for _, field := range fields {
+		encrypted := value.Field(field.index).String()
+		if encrypted != "" && f.encryp.NeedsReencryption(encrypted) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reencrypt decrypts and encrypts again with the active key the fields of v which need it.
+func (f *FieldCipher) Reencrypt(v interface{}, associatedData []byte) error {
+	value, fields, err := structOf(v)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		encrypted := value.Field(field.index).String()
+		if encrypted == "" || !f.encryp.NeedsReencryption(encrypted) {
+			continue
+		}
+
+		plain := encrypted
+		if !field.legacyPlaintext || IsVersioned(encrypted) {
+			plain, err = f.encryp.Decrypt(encrypted, associatedData)
+			if err != nil {
+				return fmt.Errorf("decrypting %s: %w", field.name, err)
+			}
+		}
+
+		encrypted, err = f.encryp.Encrypt(plain, associatedData)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", field.name, err)
+		}
+		value.Field(field.index).SetString(encrypted)
+	}
+
+	return nil
+}
+
+// IsEncryptedColumn reports whether the column (db tag) of the struct pointed by v is encrypted.
+func (f *FieldCipher) IsEncryptedColumn(v interface{}, column string) bool {
+	_, fields, err := structOf(v)
+	if err != nil {
+		return false
+	}
+
+	for _, field := range fields {
+		if field.column == column {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FieldCipher) blindIndex(field fieldPolicy, value string) string {
+	if field.normalize != nil {
+		value = field.normalize(value)
+	}
+	if value == "" {
+		return ""
+	}
+	return f.indexer.Index(value)
+}
+
+// BlindIndex returns the blind index of the value of the field of the struct pointed by v, as Seal stores it in the
+// index field. The index of an empty value is empty.
+func (f *FieldCipher) BlindIndex(v interface{}, fieldName string, value string) (string, error) {
+	_, fields, err := structOf(v)
+	if err != nil {
+		return "", err
+	}
+
+	for _, field := range fields {
+		if field.name == fieldName && field.indexField >= 0 {
+			return f.blindIndex(field, value), nil
+		}
+	}
+	return "", fmt.Errorf("field %s is not blind indexed", fieldName)
+}
+
+func (f *FieldCipher) ActiveKeyID() string {
+	return f.encryp.ActiveKeyID()
+}
+
+// ForUser returns a FieldCipher encrypting with the key of a single user, see Encryption.ForUser.
+func (f *FieldCipher) ForUser(userKey []byte) (*FieldCipher, error) {
+	encryp, err := f.encryp.ForUser(userKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewFieldCipher(encryp, f.indexer), nil
+}
+
+func (f *FieldCipher) GenerateUserKey(associatedData []byte) ([]byte, string, error) {
+	return f.encryp.GenerateUserKey(associatedData)
+}
+
+func (f *FieldCipher) WrapUserKey(userKey []byte, associatedData []byte) (string, error) {
+	return f.encryp.WrapUserKey(userKey, associatedData)
+}
+
+func (f *FieldCipher) UnwrapUserKey(wrapped string, associatedData []byte) ([]byte, error) {
+	return f.encryp.UnwrapUserKey(wrapped, associatedData)
+}
+
+func (f *FieldCipher) WrapSecret(secret []byte, name string) (string, error) {
+	return f.encryp.WrapSecret(secret, name)
+}
+
+func (f *FieldCipher) UnwrapSecret(wrapped string, name string) ([]byte, error) {
+	return f.encryp.UnwrapSecret(wrapped, name)
+}
+
+// NeedsRewrap reports whether the wrapped user key is not wrapped with the active master key.
+func (f *FieldCipher) NeedsRewrap(wrapped string) bool {
+	return f.encryp.master().NeedsReencryption(wrapped)
+}
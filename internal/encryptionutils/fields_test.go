@@ -0,0 +1,111 @@
+package encryptionutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRecord struct {
+	Name       string `db:"name" pii:"encrypt,legacy=plaintext"`
+	Email      string `db:"email" pii:"encrypt,index=EmailIndex,normalize=email"`
+	EmailIndex string `db:"email_index"`
+	Code       string `db:"code" pii:"encrypt,index=CodeIndex"`
+	CodeIndex  string `db:"code_index"`
+	Country    string `db:"country"`
+}
+
+func TestFieldCipher(t *testing.T) {
+	encryp, err := New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	indexer, err := NewIndexer([]byte("indexindexindexi"))
+	assert.NoError(t, err)
+	fields := NewFieldCipher(encryp, indexer)
+
+	t.Run("Success: seal and open", func(t *testing.T) {
+		record := &testRecord{Name: "john", Email: "John@Test.com", Country: "IN"}
+		assert.NoError(t, fields.Seal(record, []byte("1")))
+
+		assert.True(t, IsVersioned(record.Name))
+		assert.True(t, IsVersioned(record.Email))
+		assert.Equal(t, indexer.EmailIndex("john@test.com"), record.EmailIndex)
+		assert.Equal(t, "IN", record.Country)
+		assert.False(t, fields.NeedsReencryption(record))
+
+		assert.NoError(t, fields.Open(record, []byte("1")))
+		assert.Equal(t, &testRecord{Name: "john", Email: "John@Test.com", EmailIndex: record.EmailIndex, Country: "IN"}, record)
+	})
+
+	t.Run("Success: blind indexes normalised by their field", func(t *testing.T) {
+		record := &testRecord{Email: " John@Test.com", Code: "AbC"}
+		assert.NoError(t, fields.Seal(record, []byte("1")))
+		assert.Equal(t, indexer.Index("john@test.com"), record.EmailIndex)
+		assert.Equal(t, indexer.Index("AbC"), record.CodeIndex)
+
+		index, err := fields.BlindIndex(&testRecord{}, "Email", "JOHN@test.com ")
+		assert.NoError(t, err)
+		assert.Equal(t, record.EmailIndex, index)
+
+		index, err = fields.BlindIndex(&testRecord{}, "Code", "abc")
+		assert.NoError(t, err)
+		assert.NotEqual(t, record.CodeIndex, index)
+
+		index, err = fields.BlindIndex(&testRecord{}, "Email", " ")
+		assert.NoError(t, err)
+		assert.Empty(t, index)
+
+		_, err = fields.BlindIndex(&testRecord{}, "Name", "john")
+		assert.Error(t, err)
+	})
+
+	t.Run("Success: open except a field", func(t *testing.T) {
+		record := &testRecord{Name: "john", Email: "john@test.com"}
+		assert.NoError(t, fields.Seal(record, []byte("1")))
+		record.Email = "not a ciphertext"
+
+		assert.NoError(t, fields.OpenExcept(record, []byte("1"), "Email"))
+		assert.Equal(t, "john", record.Name)
+		assert.Equal(t, "", record.Email)
+	})
+
+	t.Run("Success: empty values stay empty", func(t *testing.T) {
+		record := &testRecord{}
+		assert.NoError(t, fields.Seal(record, []byte("1")))
+		assert.Equal(t, &testRecord{}, record)
+		assert.NoError(t, fields.Open(record, []byte("1")))
+	})
+
+	t.Run("Success: legacy plaintext is read as is and re-encrypted", func(t *testing.T) {
+		email, err := encryp.Encrypt("john@test.com", []byte("1"))
+		assert.NoError(t, err)
+		record := &testRecord{Name: "john", Email: email}
+
+		assert.True(t, fields.NeedsReencryption(record))
+		assert.NoError(t, fields.Reencrypt(record, []byte("1")))
+		assert.True(t, IsVersioned(record.Name))
+		assert.False(t, fields.NeedsReencryption(record))
+
+		assert.NoError(t, fields.Open(record, []byte("1")))
+		assert.Equal(t, "john", record.Name)
+		assert.Equal(t, "john@test.com", record.Email)
+	})
+
+	t.Run("Fail: open with other associated data", func(t *testing.T) {
+		record := &testRecord{Email: "john@test.com"}
+		assert.NoError(t, fields.Seal(record, []byte("1")))
+		assert.ErrorIs(t, fields.Open(record, []byte("2")), ErrDecryption)
+	})
+
+	t.Run("Success: encrypted columns", func(t *testing.T) {
+		assert.True(t, fields.IsEncryptedColumn(&testRecord{}, "name"))
+		assert.False(t, fields.IsEncryptedColumn(&testRecord{}, "country"))
+	})
+
+	t.Run("Fail: invalid policy", func(t *testing.T) {
+		type invalid struct {
+			Age int `pii:"encrypt"`
+		}
+		assert.Error(t, fields.Seal(&invalid{}, nil))
+		assert.Error(t, fields.Seal(testRecord{}, nil))
+	})
+}
@@ -0,0 +1,164 @@
+package encryptionutils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key, the master key itself never leaves the provider.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+const (
+	ProviderFile  = "file"
+	ProviderVault = "vault"
+)
+
+// ProviderConfig selects and configures a KeyProvider, only the fields of the selected kind are used.
+type ProviderConfig struct {
+	Kind string
+	// KeyFile is the path of the master key for the file provider.
+	KeyFile string
+	// VaultAddr, VaultToken and VaultKey configure the vault transit provider.
+	VaultAddr  string
+	VaultToken string
+	VaultKey   string
+}
+
+func NewKeyProvider(conf ProviderConfig) (KeyProvider, error) {
+	switch conf.Kind {
+	case ProviderFile:
+		return NewFileKeyProvider(conf.KeyFile)
+	case ProviderVault:
+		return NewVaultTransitProvider(conf.VaultAddr, conf.VaultToken, conf.VaultKey, &http.Client{Timeout: 10 * time.Second})
+	default:
+		return nil, fmt.Errorf("unknown key provider %q, use %s or %s", conf.Kind, ProviderFile, ProviderVault)
+	}
+}
+
+// Config is the complete encryption configuration, see LoadKeyring for the keyring fields.
+// When a key provider is configured values are encrypted with envelope encryption and the keyring,
+// which is then optional, is only used to decrypt older values.
+type Config struct {
+	DefaultKey  string
+	Keys        string
+	ActiveKeyID string
+	LegacyKeyID string
+
+	Provider    ProviderConfig
+	DataKeyUses int
+}
+
+// ConfigFromEnv reads the encryption configuration of the binaries from the environment through getenv, e.g. os.Getenv.
+func ConfigFromEnv(getenv func(string) string) (Config, error) {
+	conf := Config{
+		DefaultKey:  getenv("ENCRYPTION_KEY"),
+		Keys:        getenv("ENCRYPTION_KEYS"),
+		ActiveKeyID: getenv("ENCRYPTION_ACTIVE_KEY_ID"),
+		LegacyKeyID: getenv("ENCRYPTION_LEGACY_KEY_ID"),
+		Provider: ProviderConfig{
+			Kind:       getenv("KEY_PROVIDER"),
+			KeyFile:    getenv("MASTER_KEY_FILE"),
+			VaultAddr:  getenv("VAULT_ADDR"),
+			VaultToken: getenv("VAULT_TOKEN"),
+			VaultKey:   getenv("VAULT_TRANSIT_KEY"),
+		},
+	}
+
+	if v := getenv("DATA_KEY_USES"); v != "" {
+		uses, err := strconv.Atoi(v)
+		if err != nil || uses <= 0 {
+			return Config{}, fmt.Errorf("DATA_KEY_USES should be a positive number, got %q", v)
+		}
+		conf.DataKeyUses = uses
+	}
+
+	return conf, nil
+}
+
+func Load(conf Config) (*Encryption, error) {
+	if conf.Provider.Kind == "" {
+		return LoadKeyring(conf.DefaultKey, conf.Keys, conf.ActiveKeyID, conf.LegacyKeyID)
+	}
+
+	provider, err := NewKeyProvider(conf.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring *Encryption
+	if conf.DefaultKey != "" || conf.Keys != "" {
+		keyring, err = LoadKeyring(conf.DefaultKey, conf.Keys, conf.ActiveKeyID, conf.LegacyKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewEnvelope(provider, conf.DataKeyUses, keyring), nil
+}
+
+// dataKeyAssociatedData binds wrapped keys to their usage so they can't be confused with other ciphertexts.
+var dataKeyAssociatedData = []byte("data-key")
+
+// FileKeyProvider wraps data keys with AES-GCM using a master key read from a local file.
+type FileKeyProvider struct {
+	master *key
+}
+
+// NewFileKeyProvider reads the master key from the file, either as raw bytes or base64 encoded.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	if path == "" {
+		return nil, errors.New("master key file is not provided")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := []byte(strings.TrimSpace(string(content)))
+	if decoded, err := base64.StdEncoding.DecodeString(string(raw)); err == nil {
+		if _, err := newKey(decoded); err == nil {
+			raw = decoded
+		}
+	}
+
+	master, err := newKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("master key file: %w", err)
+	}
+
+	return &FileKeyProvider{master: master}, nil
+}
+
+func (f *FileKeyProvider) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, f.master.aead.NonceSize(), f.master.aead.NonceSize()+len(dataKey)+f.master.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return f.master.aead.Seal(nonce, nonce, dataKey, dataKeyAssociatedData), nil
+}
+
+func (f *FileKeyProvider) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	nonceSize := f.master.aead.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, ErrDecryption
+	}
+
+	dataKey, err := f.master.aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], dataKeyAssociatedData)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return dataKey, nil
+}
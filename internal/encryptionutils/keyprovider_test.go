@@ -0,0 +1,207 @@
+package encryptionutils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider counts the calls made to the wrapped provider.
+type countingProvider struct {
+	KeyProvider
+	wraps   atomic.Int64
+	unwraps atomic.Int64
+}
+
+func (c *countingProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	c.wraps.Add(1)
+	return c.KeyProvider.WrapKey(ctx, dataKey)
+}
+
+func (c *countingProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	c.unwraps.Add(1)
+	return c.KeyProvider.UnwrapKey(ctx, wrapped)
+}
+
+func newFileProvider(t *testing.T, content string) *FileKeyProvider {
+	path := filepath.Join(t.TempDir(), "master.key")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	provider, err := NewFileKeyProvider(path)
+	assert.NoError(t, err)
+	return provider
+}
+
+// newVaultStandIn serves the transit encrypt/decrypt endpoints of vault backed by a local master key.
+func newVaultStandIn(t *testing.T, token string) *httptest.Server {
+	master := newFileProvider(t, "0123456789abcdef0123456789abcdef")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/transit/{operation}/users", func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != token {
+			res.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(res).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+
+		data := map[string]string{}
+		switch req.PathValue("operation") {
+		case "encrypt":
+			plain, err := base64.StdEncoding.DecodeString(body["plaintext"])
+			assert.NoError(t, err)
+			wrapped, err := master.WrapKey(req.Context(), plain)
+			assert.NoError(t, err)
+			data["ciphertext"] = "vault:v1:" + base64.StdEncoding.EncodeToString(wrapped)
+		case "decrypt":
+			wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(body["ciphertext"], "vault:v1:"))
+			assert.NoError(t, err)
+			plain, err := master.UnwrapKey(req.Context(), wrapped)
+			if err != nil {
+				res.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(res).Encode(map[string][]string{"errors": {"cipher: message authentication failed"}})
+				return
+			}
+			data["plaintext"] = base64.StdEncoding.EncodeToString(plain)
+		default:
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(res).Encode(map[string]interface{}{"data": data})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	t.Run("Success: raw and base64 master keys", func(t *testing.T) {
+		for _, content := range []string{"testtesttesttest\n", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))} {
+			provider := newFileProvider(t, content)
+
+			wrapped, err := provider.WrapKey(context.Background(), []byte("data key"))
+			assert.NoError(t, err)
+
+			unwrapped, err := provider.UnwrapKey(context.Background(), wrapped)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("data key"), unwrapped)
+		}
+	})
+
+	t.Run("Fail: invalid master key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		assert.NoError(t, os.WriteFile(path, []byte("short"), 0600))
+
+		_, err := NewFileKeyProvider(path)
+		assert.ErrorIs(t, err, ErrInvalidKeyLength)
+	})
+}
+
+func TestVaultTransitProvider(t *testing.T) {
+	server := newVaultStandIn(t, "s.token")
+
+	provider, err := NewVaultTransitProvider(server.URL, "s.token", "users", server.Client())
+	assert.NoError(t, err)
+
+	wrapped, err := provider.WrapKey(context.Background(), []byte("data key"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(wrapped), "vault:v1:"))
+
+	unwrapped, err := provider.UnwrapKey(context.Background(), wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data key"), unwrapped)
+
+	forbidden, err := NewVaultTransitProvider(server.URL, "wrong", "users", server.Client())
+	assert.NoError(t, err)
+
+	_, err = forbidden.WrapKey(context.Background(), []byte("data key"))
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestEnvelope(t *testing.T) {
+	server := newVaultStandIn(t, "s.token")
+	vault, err := NewVaultTransitProvider(server.URL, "s.token", "users", server.Client())
+	assert.NoError(t, err)
+
+	keyring, err := New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	oldEncrypted, err := keyring.Encrypt("old@test.com", []byte("1"))
+	assert.NoError(t, err)
+
+	provider := &countingProvider{KeyProvider: vault}
+	encryp := NewEnvelope(provider, 2, keyring)
+	assert.Equal(t, EnvelopeKeyID, encryp.ActiveKeyID())
+
+	var encrypted []string
+	for i := 0; i < 3; i++ {
+		value, err := encryp.Encrypt("test@test.com", []byte("1"))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(value, versionEnvelope))
+		assert.False(t, encryp.NeedsReencryption(value))
+		encrypted = append(encrypted, value)
+	}
+
+	// a data key is used for two values.
+	assert.Equal(t, int64(2), provider.wraps.Load())
+
+	t.Run("Success: decrypt in another process unwraps each data key once", func(t *testing.T) {
+		other := &countingProvider{KeyProvider: vault}
+		decrypter := NewEnvelope(other, 2, nil)
+
+		for _, value := range append(encrypted, encrypted...) {
+			decrypted, err := decrypter.Decrypt(value, []byte("1"))
+			assert.NoError(t, err)
+			assert.Equal(t, "test@test.com", decrypted)
+		}
+		assert.Equal(t, int64(2), other.unwraps.Load())
+	})
+
+	t.Run("Fail: decrypt with other associated data", func(t *testing.T) {
+		_, err := encryp.Decrypt(encrypted[0], []byte("2"))
+		assert.ErrorIs(t, err, ErrDecryption)
+	})
+
+	t.Run("Success: decrypt values of the keyring", func(t *testing.T) {
+		decrypted, err := encryp.Decrypt(oldEncrypted, []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "old@test.com", decrypted)
+		assert.True(t, encryp.NeedsReencryption(oldEncrypted))
+	})
+
+	t.Run("Fail: decrypt envelope without key provider", func(t *testing.T) {
+		_, err := keyring.Decrypt(encrypted[0], []byte("1"))
+		assert.ErrorIs(t, err, ErrNoEnvelope)
+	})
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	env := func(values map[string]string) func(string) string {
+		return func(name string) string { return values[name] }
+	}
+
+	conf, err := ConfigFromEnv(env(map[string]string{"ENCRYPTION_KEY": "testtesttesttest", "KEY_PROVIDER": ProviderFile, "DATA_KEY_USES": "10"}))
+	assert.NoError(t, err)
+	assert.Equal(t, Config{DefaultKey: "testtesttesttest", Provider: ProviderConfig{Kind: ProviderFile}, DataKeyUses: 10}, conf)
+
+	conf, err = ConfigFromEnv(env(map[string]string{}))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, conf.DataKeyUses)
+
+	for _, uses := range []string{"ten", "0", "-1"} {
+		_, err = ConfigFromEnv(env(map[string]string{"DATA_KEY_USES": uses}))
+		assert.Error(t, err, uses)
+	}
+}
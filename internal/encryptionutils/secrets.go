@@ -0,0 +1,24 @@
+package encryptionutils
+
+import "encoding/base64"
+
+// secrets of the application, e.g. the signing secrets of the webhooks, are encrypted with the master keys apart
+// from the user keys, their associated data is namespaced by secretDataPrefix.
+const secretDataPrefix = "secret:"
+
+// WrapSecret encrypts the secret with the master keys, name is the kind of secret and must be given to UnwrapSecret.
+func (e *Encryption) WrapSecret(secret []byte, name string) (string, error) {
+	return e.master().Encrypt(base64.StdEncoding.EncodeToString(secret), secretData(name))
+}
+
+func (e *Encryption) UnwrapSecret(wrapped string, name string) ([]byte, error) {
+	encoded, err := e.master().Decrypt(wrapped, secretData(name))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func secretData(name string) []byte {
+	return []byte(secretDataPrefix + name)
+}
@@ -0,0 +1,26 @@
+package encryptionutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecrets(t *testing.T) {
+	master, err := New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+
+	wrapped, err := master.WrapSecret([]byte("signing secret"), "webhook")
+	assert.NoError(t, err)
+
+	t.Run("Success: unwrap with the same name", func(t *testing.T) {
+		secret, err := master.UnwrapSecret(wrapped, "webhook")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("signing secret"), secret)
+	})
+
+	t.Run("Fail: unwrap with another name", func(t *testing.T) {
+		_, err := master.UnwrapSecret(wrapped, "other")
+		assert.Error(t, err)
+	})
+}
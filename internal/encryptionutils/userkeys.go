@@ -0,0 +1,99 @@
+package encryptionutils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// values of a user are encrypted with its own data key, see ForUser:
+//
+//	v4:<base64(nonce | ciphertext)>
+//
+// the key is not part of the ciphertext, it is stored wrapped by the master keys next to the user
+// so destroying it makes every copy of the values unrecoverable.
+const versionUser = "v4:"
+
+// UserKeyID is reported as active key id by the Encryption of a user.
+const UserKeyID = "user"
+
+var ErrNoUserKey = errors.New("ciphertext is encrypted with a user key which is not available or has been destroyed")
+
+// GenerateUserKey returns a new random user key and the same key wrapped by the master keys for storage.
+// The associated data binds the wrapped key to its user and must be given to UnwrapUserKey.
+func (e *Encryption) GenerateUserKey(associatedData []byte) ([]byte, string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err := e.WrapUserKey(raw, associatedData)
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, wrapped, nil
+}
+
+// WrapUserKey encrypts the user key with the master keys, it is also used to wrap a key again after a key rotation.
+func (e *Encryption) WrapUserKey(userKey []byte, associatedData []byte) (string, error) {
+	return e.master().Encrypt(base64.StdEncoding.EncodeToString(userKey), associatedData)
+}
+
+func (e *Encryption) UnwrapUserKey(wrapped string, associatedData []byte) ([]byte, error) {
+	encoded, err := e.master().Decrypt(wrapped, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// ForUser returns an Encryption which encrypts with the user key,
+// values encrypted with the master keys before the user had a key are still decrypted.
+func (e *Encryption) ForUser(userKey []byte) (*Encryption, error) {
+	k, err := newKey(userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *e
+	u.user = k
+	return &u, nil
+}
+
+// master returns the Encryption without user key.
+func (e *Encryption) master() *Encryption {
+	if e.user == nil {
+		return e
+	}
+
+	m := *e
+	m.user = nil
+	return &m
+}
+
+func (e *Encryption) encryptUser(data string, associatedData []byte) (string, error) {
+	aead := e.user.aead
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return versionUser + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(data), associatedData)), nil
+}
+
+func (e *Encryption) decryptUser(data string, associatedData []byte) (string, bool, error) {
+	encoded, ok := strings.CutPrefix(data, versionUser)
+	if !ok {
+		return "", false, nil
+	}
+
+	if e.user == nil {
+		return "", true, ErrNoUserKey
+	}
+
+	plain, err := openEncoded(e.user.aead, encoded, associatedData)
+	return plain, true, err
+}
@@ -0,0 +1,69 @@
+package encryptionutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserKeys(t *testing.T) {
+	master, err := New([]byte("testtesttesttest"))
+	assert.NoError(t, err)
+	oldEncrypted, err := master.Encrypt("old@test.com", []byte("1"))
+	assert.NoError(t, err)
+
+	raw, wrapped, err := master.GenerateUserKey([]byte("user_key:1"))
+	assert.NoError(t, err)
+
+	user, err := master.ForUser(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, UserKeyID, user.ActiveKeyID())
+	assert.Equal(t, DefaultKeyID, master.ActiveKeyID())
+
+	encrypted, err := user.Encrypt("test@test.com", []byte("1"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, versionUser))
+	assert.True(t, IsVersioned(encrypted))
+	assert.False(t, user.NeedsReencryption(encrypted))
+	assert.True(t, user.NeedsReencryption(oldEncrypted))
+
+	t.Run("Success: decrypt with the unwrapped key", func(t *testing.T) {
+		unwrapped, err := master.UnwrapUserKey(wrapped, []byte("user_key:1"))
+		assert.NoError(t, err)
+		assert.Equal(t, raw, unwrapped)
+
+		other, err := master.ForUser(unwrapped)
+		assert.NoError(t, err)
+		decrypted, err := other.Decrypt(encrypted, []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "test@test.com", decrypted)
+
+		decrypted, err = other.Decrypt(oldEncrypted, []byte("1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "old@test.com", decrypted)
+	})
+
+	t.Run("Success: user key is wrapped with the master key", func(t *testing.T) {
+		rewrapped, err := user.WrapUserKey(raw, []byte("user_key:1"))
+		assert.NoError(t, err)
+		assert.False(t, master.NeedsReencryption(rewrapped))
+	})
+
+	t.Run("Fail: unwrap the key of another user", func(t *testing.T) {
+		_, err := master.UnwrapUserKey(wrapped, []byte("user_key:2"))
+		assert.ErrorIs(t, err, ErrDecryption)
+	})
+
+	t.Run("Fail: decrypt without the user key", func(t *testing.T) {
+		_, err := master.Decrypt(encrypted, []byte("1"))
+		assert.ErrorIs(t, err, ErrNoUserKey)
+
+		otherRaw, _, err := master.GenerateUserKey([]byte("user_key:1"))
+		assert.NoError(t, err)
+		other, err := master.ForUser(otherRaw)
+		assert.NoError(t, err)
+		_, err = other.Decrypt(encrypted, []byte("1"))
+		assert.ErrorIs(t, err, ErrDecryption)
+	})
+}
@@ -0,0 +1,111 @@
+package encryptionutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VaultTransitProvider wraps data keys with the encrypt/decrypt endpoints of a HashiCorp Vault transit
+// secrets engine (or any server implementing the same HTTP API), the master key stays inside vault.
+type VaultTransitProvider struct {
+	addr    string
+	token   string
+	keyName string
+	client  *http.Client
+}
+
+func NewVaultTransitProvider(addr, token, keyName string, client *http.Client) (*VaultTransitProvider, error) {
+	if addr == "" || token == "" || keyName == "" {
+		return nil, errors.New("vault address, token and transit key name are required")
+	}
+
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("invalid vault address: %w", err)
+	}
+
+	return &VaultTransitProvider{
+		addr:    strings.TrimSuffix(addr, "/"),
+		token:   token,
+		keyName: keyName,
+		client:  client,
+	}, nil
+}
+
+type vaultResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (v *VaultTransitProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	out, err := v.call(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Data.Ciphertext == "" {
+		return nil, errors.New("vault: empty ciphertext in response")
+	}
+
+	return []byte(out.Data.Ciphertext), nil
+}
+
+func (v *VaultTransitProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := v.call(ctx, "decrypt", map[string]string{
+		"ciphertext": string(wrapped),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(out.Data.Plaintext)
+}
+
+func (v *VaultTransitProvider) call(ctx context.Context, operation string, body map[string]string) (*vaultResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/transit/%s/%s", v.addr, operation, url.PathEscape(v.keyName))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var out vaultResponse
+	if err := json.Unmarshal(raw, &out); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("vault: invalid response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: %s failed with status %d: %s", operation, res.StatusCode, strings.Join(out.Errors, ", "))
+	}
+
+	return &out, nil
+}
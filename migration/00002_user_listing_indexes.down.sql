@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS user_details_parent_user_id_idx;
+DROP INDEX IF EXISTS user_details_last_name_idx;
+DROP INDEX IF EXISTS user_details_created_at_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS user_details_created_at_idx ON user_details (COALESCE(created_at,'-infinity'::timestamptz), id);
+CREATE INDEX IF NOT EXISTS user_details_last_name_idx ON user_details (COALESCE(last_name,''), id);
+CREATE INDEX IF NOT EXISTS user_details_parent_user_id_idx ON user_details (parent_user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS user_details_email_index_key;
+DROP INDEX IF EXISTS user_details_email_index_idx;
+ALTER TABLE user_details DROP COLUMN IF EXISTS email_index;
@@ -0,0 +1,2 @@
+ALTER TABLE user_details ADD COLUMN IF NOT EXISTS email_index TEXT;
+CREATE INDEX IF NOT EXISTS user_details_email_index_idx ON user_details (email_index);
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS job_checkpoints (name TEXT PRIMARY KEY, last_id BIGINT NOT NULL, updated_at timestamptz NOT NULL DEFAULT now());
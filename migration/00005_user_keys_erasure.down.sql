@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS erasure_audit_user_id_idx;
+DROP TABLE IF EXISTS erasure_audit;
+DROP TABLE IF EXISTS user_keys;
+ALTER TABLE user_details DROP COLUMN IF EXISTS erased_at;
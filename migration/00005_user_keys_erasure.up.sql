@@ -0,0 +1,4 @@
+ALTER TABLE user_details ADD COLUMN IF NOT EXISTS erased_at timestamptz;
+CREATE TABLE IF NOT EXISTS user_keys (user_id BIGINT PRIMARY KEY, wrapped_key TEXT NOT NULL, created_at timestamptz NOT NULL DEFAULT now());
+CREATE TABLE IF NOT EXISTS erasure_audit (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, erased_at timestamptz NOT NULL DEFAULT now(), key_destroyed BOOLEAN NOT NULL);
+CREATE INDEX IF NOT EXISTS erasure_audit_user_id_idx ON erasure_audit (user_id);
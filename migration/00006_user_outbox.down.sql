@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_outbox_unpublished_idx;
+DROP TABLE IF EXISTS user_outbox;
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS user_outbox (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, event_type TEXT NOT NULL, created_at timestamptz NOT NULL DEFAULT now(), published_at timestamptz);
+CREATE INDEX IF NOT EXISTS user_outbox_unpublished_idx ON user_outbox (id) WHERE published_at IS NULL;
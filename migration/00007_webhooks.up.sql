@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS webhooks (id BIGSERIAL PRIMARY KEY, url TEXT NOT NULL, event_types TEXT[] NOT NULL DEFAULT '{}', wrapped_secret TEXT NOT NULL, enabled BOOLEAN NOT NULL DEFAULT true, consecutive_failures INT NOT NULL DEFAULT 0, disabled_at timestamptz, created_at timestamptz NOT NULL DEFAULT now(), updated_at timestamptz NOT NULL DEFAULT now());
+CREATE TABLE IF NOT EXISTS webhook_deliveries (id BIGSERIAL PRIMARY KEY, webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE, event_id BIGINT NOT NULL, event_type TEXT NOT NULL, user_id BIGINT NOT NULL, event_at timestamptz NOT NULL, status TEXT NOT NULL DEFAULT 'pending', attempts INT NOT NULL DEFAULT 0, last_status_code INT NOT NULL DEFAULT 0, last_error TEXT NOT NULL DEFAULT '', next_attempt_at timestamptz DEFAULT now(), created_at timestamptz NOT NULL DEFAULT now(), updated_at timestamptz NOT NULL DEFAULT now(), UNIQUE (webhook_id, event_id));
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS imports (id TEXT PRIMARY KEY, source TEXT NOT NULL, status TEXT NOT NULL DEFAULT 'running', error TEXT NOT NULL DEFAULT '', started_at timestamptz NOT NULL DEFAULT now(), finished_at timestamptz, rows_read BIGINT NOT NULL DEFAULT 0, published BIGINT NOT NULL DEFAULT 0, consumed BIGINT NOT NULL DEFAULT 0, stored BIGINT NOT NULL DEFAULT 0, duplicates BIGINT NOT NULL DEFAULT 0, failures BIGINT NOT NULL DEFAULT 0);
+CREATE INDEX IF NOT EXISTS imports_started_at_idx ON imports (started_at DESC);
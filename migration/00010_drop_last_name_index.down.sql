@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS user_details_last_name_idx ON user_details (COALESCE(last_name,''), id);
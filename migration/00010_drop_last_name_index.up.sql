@@ -0,0 +1 @@
+DROP INDEX IF EXISTS user_details_last_name_idx;
@@ -0,0 +1 @@
+-- the keys of the deleted users can't be restored.
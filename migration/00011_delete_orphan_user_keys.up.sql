@@ -0,0 +1 @@
+DELETE FROM user_keys WHERE NOT EXISTS (SELECT 1 FROM user_details WHERE user_details.id = user_keys.user_id);
@@ -0,0 +1 @@
+-- the subscriptions to the updated events are not restored.
@@ -0,0 +1,2 @@
+UPDATE webhooks SET event_types = array_remove(event_types, 'updated'), updated_at = now() WHERE 'updated' = ANY(event_types) AND cardinality(event_types) > 1;
+UPDATE webhooks SET enabled = false, disabled_at = COALESCE(disabled_at, now()), updated_at = now() WHERE event_types = '{updated}';
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS user_outbox_unpublished_user_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS user_outbox_unpublished_user_idx ON user_outbox (user_id, id) WHERE published_at IS NULL;
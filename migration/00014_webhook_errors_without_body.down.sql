@@ -0,0 +1 @@
+-- the response bodies removed from the delivery log can't be restored.
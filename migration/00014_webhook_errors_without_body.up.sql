@@ -0,0 +1 @@
+UPDATE webhook_deliveries SET last_error = 'unexpected status ' || last_status_code WHERE last_status_code <> 0 AND last_error LIKE 'unexpected status %';
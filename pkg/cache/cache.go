@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/viswals_task/core/models"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// InvalidationChannel is the pub/sub channel on which the instances announce the users they changed in the shared cache,
+// redis.Redis namespaces it with its key prefix.
+const InvalidationChannel = "invalidate"
+
+// flushAllKey is announced instead of a key when every cached user changed, e.g. after cmd/cachesync -invalidate.
+const flushAllKey = "*"
+
+// Store is the shared cache, e.g. redis.Redis.
+type Store interface {
+	Get(ctx context.Context, key string) (*models.UserDetails, error)
+	// GetMany maps the keys cached as missing to nil, the keys not cached are absent.
+	GetMany(ctx context.Context, keys []string) (map[string]*models.UserDetails, error)
+	Set(ctx context.Context, key string, user *models.UserDetails) error
+	SetMissing(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Bus delivers the invalidation messages to every instance, e.g. redis.Redis.
+type Bus interface {
+	Publish(ctx context.Context, channel, message string) error
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// Cache is a two-tier cache, users are read from the in-process LRU before the shared store.
+// Every change made through the Cache is written to the store and announced on the bus so the other instances
+// drop their local copy. Invalidations missed while the bus is disconnected are bounded by the TTL of the LRU.
+type Cache struct {
+	store Store
+	// local is optional, without it the Cache only announces the changes.
+	local *LRU
+	bus   Bus
+	// counter is optional, reads are only counted when set.
+	counter    ReadCounter
+	reads      reads
+	instanceID string
+	logger     *zap.Logger
+}
+
+func New(store Store, local *LRU, bus Bus, counter ReadCounter, logger *zap.Logger) (*Cache, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		store:      store,
+		local:      local,
+		bus:        bus,
+		counter:    counter,
+		instanceID: hex.EncodeToString(id),
+		logger:     logger,
+	}, nil
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (*models.UserDetails, error) {
+	if c.counter != nil {
+		c.reads.add(key)
+	}
+
+	if c.local == nil {
+		return c.store.Get(ctx, key)
+	}
+
+	if user, ok := c.local.Get(key); ok {
+		return user, nil
+	}
+
+	generation := c.local.Generation()
+	user, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.local.AddIfGeneration(key, user, generation)
+	return user, nil
+}
+
+// GetMany returns the cached users by key like Store.GetMany, the local copies are read first.
+func (c *Cache) GetMany(ctx context.Context, keys []string) (map[string]*models.UserDetails, error) {
+	if c.counter != nil {
+		for _, key := range keys {
+			c.reads.add(key)
+		}
+	}
+
+	if c.local == nil {
+		return c.store.GetMany(ctx, keys)
+	}
+
+	users := make(map[string]*models.UserDetails, len(keys))
+	remaining := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if user, ok := c.local.Get(key); ok {
+			users[key] = user
+			continue
+		}
+		remaining = append(remaining, key)
+	}
+
+	if len(remaining) == 0 {
+		return users, nil
+	}
+
+	generation := c.local.Generation()
+	stored, err := c.store.GetMany(ctx, remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, user := range stored {
+		users[key] = user
+		if user != nil {
+			c.local.AddIfGeneration(key, user, generation)
+		}
+	}
+	return users, nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, user *models.UserDetails) error {
+	err := c.store.Set(ctx, key, user)
+	if err != nil {
+		return err
+	}
+
+	c.invalidate(ctx, key)
+	if c.local != nil {
+		c.local.Add(key, user)
+	}
+	return nil
+}
+
+func (c *Cache) SetMissing(ctx context.Context, key string) error {
+	err := c.store.SetMissing(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	c.invalidate(ctx, key)
+	return nil
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	err := c.store.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	c.invalidate(ctx, key)
+	return nil
+}
+
+// invalidate drops the local copy and announces the change to the other instances.
+func (c *Cache) invalidate(ctx context.Context, key string) {
+	if c.local != nil {
+		c.local.Remove(key)
+	}
+
+	err := c.bus.Publish(ctx, InvalidationChannel, c.instanceID+":"+key)
+	if err != nil {
+		// the local copies of the other instances expire with their TTL.
+		c.logger.Warn("Cache: error publishing invalidation", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// InvalidateAll drops every local copy and announces it to the other instances, to be called once every user has been
+// removed from the store. Unlike the changes of a single user, it fails when the announcement can't be published.
+func (c *Cache) InvalidateAll(ctx context.Context) error {
+	if c.local != nil {
+		c.local.Clear()
+	}
+	return c.bus.Publish(ctx, InvalidationChannel, c.instanceID+":"+flushAllKey)
+}
+
+// Listen drops the local copies of the users changed by the other instances until the context is cancelled.
+func (c *Cache) Listen(ctx context.Context) error {
+	messages, err := c.bus.Subscribe(ctx, InvalidationChannel)
+	if err != nil {
+		return err
+	}
+
+	for message := range messages {
+		instanceID, key, ok := strings.Cut(message, ":")
+		if !ok || instanceID == c.instanceID || c.local == nil {
+			continue
+		}
+		if key == flushAllKey {
+			c.local.Clear()
+			continue
+		}
+		c.local.Remove(key)
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/viswals_task/core/models"
+	"github.com/viswals_task/pkg/redis/mockredis"
+	"go.uber.org/zap"
+)
+
+type testBus struct {
+	published []string
+	messages  chan string
+}
+
+func (b *testBus) Publish(_ context.Context, _, message string) error {
+	b.published = append(b.published, message)
+	return nil
+}
+
+func (b *testBus) Subscribe(_ context.Context, _ string) (<-chan string, error) {
+	return b.messages, nil
+}
+
+func TestLRU(t *testing.T) {
+	t.Run("Success: evicts the least recently used entry", func(t *testing.T) {
+		lru := NewLRU(2, 0, time.Minute)
+		lru.Add("1", &models.UserDetails{ID: 1})
+		lru.Add("2", &models.UserDetails{ID: 2})
+		_, ok := lru.Get("1")
+		assert.True(t, ok)
+
+		lru.Add("3", &models.UserDetails{ID: 3})
+		assert.Equal(t, 2, lru.Len())
+		_, ok = lru.Get("2")
+		assert.False(t, ok)
+		_, ok = lru.Get("1")
+		assert.True(t, ok)
+	})
+
+	t.Run("Success: bounded by memory", func(t *testing.T) {
+		size := entrySize(&entry{key: "1", user: models.UserDetails{FirstName: "john"}})
+		lru := NewLRU(10, 2*size, time.Minute)
+		for _, key := range []string{"1", "2", "3"} {
+			lru.Add(key, &models.UserDetails{FirstName: "john"})
+		}
+		assert.Equal(t, 2, lru.Len())
+
+		lru.Add("4", &models.UserDetails{FirstName: string(make([]byte, 2*size))})
+		assert.Equal(t, 2, lru.Len())
+		_, ok := lru.Get("4")
+		assert.False(t, ok)
+	})
+
+	t.Run("Success: entries expire", func(t *testing.T) {
+		now := time.Now()
+		lru := NewLRU(10, 0, time.Minute)
+		lru.now = func() time.Time { return now }
+		lru.Add("1", &models.UserDetails{ID: 1})
+
+		now = now.Add(2 * time.Minute)
+		_, ok := lru.Get("1")
+		assert.False(t, ok)
+		assert.Equal(t, 0, lru.Len())
+	})
+
+	t.Run("Success: returns copies", func(t *testing.T) {
+		lru := NewLRU(10, 0, time.Minute)
+		user := &models.UserDetails{ID: 1, FirstName: "john"}
+		lru.Add("1", user)
+		user.FirstName = "jane"
+
+		cached, _ := lru.Get("1")
+		cached.LastName = "doe"
+		cached, _ = lru.Get("1")
+		assert.Equal(t, &models.UserDetails{ID: 1, FirstName: "john"}, cached)
+	})
+
+	t.Run("Success: stale reads are not cached after a removal", func(t *testing.T) {
+		lru := NewLRU(10, 0, time.Minute)
+		generation := lru.Generation()
+		lru.Remove("1")
+		lru.AddIfGeneration("1", &models.UserDetails{ID: 1}, generation)
+		assert.Equal(t, 0, lru.Len())
+	})
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	user := &models.UserDetails{ID: 1, FirstName: "john"}
+
+	t.Run("Success: reads through the local cache", func(t *testing.T) {
+		store := new(mockredis.MockRedis)
+		c, err := New(store, NewLRU(10, 0, time.Minute), &testBus{}, nil, zap.NewNop())
+		assert.NoError(t, err)
+
+		store.On("Get", mock.Anything, "1").Return(&models.UserDetails{ID: 1, FirstName: "john"}, nil).Once()
+		for i := 0; i < 2; i++ {
+			cached, err := c.Get(ctx, "1")
+			assert.NoError(t, err)
+			assert.Equal(t, user, cached)
+		}
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Success: reads many through the local cache", func(t *testing.T) {
+		store := new(mockredis.MockRedis)
+		local := NewLRU(10, 0, time.Minute)
+		c, err := New(store, local, &testBus{}, nil, zap.NewNop())
+		assert.NoError(t, err)
+		local.Add("1", user)
+
+		// only the keys without local copy are read from the store, the missing ones are not kept locally.
+		store.On("GetMany", mock.Anything, []string{"2", "3", "4"}).Return(map[string]*models.UserDetails{"2": {ID: 2}, "3": nil}, nil).Once()
+		cached, err := c.GetMany(ctx, []string{"1", "2", "3", "4"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]*models.UserDetails{"1": user, "2": {ID: 2}, "3": nil}, cached)
+		assert.Equal(t, 2, local.Len())
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Success: changes are announced", func(t *testing.T) {
+		store := new(mockredis.MockRedis)
+		bus := &testBus{}
+		local := NewLRU(10, 0, time.Minute)
+		c, err := New(store, local, bus, nil, zap.NewNop())
+		assert.NoError(t, err)
+
+		store.On("Set", mock.Anything, "1", user).Return(nil).Once()
+		store.On("Delete", mock.Anything, "1").Return(nil).Once()
+
+		assert.NoError(t, c.Set(ctx, "1", user))
+		assert.Equal(t, 1, local.Len())
+		assert.NoError(t, c.Delete(ctx, "1"))
+		assert.Equal(t, 0, local.Len())
+		assert.Equal(t, []string{c.instanceID + ":1", c.instanceID + ":1"}, bus.published)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("Success: invalidations of the other instances", func(t *testing.T) {
+		bus := &testBus{messages: make(chan string)}
+		local := NewLRU(10, 0, time.Minute)
+		c, err := New(new(mockredis.MockRedis), local, bus, nil, zap.NewNop())
+		assert.NoError(t, err)
+		local.Add("1", user)
+		local.Add("2", user)
+
+		done := make(chan error)
+		go func() { done <- c.Listen(ctx) }()
+
+		bus.messages <- c.instanceID + ":1"
+		bus.messages <- "other:2"
+		close(bus.messages)
+		assert.NoError(t, <-done)
+
+		_, ok := local.Get("1")
+		assert.True(t, ok)
+		_, ok = local.Get("2")
+		assert.False(t, ok)
+	})
+	t.Run("Success: invalidate all", func(t *testing.T) {
+		bus := &testBus{messages: make(chan string)}
+		local := NewLRU(10, 0, time.Minute)
+		c, err := New(new(mockredis.MockRedis), local, bus, nil, zap.NewNop())
+		assert.NoError(t, err)
+		local.Add("1", user)
+
+		assert.NoError(t, c.InvalidateAll(ctx))
+		assert.Equal(t, 0, local.Len())
+		assert.Equal(t, []string{c.instanceID + ":*"}, bus.published)
+
+		// the announcement of another instance clears the local copies too.
+		local.Add("1", user)
+		local.Add("2", user)
+		done := make(chan error)
+		go func() { done <- c.Listen(ctx) }()
+
+		bus.messages <- "other:*"
+		close(bus.messages)
+		assert.NoError(t, <-done)
+		assert.Equal(t, 0, local.Len())
+	})
+}
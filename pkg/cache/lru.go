@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"container/list"
+	"github.com/viswals_task/core/models"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+type entry struct {
+	key     string
+	user    models.UserDetails
+	size    int
+	expires time.Time
+}
+
+// LRU is an in-process cache of users bounded by the number of entries and their approximate size in memory,
+// the least recently used users are evicted first and entries expire after the TTL.
+type LRU struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	bytes int
+	order *list.List
+	items map[string]*list.Element
+	// generation changes on every removal, see AddIfGeneration.
+	generation uint64
+	now        func() time.Time
+}
+
+func NewLRU(maxEntries, maxBytes int, ttl time.Duration) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get returns a copy of the cached user, callers can modify it.
+func (l *LRU) Get(key string) (*models.UserDetails, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if l.now().After(e.expires) {
+		l.remove(element)
+		return nil, false
+	}
+
+	l.order.MoveToFront(element)
+	user := e.user
+	return &user, true
+}
+
+// Generation returns the current generation to give to AddIfGeneration.
+func (l *LRU) Generation() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.generation
+}
+
+func (l *LRU) Add(key string, user *models.UserDetails) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(key, user)
+}
+
+// AddIfGeneration adds the user only if nothing was removed since the generation was read,
+// so a user read before an invalidation is not cached after it.
+func (l *LRU) AddIfGeneration(key string, user *models.UserDetails, generation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.generation == generation {
+		l.add(key, user)
+	}
+}
+
+func (l *LRU) Remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.generation++
+	if element, ok := l.items[key]; ok {
+		l.remove(element)
+	}
+}
+
+// Clear removes every user.
+func (l *LRU) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.generation++
+	l.order.Init()
+	l.items = make(map[string]*list.Element)
+	l.bytes = 0
+}
+
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) add(key string, user *models.UserDetails) {
+	if element, ok := l.items[key]; ok {
+		l.remove(element)
+	}
+
+	e := &entry{key: key, user: *user, expires: l.now().Add(l.ttl)}
+	e.size = entrySize(e)
+	if l.maxBytes > 0 && e.size > l.maxBytes {
+		return
+	}
+
+	l.items[key] = l.order.PushFront(e)
+	l.bytes += e.size
+
+	for l.order.Len() > l.maxEntries || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *LRU) remove(element *list.Element) {
+	e := l.order.Remove(element).(*entry)
+	delete(l.items, e.key)
+	l.bytes -= e.size
+}
+
+// entrySize approximates the memory used by the entry.
+func entrySize(e *entry) int {
+	return int(unsafe.Sizeof(*e)) + len(e.key) + len(e.user.FirstName) + len(e.user.LastName) + len(e.user.EmailAddress) + len(e.user.EmailIndex)
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// ReadCounter stores the number of reads per key, e.g. redis.Redis, the most read users are warmed first.
+type ReadCounter interface {
+	IncrementReads(ctx context.Context, counts map[string]int64) error
+}
+
+// reads buffers the reads counted between two flushes so a read never waits for the counter.
+type reads struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (r *reads) add(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.counts == nil {
+		r.counts = make(map[string]int64)
+	}
+	r.counts[key]++
+}
+
+func (r *reads) take() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := r.counts
+	r.counts = nil
+	return counts
+}
+
+// FlushReads writes the buffered read counts to the counter every interval until the context is cancelled.
+// Counts which can't be written are dropped, they are only a hint for the warm-up.
+func (c *Cache) FlushReads(ctx context.Context, interval time.Duration) {
+	if c.counter == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			counts := c.reads.take()
+			if len(counts) == 0 {
+				continue
+			}
+
+			err := c.counter.IncrementReads(ctx, counts)
+			if err != nil {
+				c.logger.Warn("Cache: error flushing read counts", zap.Int("keys", len(counts)), zap.Error(err))
+			}
+		}
+	}
+}
@@ -10,13 +10,22 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/lib/pq"
 	"github.com/viswals_task/core/models"
+	"strings"
+	"time"
 )
 
 var (
-	ErrNoData    = errors.New("requested data does not exist")
-	ErrDuplicate = errors.New("data to create already exists")
+	ErrNoData         = errors.New("requested data does not exist")
+	ErrDuplicate      = errors.New("data to create already exists")
+	ErrDuplicateEmail = errors.New("user with the same email already exists")
 )
 
+// insertOutboxEvent records a change of a user in the outbox, it is executed in the transaction of the change.
+const insertOutboxEvent = "INSERT INTO user_outbox (user_id,event_type) VALUES ($1,$2);"
+
+// uniqueEmailIndex is the optional unique index on the email blind index, see SetUniqueEmail.
+const uniqueEmailIndex = "user_details_email_index_key"
+
 // using default exec/query syntax as go package internally has protection for SQL injection. (alternatively, we can use a prepare statement)
 
 type Database struct {
@@ -43,17 +52,100 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-func (d *Database) CreateUser(ctx context.Context, userDetails *models.UserDetails) error {
+// CreateUser inserts the user together with its wrapped key, the key is not stored when wrappedKey is empty. The
+// response of the request is stored with the user when the context carries an idempotency record. The creation is
+// recorded in the outbox, followed by the merge when the user is already merged.
+func (d *Database) CreateUser(ctx context.Context, userDetails *models.UserDetails, wrappedKey string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, userDetails, wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	if record := models.IdempotencyFromContext(ctx); record != nil {
+		_, err = tx.ExecContext(ctx, completeIdempotencyKey, record.Scope, record.Key, record.StatusCode, record.Response)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateUsers inserts the users with their wrapped keys like CreateUser in a single transaction and returns the
+// outcome of each user in order, ErrDuplicate or ErrDuplicateEmail for the users already created, a duplicate does
+// not stop the others. An error is returned when the transaction fails, no user is created then.
+func (d *Database) CreateUsers(ctx context.Context, users []*models.UserDetails, wrappedKeys []string) ([]error, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	errs := make([]error, len(users))
+	for i, user := range users {
+		// a failed insert aborts the transaction, it is rolled back to before the user only.
+		_, err = tx.ExecContext(ctx, "SAVEPOINT create_user;")
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertUser(ctx, tx, user, wrappedKeys[i])
+		if errors.Is(err, ErrDuplicate) || errors.Is(err, ErrDuplicateEmail) {
+			errs[i] = err
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT create_user;")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// insertUser inserts the user, its key and its outbox events in the transaction.
+func insertUser(ctx context.Context, tx *sql.Tx, userDetails *models.UserDetails, wrappedKey string) error {
 	// insert data in database.
-	_, err := d.db.ExecContext(ctx, "INSERT INTO user_details (id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8);", userDetails.ID, userDetails.FirstName, userDetails.LastName, userDetails.EmailAddress, userDetails.CreatedAt, userDetails.DeletedAt, userDetails.MergedAt, userDetails.ParentUserId)
+	_, err := tx.ExecContext(ctx, "INSERT INTO user_details (id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id,email_index) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,NULLIF($9,''));", userDetails.ID, userDetails.FirstName, userDetails.LastName, userDetails.EmailAddress, userDetails.CreatedAt, userDetails.DeletedAt, userDetails.MergedAt, userDetails.ParentUserId, userDetails.EmailIndex)
 	if err != nil {
 		// check for data already exists.
 		var e *pq.Error
 		if errors.As(err, &e) && e.Code == "23505" {
+			if e.Constraint == uniqueEmailIndex {
+				return ErrDuplicateEmail
+			}
 			return ErrDuplicate
 		}
 		return err
 	}
+
+	if wrappedKey != "" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_keys (user_id,wrapped_key) VALUES ($1,$2);", userDetails.ID, wrappedKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, insertOutboxEvent, userDetails.ID, models.EventCreated)
+	if err != nil {
+		return err
+	}
+
+	if userDetails.Merged() {
+		_, err = tx.ExecContext(ctx, insertOutboxEvent, userDetails.ID, models.EventMerged)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -87,9 +179,9 @@ func (d *Database) CreateUser(ctx context.Context, userDetails *models.UserDetai
 func (d *Database) GetUserByID(ctx context.Context, id string) (*models.UserDetails, error) {
 	var userDetails models.UserDetails
 
-	row := d.db.QueryRowContext(ctx, "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details WHERE id = $1;", id)
+	row := d.db.QueryRowContext(ctx, "SELECT id,COALESCE(first_name,''),COALESCE(last_name,''),COALESCE(email_address,''),created_at,deleted_at,merged_at,parent_user_id,erased_at FROM user_details WHERE id = $1;", id)
 
-	err := row.Scan(&userDetails.ID, &userDetails.FirstName, &userDetails.LastName, &userDetails.EmailAddress, &userDetails.CreatedAt, &userDetails.DeletedAt, &userDetails.MergedAt, &userDetails.ParentUserId)
+	err := row.Scan(&userDetails.ID, &userDetails.FirstName, &userDetails.LastName, &userDetails.EmailAddress, &userDetails.CreatedAt, &userDetails.DeletedAt, &userDetails.MergedAt, &userDetails.ParentUserId, &userDetails.ErasedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoData
@@ -99,18 +191,206 @@ func (d *Database) GetUserByID(ctx context.Context, id string) (*models.UserDeta
 	return &userDetails, nil
 }
 
-func (d *Database) GetAllUsers(ctx context.Context) ([]*models.UserDetails, error) {
-	var userDetails []*models.UserDetails
-	rows, err := d.db.QueryContext(ctx, "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details")
+// GetUsersByIDs returns the users with the given ids in no particular order, the ids without user are left out.
+// Tombstones of erased users are returned like GetUserByID does.
+func (d *Database) GetUsersByIDs(ctx context.Context, ids []int64) ([]*models.UserDetails, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id,COALESCE(first_name,''),COALESCE(last_name,''),COALESCE(email_address,''),created_at,deleted_at,merged_at,parent_user_id,erased_at FROM user_details WHERE id = ANY($1);", pq.Array(ids))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := make([]*models.UserDetails, 0, len(ids))
+	for rows.Next() {
+		var userDetails models.UserDetails
+		err := rows.Scan(&userDetails.ID, &userDetails.FirstName, &userDetails.LastName, &userDetails.EmailAddress, &userDetails.CreatedAt, &userDetails.DeletedAt, &userDetails.MergedAt, &userDetails.ParentUserId, &userDetails.ErasedAt)
+		if err != nil {
 			return nil, err
 		}
+		users = append(users, &userDetails)
+	}
+
+	return users, rows.Err()
+}
+
+// sortExpressions maps the sortable fields to the expression used for ordering and keyset comparison.
+// NULL values are coalesced so that they still have a stable position in the keyset.
+var sortExpressions = map[models.SortField]string{
+	models.SortByID:        "id",
+	models.SortByCreatedAt: "COALESCE(created_at,'-infinity'::timestamptz)",
+}
+
+var sortCasts = map[models.SortField]string{
+	models.SortByCreatedAt: "timestamptz",
+}
+
+// userFilter builds the WHERE clause for the filters of the query, the cursor is not part of it.
+// Tombstones of erased users are never listed.
+func userFilter(query *models.UserQuery) ([]string, []interface{}) {
+	conditions := []string{"erased_at IS NULL"}
+	var args []interface{}
+
+	if query.CreatedAfter != nil {
+		args = append(args, *query.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if query.CreatedBefore != nil {
+		args = append(args, *query.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	if query.Deleted != nil {
+		if *query.Deleted {
+			conditions = append(conditions, "deleted_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "deleted_at IS NULL")
+		}
+	}
+
+	if query.Merged != nil {
+		if *query.Merged {
+			conditions = append(conditions, "merged_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "merged_at IS NULL")
+		}
+	}
+
+	if query.ParentUserID != nil {
+		args = append(args, *query.ParentUserID)
+		conditions = append(conditions, fmt.Sprintf("parent_user_id = $%d", len(args)))
+	}
+
+	if query.EmailIndex != "" {
+		args = append(args, query.EmailIndex)
+		conditions = append(conditions, fmt.Sprintf("email_index = $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// FilterUsers returns a single page of users matching the query using keyset pagination on (sort field, id).
+func (d *Database) FilterUsers(ctx context.Context, query *models.UserQuery) ([]*models.UserDetails, error) {
+	statement, args, err := selectUsers(query)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, query.Limit)
+	rows, err := d.db.QueryContext(ctx, statement+fmt.Sprintf(" LIMIT $%d;", len(args)), args...)
+	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
+	return scanUsers(rows)
+}
+
+// StreamUsers calls fn with the users matching the query in its order, batchSize users at a time. The users are
+// read through a server side cursor, only a batch is held in memory whatever the number of users.
+func (d *Database) StreamUsers(ctx context.Context, query *models.UserQuery, batchSize int, fn func([]*models.UserDetails) error) error {
+	statement, args, err := selectUsers(query)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE user_stream NO SCROLL CURSOR FOR "+statement+";", args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM user_stream;", batchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+
+		users, err := scanUsers(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		if len(users) == 0 {
+			return tx.Commit()
+		}
+
+		err = fn(users)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// selectUsers builds the statement selecting the users matching the query in its order, without limit.
+func selectUsers(query *models.UserQuery) (string, []interface{}, error) {
+	sortBy := query.SortBy
+	if sortBy == "" {
+		sortBy = models.SortByID
+	}
+
+	expression, ok := sortExpressions[sortBy]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported sort field %q", sortBy)
+	}
+
+	direction, comparator := "ASC", ">"
+	if query.Descending {
+		direction, comparator = "DESC", "<"
+	}
+
+	conditions, args := userFilter(query)
+
+	if query.After != nil {
+		if sortBy == models.SortByID {
+			args = append(args, query.After.ID)
+			conditions = append(conditions, fmt.Sprintf("id %s $%d", comparator, len(args)))
+		} else {
+			args = append(args, query.After.Value, query.After.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", expression, comparator, len(args)-1, sortCasts[sortBy], len(args)))
+		}
+	}
+
+	orderBy := fmt.Sprintf(" ORDER BY id %s", direction)
+	if sortBy != models.SortByID {
+		orderBy = fmt.Sprintf(" ORDER BY %s %s, id %s", expression, direction, direction)
+	}
+
+	statement := "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details" +
+		whereClause(conditions) + orderBy
+
+	return statement, args, nil
+}
+
+// CountUsers returns the number of users matching the filters of the query irrespective of the page.
+func (d *Database) CountUsers(ctx context.Context, query *models.UserQuery) (int64, error) {
+	conditions, args := userFilter(query)
+
+	var count int64
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_details"+whereClause(conditions)+";", args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func scanUsers(rows *sql.Rows) ([]*models.UserDetails, error) {
+	var userDetails []*models.UserDetails
+
 	for rows.Next() {
 		var userDetail models.UserDetails
 		err := rows.Scan(&userDetail.ID, &userDetail.FirstName, &userDetail.LastName, &userDetail.EmailAddress, &userDetail.CreatedAt, &userDetail.DeletedAt, &userDetail.MergedAt, &userDetail.ParentUserId)
@@ -121,13 +401,13 @@ func (d *Database) GetAllUsers(ctx context.Context) ([]*models.UserDetails, erro
 		userDetails = append(userDetails, &userDetail)
 	}
 
-	return userDetails, nil
+	return userDetails, rows.Err()
 }
 
 func (d *Database) ListUsers(ctx context.Context, limit, offset int64) ([]*models.UserDetails, error) {
 	var userDetails []*models.UserDetails
 
-	rows, err := d.db.QueryContext(ctx, "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details ORDER BY id LIMIT $1 OFFSET $2;", limit, offset)
+	rows, err := d.db.QueryContext(ctx, "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details WHERE erased_at IS NULL ORDER BY id LIMIT $1 OFFSET $2;", limit, offset)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoData
@@ -150,15 +430,283 @@ func (d *Database) ListUsers(ctx context.Context, limit, offset int64) ([]*model
 	return userDetails, nil
 }
 
+// DeleteUser deletes the user and its key and records the deletion in the outbox. ErrNoData is returned when the user
+// does not exist or is already deleted.
 func (d *Database) DeleteUser(ctx context.Context, id string) error {
-	_, err := d.db.ExecContext(ctx, "DELETE FROM user_details WHERE id = $1;", id)
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, "DELETE FROM user_details WHERE id = $1 RETURNING id;", id).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoData
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_keys WHERE user_id = $1;", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, insertOutboxEvent, userID, models.EventDeleted)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateUserPII replaces the encrypted personal data of the user only if it still has the old values,
+// so a concurrent change is never overwritten. ErrNoData is returned when nothing was updated.
+func (d *Database) UpdateUserPII(ctx context.Context, old, updated *models.UserDetails) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE user_details SET first_name = $5, last_name = $6, email_address = $7 WHERE id = $1 AND first_name IS NOT DISTINCT FROM $2 AND last_name IS NOT DISTINCT FROM $3 AND email_address IS NOT DISTINCT FROM $4;", old.ID, old.FirstName, old.LastName, old.EmailAddress, updated.FirstName, updated.LastName, updated.EmailAddress)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNoData
+	}
+
+	return nil
+}
+
+// GetUserKeys returns the wrapped keys of the users by user id, users without key are absent.
+func (d *Database) GetUserKeys(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT user_id,wrapped_key FROM user_keys WHERE user_id = ANY($1);", pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := make(map[int64]string, len(userIDs))
+	for rows.Next() {
+		var userID int64
+		var wrappedKey string
+		err := rows.Scan(&userID, &wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[userID] = wrappedKey
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateUserKey stores the key of a user created before keys existed,
+// ErrNoData is returned when the user already has a key, does not exist or has been erased.
+func (d *Database) CreateUserKey(ctx context.Context, userID int64, wrappedKey string) error {
+	result, err := d.db.ExecContext(ctx, "INSERT INTO user_keys (user_id,wrapped_key) SELECT $1,$2 WHERE EXISTS (SELECT 1 FROM user_details WHERE id = $1 AND erased_at IS NULL) ON CONFLICT (user_id) DO NOTHING;", userID, wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// UpdateUserKey replaces the wrapped key of the user only if it is still the old one, ErrNoData is returned otherwise.
+func (d *Database) UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE user_keys SET wrapped_key = $3 WHERE user_id = $1 AND wrapped_key = $2;", userID, oldWrappedKey, wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// EraseUser destroys the key of the user, removes its personal data keeping the row as a tombstone
+// (so the user can't be created again by a replayed message) and records the erasure in erasure_audit.
+// Erasing an erased user again only adds an audit record. ErrNoData is returned when the user does not exist.
+func (d *Database) EraseUser(ctx context.Context, userID int64) (*models.ErasureRecord, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_details SET first_name = NULL, last_name = NULL, email_address = NULL, email_index = NULL, erased_at = COALESCE(erased_at, now()) WHERE id = $1;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireAffected(result)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = tx.ExecContext(ctx, "DELETE FROM user_keys WHERE user_id = $1;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	destroyed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.ErasureRecord{UserID: userID, KeyDestroyed: destroyed > 0}
+	err = tx.QueryRowContext(ctx, "INSERT INTO erasure_audit (user_id,key_destroyed) VALUES ($1,$2) RETURNING id,erased_at;", userID, record.KeyDestroyed).Scan(&record.ID, &record.ErasedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, insertOutboxEvent, userID, models.EventErased)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNoData
+	}
 
 	return nil
 }
 
+// RelayOutbox passes up to limit unpublished outbox events, oldest first, to publish and marks them as published
+// when it succeeds. The events stay locked until then, concurrent relays skip them and publish the next ones. Only the
+// oldest unpublished event of a user is relayed so the events of a user are still published in order, its next event
+// is relayed once it is published. An event is published again if marking it fails, it is never lost.
+// It returns the number of events published.
+func (d *Database) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.OutboxEvent) error) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id,user_id,event_type,created_at FROM user_outbox o WHERE published_at IS NULL AND NOT EXISTS (SELECT 1 FROM user_outbox e WHERE e.user_id = o.user_id AND e.published_at IS NULL AND e.id < o.id) ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED;", limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []*models.OutboxEvent
+	var ids []int64
+	for rows.Next() {
+		event := new(models.OutboxEvent)
+		err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.At)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+		ids = append(ids, event.ID)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	err = publish(ctx, events)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_outbox SET published_at = now() WHERE id = ANY($1);", pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// PruneOutbox deletes the outbox events published before the time and returns how many were deleted.
+func (d *Database) PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM user_outbox WHERE published_at < $1;", publishedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetCheckpoint returns the last id processed by the named job, 0 if the job never saved a checkpoint.
+func (d *Database) GetCheckpoint(ctx context.Context, name string) (int64, error) {
+	var lastID int64
+	err := d.db.QueryRowContext(ctx, "SELECT last_id FROM job_checkpoints WHERE name = $1;", name).Scan(&lastID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return lastID, nil
+}
+
+func (d *Database) SaveCheckpoint(ctx context.Context, name string, lastID int64) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO job_checkpoints (name,last_id,updated_at) VALUES ($1,$2,now()) ON CONFLICT (name) DO UPDATE SET last_id = EXCLUDED.last_id, updated_at = EXCLUDED.updated_at;", name, lastID)
+	return err
+}
+
+// ListUsersWithoutEmailIndex returns up to limit users after afterID, in id order, having an email without blind
+// index, i.e. created before the index existed.
+func (d *Database) ListUsersWithoutEmailIndex(ctx context.Context, afterID int64, limit int64) ([]*models.UserDetails, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id FROM user_details WHERE id > $1 AND email_index IS NULL AND COALESCE(email_address,'') <> '' AND erased_at IS NULL ORDER BY id LIMIT $2;", afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+// SetEmailIndex stores the blind index of the email of the user, only if the email is still the one indexed.
+// ErrNoData is returned otherwise.
+func (d *Database) SetEmailIndex(ctx context.Context, user *models.UserDetails, index string) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE user_details SET email_index = $3 WHERE id = $1 AND email_address IS NOT DISTINCT FROM $2;", user.ID, user.EmailAddress, index)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// SetUniqueEmail creates or drops the unique index on the email blind index.
+// Creating the index fails if users with the same email already exist.
+func (d *Database) SetUniqueEmail(ctx context.Context, enabled bool) error {
+	statement := "DROP INDEX IF EXISTS " + uniqueEmailIndex + ";"
+	if enabled {
+		statement = "CREATE UNIQUE INDEX IF NOT EXISTS " + uniqueEmailIndex + " ON user_details (email_index);"
+	}
+
+	_, err := d.db.ExecContext(ctx, statement)
+	return err
+}
+
 func (d *Database) Migrate(databaseName string) error {
 	driver, err := postgres.WithInstance(d.db, &postgres.Config{})
 	if err != nil {
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/viswals_task/core/models"
+	"time"
+)
+
+// completeIdempotencyKey stores the response of a request, it is executed in the transaction of the write of the
+// request when the context carries its record, see models.WithIdempotency.
+const completeIdempotencyKey = "UPDATE idempotency_keys SET status_code = $3, response = $4 WHERE scope = $1 AND key = $2 AND status_code IS NULL;"
+
+// ReserveIdempotencyKey stores the key of a request in progress. When the key is already used and not expired, the
+// stored record is returned instead, the request must not be handled then. The key of a request still without response
+// after its lease is taken over, the request crashed before completing or releasing it.
+func (d *Database) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	var reserved bool
+	err := d.db.QueryRowContext(ctx, "INSERT INTO idempotency_keys (scope,key,request_hash,expires_at,lease_expires_at) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (scope,key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL, response = NULL, created_at = now(), expires_at = EXCLUDED.expires_at, lease_expires_at = EXCLUDED.lease_expires_at WHERE idempotency_keys.expires_at <= now() OR (idempotency_keys.status_code IS NULL AND idempotency_keys.lease_expires_at <= now()) RETURNING true;", record.Scope, record.Key, record.RequestHash, record.ExpiresAt, record.LeaseExpiresAt).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	stored := &models.IdempotencyRecord{Scope: record.Scope, Key: record.Key}
+	err = d.db.QueryRowContext(ctx, "SELECT request_hash,COALESCE(status_code,0),response,expires_at FROM idempotency_keys WHERE scope = $1 AND key = $2;", record.Scope, record.Key).Scan(&stored.RequestHash, &stored.StatusCode, &stored.Response, &stored.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the request holding the key released it meanwhile, it is still in progress for the caller.
+		stored.RequestHash = record.RequestHash
+		return stored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request, the response stored with its write is kept.
+func (d *Database) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	_, err := d.db.ExecContext(ctx, completeIdempotencyKey, record.Scope, record.Key, record.StatusCode, record.Response)
+	return err
+}
+
+// ReleaseIdempotencyKey deletes the key of a request which failed without response, e.g. on a timeout, so that it can
+// be retried. The key of a request whose write committed is kept.
+func (d *Database) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status_code IS NULL;", record.Scope, record.Key)
+	return err
+}
+
+// PruneIdempotencyKeys deletes the keys expired before the given time and returns their number.
+func (d *Database) PruneIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1;", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
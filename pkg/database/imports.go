@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/viswals_task/core/models"
+)
+
+const importColumns = "id,source,status,error,started_at,finished_at,rows_read,published,consumed,stored,duplicates,failures"
+
+func scanImport(row rowScanner) (*models.Import, error) {
+	imp := new(models.Import)
+	err := row.Scan(&imp.ID, &imp.Source, &imp.Status, &imp.Error, &imp.StartedAt, &imp.FinishedAt, &imp.RowsRead, &imp.Published, &imp.Consumed, &imp.Stored, &imp.Duplicates, &imp.Failures)
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// CreateImport stores the import as running and fills its generated fields.
+func (d *Database) CreateImport(ctx context.Context, imp *models.Import) error {
+	created, err := scanImport(d.db.QueryRowContext(ctx, "INSERT INTO imports (id,source) VALUES ($1,$2) RETURNING "+importColumns+";", imp.ID, imp.Source))
+	if err != nil {
+		return err
+	}
+
+	*imp = *created
+	return nil
+}
+
+// AddImportProgress adds the counts to the import, a published import is completed once every published user is
+// consumed. ErrNoData is returned when the import does not exist.
+func (d *Database) AddImportProgress(ctx context.Context, id string, progress *models.ImportProgress) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE imports SET rows_read = rows_read + $2, published = published + $3, consumed = consumed + $4, stored = stored + $5, duplicates = duplicates + $6, failures = failures + $7, status = CASE WHEN status = 'published' AND consumed + $4 >= published + $3 THEN 'completed' ELSE status END, finished_at = CASE WHEN status = 'published' AND consumed + $4 >= published + $3 THEN now() ELSE finished_at END WHERE id = $1;", id, progress.RowsRead, progress.Published, progress.Consumed, progress.Stored, progress.Duplicates, progress.Failures)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// FinishImport ends the publishing of the import with status ImportPublished or ImportFailed, a published import
+// whose users are all consumed already is completed. ErrNoData is returned when the import does not exist.
+func (d *Database) FinishImport(ctx context.Context, id string, status models.ImportStatus, reason string) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE imports SET status = CASE WHEN $2::text = 'published' AND consumed >= published THEN 'completed' ELSE $2::text END, error = $3, finished_at = CASE WHEN $2::text = 'published' AND consumed < published THEN NULL ELSE now() END WHERE id = $1;", id, status, reason)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (d *Database) GetImport(ctx context.Context, id string) (*models.Import, error) {
+	imp, err := scanImport(d.db.QueryRowContext(ctx, "SELECT "+importColumns+" FROM imports WHERE id = $1;", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoData
+	}
+	return imp, err
+}
+
+// ListImports returns the last imports, most recent first.
+func (d *Database) ListImports(ctx context.Context, limit int64) ([]*models.Import, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+importColumns+" FROM imports ORDER BY started_at DESC, id LIMIT $1;", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	imports := make([]*models.Import, 0)
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+
+	return imports, rows.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/stretchr/testify/mock"
 	"github.com/viswals_task/core/models"
+	"time"
 )
 
 type MockDatabase struct {
@@ -15,16 +16,26 @@ func (db *MockDatabase) GetUserByID(ctx context.Context, id string) (*models.Use
 	return args.Get(0).(*models.UserDetails), args.Error(1)
 }
 
-func (db *MockDatabase) CreateUser(ctx context.Context, user *models.UserDetails) error {
-	args := db.Called(ctx, user)
+func (db *MockDatabase) GetUsersByIDs(ctx context.Context, ids []int64) ([]*models.UserDetails, error) {
+	args := db.Called(ctx, ids)
+	return args.Get(0).([]*models.UserDetails), args.Error(1)
+}
+
+func (db *MockDatabase) CreateUser(ctx context.Context, user *models.UserDetails, wrappedKey string) error {
+	args := db.Called(ctx, user, wrappedKey)
 	return args.Error(0)
 }
 
-func (db *MockDatabase) GetAllUsers(ctx context.Context) ([]*models.UserDetails, error) {
-	args := db.Called(ctx)
+func (db *MockDatabase) FilterUsers(ctx context.Context, query *models.UserQuery) ([]*models.UserDetails, error) {
+	args := db.Called(ctx, query)
 	return args.Get(0).([]*models.UserDetails), args.Error(1)
 }
 
+func (db *MockDatabase) CountUsers(ctx context.Context, query *models.UserQuery) (int64, error) {
+	args := db.Called(ctx, query)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (db *MockDatabase) DeleteUser(ctx context.Context, id string) error {
 	args := db.Called(ctx, id)
 	return args.Error(0)
@@ -34,3 +45,175 @@ func (db *MockDatabase) ListUsers(ctx context.Context, limit, offset int64) ([]*
 	args := db.Called(ctx, limit, offset)
 	return args.Get(0).([]*models.UserDetails), args.Error(1)
 }
+
+func (db *MockDatabase) UpdateUserPII(ctx context.Context, old, updated *models.UserDetails) error {
+	args := db.Called(ctx, old, updated)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) GetUserKeys(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	args := db.Called(ctx, userIDs)
+	return args.Get(0).(map[int64]string), args.Error(1)
+}
+
+func (db *MockDatabase) CreateUsers(ctx context.Context, users []*models.UserDetails, wrappedKeys []string) ([]error, error) {
+	args := db.Called(ctx, users, wrappedKeys)
+	return args.Get(0).([]error), args.Error(1)
+}
+
+func (db *MockDatabase) CreateUserKey(ctx context.Context, userID int64, wrappedKey string) error {
+	args := db.Called(ctx, userID, wrappedKey)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string) error {
+	args := db.Called(ctx, userID, oldWrappedKey, wrappedKey)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) EraseUser(ctx context.Context, userID int64) (*models.ErasureRecord, error) {
+	args := db.Called(ctx, userID)
+	return args.Get(0).(*models.ErasureRecord), args.Error(1)
+}
+
+func (db *MockDatabase) GetCheckpoint(ctx context.Context, name string) (int64, error) {
+	args := db.Called(ctx, name)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (db *MockDatabase) SaveCheckpoint(ctx context.Context, name string, lastID int64) error {
+	args := db.Called(ctx, name, lastID)
+	return args.Error(0)
+}
+
+// RelayOutbox passes the events returned by the expectation to publish.
+func (db *MockDatabase) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.OutboxEvent) error) (int, error) {
+	args := db.Called(ctx, limit)
+	events := args.Get(0).([]*models.OutboxEvent)
+	if args.Error(1) != nil || len(events) == 0 {
+		return 0, args.Error(1)
+	}
+
+	err := publish(ctx, events)
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (db *MockDatabase) PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	args := db.Called(ctx, publishedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (db *MockDatabase) CreateWebhook(ctx context.Context, webhook *models.Webhook, wrappedSecret string) error {
+	args := db.Called(ctx, webhook, wrappedSecret)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	args := db.Called(ctx, id)
+	return args.Get(0).(*models.Webhook), args.Error(1)
+}
+
+func (db *MockDatabase) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	args := db.Called(ctx)
+	return args.Get(0).([]*models.Webhook), args.Error(1)
+}
+
+func (db *MockDatabase) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	args := db.Called(ctx, webhook)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) DeleteWebhook(ctx context.Context, id int64) error {
+	args := db.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) EnqueueDeliveries(ctx context.Context, event *models.OutboxEvent) (int64, error) {
+	args := db.Called(ctx, event)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (db *MockDatabase) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.DeliveryAttempt, error) {
+	args := db.Called(ctx, limit, lease)
+	return args.Get(0).([]*models.DeliveryAttempt), args.Error(1)
+}
+
+func (db *MockDatabase) RecordDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt, result *models.DeliveryResult, disableAfter int) (bool, error) {
+	args := db.Called(ctx, attempt, result, disableAfter)
+	return args.Bool(0), args.Error(1)
+}
+
+func (db *MockDatabase) ListDeliveries(ctx context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error) {
+	args := db.Called(ctx, webhookID, limit)
+	return args.Get(0).([]*models.WebhookDelivery), args.Error(1)
+}
+
+func (db *MockDatabase) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	args := db.Called(ctx, record)
+	return args.Get(0).(*models.IdempotencyRecord), args.Error(1)
+}
+
+func (db *MockDatabase) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	args := db.Called(ctx, record)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	args := db.Called(ctx, record)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) PruneIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	args := db.Called(ctx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (db *MockDatabase) CreateImport(ctx context.Context, imp *models.Import) error {
+	args := db.Called(ctx, imp)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) AddImportProgress(ctx context.Context, id string, progress *models.ImportProgress) error {
+	args := db.Called(ctx, id, progress)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) FinishImport(ctx context.Context, id string, status models.ImportStatus, reason string) error {
+	args := db.Called(ctx, id, status, reason)
+	return args.Error(0)
+}
+
+func (db *MockDatabase) GetImport(ctx context.Context, id string) (*models.Import, error) {
+	args := db.Called(ctx, id)
+	return args.Get(0).(*models.Import), args.Error(1)
+}
+
+func (db *MockDatabase) ListImports(ctx context.Context, limit int64) ([]*models.Import, error) {
+	args := db.Called(ctx, limit)
+	return args.Get(0).([]*models.Import), args.Error(1)
+}
+
+// StreamUsers calls fn with the batches returned first, then returns the error.
+func (db *MockDatabase) StreamUsers(ctx context.Context, query *models.UserQuery, batchSize int, fn func([]*models.UserDetails) error) error {
+	args := db.Called(ctx, query, batchSize)
+	for _, users := range args.Get(0).([][]*models.UserDetails) {
+		err := fn(users)
+		if err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (db *MockDatabase) ListUsersWithoutEmailIndex(ctx context.Context, afterID int64, limit int64) ([]*models.UserDetails, error) {
+	args := db.Called(ctx, afterID, limit)
+	return args.Get(0).([]*models.UserDetails), args.Error(1)
+}
+
+func (db *MockDatabase) SetEmailIndex(ctx context.Context, user *models.UserDetails, index string) error {
+	args := db.Called(ctx, user, index)
+	return args.Error(0)
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/viswals_task/core/models"
+	"time"
+)
+
+const webhookColumns = "id,url,event_types,enabled,consecutive_failures,disabled_at,created_at,updated_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	webhook := new(models.Webhook)
+	var eventTypes []string
+	err := row.Scan(&webhook.ID, &webhook.URL, pq.Array(&eventTypes), &webhook.Enabled, &webhook.ConsecutiveFailures, &webhook.DisabledAt, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.EventTypes = make([]models.EventType, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, models.EventType(t))
+	}
+	return webhook, nil
+}
+
+func eventTypeNames(types []models.EventType) []string {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, string(t))
+	}
+	return names
+}
+
+// CreateWebhook stores the webhook with its wrapped secret and fills its generated fields.
+func (d *Database) CreateWebhook(ctx context.Context, webhook *models.Webhook, wrappedSecret string) error {
+	row := d.db.QueryRowContext(ctx, "INSERT INTO webhooks (url,event_types,wrapped_secret) VALUES ($1,$2,$3) RETURNING "+webhookColumns+";", webhook.URL, pq.Array(eventTypeNames(webhook.EventTypes)), wrappedSecret)
+	created, err := scanWebhook(row)
+	if err != nil {
+		return err
+	}
+
+	created.Secret = webhook.Secret
+	*webhook = *created
+	return nil
+}
+
+func (d *Database) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	webhook, err := scanWebhook(d.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1;", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoData
+	}
+	return webhook, err
+}
+
+func (d *Database) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	webhooks := make([]*models.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook replaces the url, event types and state of the webhook. Enabling a disabled webhook resets its
+// failures, its pending deliveries are then attempted. ErrNoData is returned when the webhook does not exist.
+func (d *Database) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	row := d.db.QueryRowContext(ctx, "UPDATE webhooks SET url = $2, event_types = $3, enabled = $4, consecutive_failures = CASE WHEN $4 AND NOT enabled THEN 0 ELSE consecutive_failures END, disabled_at = CASE WHEN $4 THEN NULL ELSE COALESCE(disabled_at, now()) END, updated_at = now() WHERE id = $1 RETURNING "+webhookColumns+";", webhook.ID, webhook.URL, pq.Array(eventTypeNames(webhook.EventTypes)), webhook.Enabled)
+	updated, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoData
+	}
+	if err != nil {
+		return err
+	}
+
+	*webhook = *updated
+	return nil
+}
+
+// DeleteWebhook deletes the webhook with its deliveries, ErrNoData is returned when it does not exist.
+func (d *Database) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1;", id)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// EnqueueDeliveries creates the deliveries of the event to the enabled webhooks of its type, an event received
+// again is not delivered twice. It returns the number of deliveries created.
+func (d *Database) EnqueueDeliveries(ctx context.Context, event *models.OutboxEvent) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id,event_id,event_type,user_id,event_at) SELECT id,$1,$2,$3,$4 FROM webhooks WHERE enabled AND (cardinality(event_types) = 0 OR $2 = ANY(event_types)) ON CONFLICT (webhook_id,event_id) DO NOTHING;", event.ID, event.Type, event.UserID, event.At)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+const deliveryColumns = "d.id,d.webhook_id,d.event_id,d.event_type,d.user_id,d.event_at,d.status,d.attempts,d.last_status_code,d.last_error,d.next_attempt_at,d.created_at,d.updated_at"
+
+func deliveryFields(delivery *models.WebhookDelivery) []any {
+	return []any{&delivery.ID, &delivery.WebhookID, &delivery.Event.ID, &delivery.Event.Type, &delivery.Event.UserID, &delivery.Event.At, &delivery.Status, &delivery.Attempts, &delivery.LastStatusCode, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt}
+}
+
+// ClaimDeliveries returns up to limit due deliveries of the enabled webhooks, they are not claimed again before
+// the lease expires so a delivery interrupted by a crash is attempted again.
+func (d *Database) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.DeliveryAttempt, error) {
+	rows, err := d.db.QueryContext(ctx, "UPDATE webhook_deliveries d SET next_attempt_at = now() + $2::bigint * interval '1 millisecond', updated_at = now() FROM webhooks w WHERE w.id = d.webhook_id AND d.id IN (SELECT due.id FROM webhook_deliveries due JOIN webhooks dw ON dw.id = due.webhook_id WHERE due.status = 'pending' AND due.next_attempt_at <= now() AND dw.enabled ORDER BY due.next_attempt_at LIMIT $1 FOR UPDATE OF due SKIP LOCKED) RETURNING "+deliveryColumns+",w.url,w.wrapped_secret;", limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var attempts []*models.DeliveryAttempt
+	for rows.Next() {
+		attempt := new(models.DeliveryAttempt)
+		err := rows.Scan(append(deliveryFields(&attempt.WebhookDelivery), &attempt.URL, &attempt.WrappedSecret)...)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// RecordDeliveryAttempt stores the result of the attempt. A failure counts towards disabling the webhook, it is
+// disabled once it has failed disableAfter times in a row, true is then returned. A success resets the count.
+func (d *Database) RecordDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt, result *models.DeliveryResult, disableAfter int) (bool, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status := models.DeliveryFailed
+	switch {
+	case result.Delivered:
+		status = models.DeliveryDelivered
+	case result.RetryAt.Valid:
+		status = models.DeliveryPending
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = $4, next_attempt_at = $5, updated_at = now() WHERE id = $1;", attempt.ID, status, result.StatusCode, result.Error, result.RetryAt)
+	if err != nil {
+		return false, err
+	}
+
+	disabled := false
+	if result.Delivered {
+		_, err = tx.ExecContext(ctx, "UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0;", attempt.WebhookID)
+	} else {
+		err = tx.QueryRowContext(ctx, "UPDATE webhooks SET consecutive_failures = consecutive_failures + 1, enabled = enabled AND consecutive_failures + 1 < $2, disabled_at = CASE WHEN enabled AND consecutive_failures + 1 >= $2 THEN now() ELSE disabled_at END WHERE id = $1 RETURNING disabled_at IS NOT NULL AND consecutive_failures = $2;", attempt.WebhookID, disableAfter).Scan(&disabled)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the webhook has been deleted meanwhile.
+			err = nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return disabled, tx.Commit()
+}
+
+// ListDeliveries returns the last deliveries of the webhook, most recent first.
+func (d *Database) ListDeliveries(ctx context.Context, webhookID int64, limit int64) ([]*models.WebhookDelivery, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries d WHERE d.webhook_id = $1 ORDER BY d.id DESC LIMIT $2;", webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := new(models.WebhookDelivery)
+		err := rows.Scan(deliveryFields(delivery)...)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,131 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
+)
+
+var ErrNotConfirmed = errors.New("message was not confirmed by the broker")
+
+// Exchange publishes persistent messages to a durable topic exchange, every message is confirmed by the broker
+// so a message is known to be stored once Publish returns.
+type Exchange struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	name    string
+	// mu keeps the messages in the order of the Publish calls.
+	mu sync.Mutex
+}
+
+func NewExchange(uri string, name string) (*Exchange, error) {
+	conn, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	err = ch.ExchangeDeclare(name, amqp.ExchangeTopic, true, false, false, false, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &Exchange{conn: conn, channel: ch, name: name}, nil
+}
+
+func (e *Exchange) Close() error {
+	return e.conn.Close()
+}
+
+// Closed returns a channel receiving the error which closed the connection or the publishing channel, e.g. when the
+// broker restarts. The Exchange doesn't reconnect: its channels and the consumed queues are gone, the process has to
+// stop and be restarted with new ones. Closing the Exchange sends amqp.ErrClosed.
+func (e *Exchange) Closed() <-chan error {
+	connClosed := e.conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := e.channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	closed := make(chan error, 1)
+	go func() {
+		var err *amqp.Error
+		select {
+		case err = <-connClosed:
+		case err = <-channelClosed:
+		}
+
+		if err == nil {
+			closed <- amqp.ErrClosed
+			return
+		}
+		closed <- err
+	}()
+	return closed
+}
+
+// Publish sends the message with the routing key and waits for the broker to confirm it, the message id lets the
+// consumers drop the messages delivered twice.
+func (e *Exchange) Publish(ctx context.Context, routingKey string, messageID string, data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	confirmation, err := e.channel.PublishWithDeferredConfirmWithContext(ctx, e.name, routingKey, false, false, amqp.Publishing{
+		MessageId:    messageID,
+		Body:         data,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+	})
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNotConfirmed
+	}
+	return nil
+}
+
+// Consume binds the durable queue to the exchange with the binding key, e.g. user.#, and returns its messages.
+// Every message has to be acknowledged, the messages not acknowledged are delivered again. Up to prefetch
+// messages are delivered before being acknowledged.
+func (e *Exchange) Consume(queueName string, bindingKey string, prefetch int) (<-chan amqp.Delivery, error) {
+	ch, err := e.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	err = ch.QueueBind(q.Name, bindingKey, e.name, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	err = ch.Qos(prefetch, 0, false)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	return ch.Consume(q.Name, "", false, false, false, false, nil)
+}
@@ -0,0 +1,20 @@
+package mockrabbitmq
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockExchange struct {
+	mock.Mock
+}
+
+func (m *MockExchange) Publish(ctx context.Context, routingKey string, messageID string, data []byte) error {
+	args := m.Called(ctx, routingKey, messageID, data)
+	return args.Error(0)
+}
+
+func (m *MockExchange) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
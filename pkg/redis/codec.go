@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/viswals_task/core/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var ErrUnknownEncoding = errors.New("unknown cache entry encoding")
+
+const (
+	// jsonMarker is the first byte of every JSON entry, JSON entries have no marker of their own so
+	// the entries written before codecs existed are still read.
+	jsonMarker    = '{'
+	msgpackMarker = 0x01
+)
+
+// Codec encodes the cached users, the first byte of every entry is the marker of its codec so entries of
+// every codec can be read whatever the codec used for writing.
+type Codec interface {
+	Name() string
+	Marker() byte
+	// Marshal returns the entry, including the marker.
+	Marshal(user *models.UserDetails) ([]byte, error)
+	// Unmarshal decodes an entry starting with the marker.
+	Unmarshal(data []byte, user *models.UserDetails) error
+}
+
+// codecs are the codecs entries can be read with, by marker.
+var codecs = map[byte]Codec{
+	jsonMarker:    JSONCodec{},
+	msgpackMarker: MsgpackCodec{},
+}
+
+// CodecByName returns the codec named json or msgpack.
+func CodecByName(name string) (Codec, error) {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown codec %q", ErrInvalidConfig, name)
+}
+
+// decode reads the entry with the codec of its marker.
+func decode(data []byte, user *models.UserDetails) error {
+	if len(data) == 0 {
+		return ErrUnknownEncoding
+	}
+
+	codec, ok := codecs[data[0]]
+	if !ok {
+		return fmt.Errorf("%w: marker %#x", ErrUnknownEncoding, data[0])
+	}
+	return codec.Unmarshal(data, user)
+}
+
+// JSONCodec is the default codec, it is readable by the instances which don't know codecs.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Marker() byte { return jsonMarker }
+
+func (JSONCodec) Marshal(user *models.UserDetails) ([]byte, error) {
+	return json.Marshal(user)
+}
+
+func (JSONCodec) Unmarshal(data []byte, user *models.UserDetails) error {
+	return json.Unmarshal(data, user)
+}
+
+// MsgpackCodec encodes users as MessagePack arrays, fields are identified by their position so entries
+// must be invalidated with redis.SchemaVersion when the fields of models.UserDetails change.
+// Fields excluded from JSON are excluded as well.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+func (MsgpackCodec) Marker() byte { return msgpackMarker }
+
+func (MsgpackCodec) Marshal(user *models.UserDetails) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(msgpackMarker)
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseArrayEncodedStructs(true)
+	enc.UseCompactInts(true)
+
+	err := enc.Encode(user)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, user *models.UserDetails) error {
+	if len(data) == 0 || data[0] != msgpackMarker {
+		return ErrUnknownEncoding
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data[1:]))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(user)
+}
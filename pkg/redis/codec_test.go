@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals_task/core/models"
+)
+
+// typicalUser looks like a cached user, its personal data is encrypted with its user key.
+func typicalUser() *models.UserDetails {
+	return &models.UserDetails{
+		ID:           1234567,
+		FirstName:    "v4:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIj",
+		LastName:     "v4:JCUmJygpKissLS4vMDEyMzQ1Njc4OTo7PD0+P0BBQkNERUZH",
+		EmailAddress: "v4:SElKS0xNTk9QUVJTVFVWV1hZWltcXV5fYGFiY2RlZmdoaWprbG1ub3BxcnN0",
+		CreatedAt:    models.NullTime{Time: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), Valid: true},
+		ParentUserId: 1234000,
+		EmailIndex:   "not cached",
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	expected := typicalUser()
+	expected.EmailIndex = ""
+
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		t.Run("Success: round trip with "+codec.Name(), func(t *testing.T) {
+			data, err := codec.Marshal(typicalUser())
+			assert.NoError(t, err)
+			assert.Equal(t, codec.Marker(), data[0])
+
+			user := new(models.UserDetails)
+			assert.NoError(t, decode(data, user))
+			assert.Equal(t, expected.CreatedAt.Time.UnixNano(), user.CreatedAt.Time.UnixNano())
+			user.CreatedAt.Time = expected.CreatedAt.Time
+			assert.Equal(t, expected, user)
+		})
+	}
+
+	t.Run("Success: entries written before codecs", func(t *testing.T) {
+		user := new(models.UserDetails)
+		assert.NoError(t, decode([]byte(`{"id":1,"first_name":"v2:a"}`), user))
+		assert.Equal(t, &models.UserDetails{ID: 1, FirstName: "v2:a"}, user)
+	})
+
+	t.Run("Fail: unknown marker", func(t *testing.T) {
+		assert.ErrorIs(t, decode([]byte{0x7f, 1}, new(models.UserDetails)), ErrUnknownEncoding)
+		assert.ErrorIs(t, decode(nil, new(models.UserDetails)), ErrUnknownEncoding)
+	})
+
+	t.Run("Success: codec by name", func(t *testing.T) {
+		codec, err := CodecByName("msgpack")
+		assert.NoError(t, err)
+		assert.Equal(t, MsgpackCodec{}, codec)
+
+		_, err = CodecByName("xml")
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	})
+}
+
+// go test ./pkg/redis -bench Codec -benchmem reports the size of the entries as bytes/entry.
+func BenchmarkCodecMarshal(b *testing.B) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		b.Run(codec.Name(), func(b *testing.B) {
+			user := typicalUser()
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				data, err = codec.Marshal(user)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes/entry")
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		b.Run(codec.Name(), func(b *testing.B) {
+			data, err := codec.Marshal(typicalUser())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := decode(data, new(models.UserDetails))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/viswals_task/core/models"
+	"strconv"
+	"time"
+)
+
+// the user events are kept in the stream <prefix>:events, its entry ids are the sequence numbers of the events.
+// Every instance reads the stream, so the events written by one instance are sent to the clients of all of them.
+
+const (
+	// eventsKey is the stream of the user events, it is not namespaced with the schema version.
+	eventsKey = "events"
+	// firstEventSeq is before every event.
+	firstEventSeq = "0-0"
+)
+
+// AppendEvent adds the event to the stream and returns its sequence number, the oldest events are trimmed
+// so about EventsMaxLen events are kept.
+func (r *Redis) AppendEvent(ctx context.Context, eventType models.EventType, userID int64) (string, error) {
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.prefix + eventsKey,
+		MaxLen: r.eventsMaxLen,
+		Approx: true,
+		Values: []any{"type", string(eventType), "user_id", userID},
+	}).Result()
+}
+
+// LastEventSeq returns the sequence number of the last event, reading after it only returns the new events.
+func (r *Redis) LastEventSeq(ctx context.Context) (string, error) {
+	messages, err := r.client.XRevRangeN(ctx, r.prefix+eventsKey, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return firstEventSeq, nil
+	}
+	return messages[0].ID, nil
+}
+
+// ReadEvents returns up to count events after the sequence number, it waits up to block for an event
+// when there is none, an empty list is returned if none was added meanwhile. It doesn't wait when block is 0.
+func (r *Redis) ReadEvents(ctx context.Context, after string, count int64, block time.Duration) ([]*models.UserEvent, error) {
+	// BLOCK 0 waits forever in redis, a negative block is not sent.
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{r.prefix + eventsKey, after},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*models.UserEvent
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			event, err := toEvent(message)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func toEvent(message redis.XMessage) (*models.UserEvent, error) {
+	at, err := models.ParseEventSeq(message.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType, _ := message.Values["type"].(string)
+	userID, err := strconv.ParseInt(fmt.Sprint(message.Values["user_id"]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id of event %s: %w", message.ID, err)
+	}
+
+	return &models.UserEvent{
+		Seq:    message.ID,
+		Type:   models.EventType(eventType),
+		UserID: userID,
+		At:     at,
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/stretchr/testify/mock"
 	"github.com/viswals_task/core/models"
+	"time"
 )
 
 type MockRedis struct {
@@ -15,12 +16,52 @@ func (m *MockRedis) Get(ctx context.Context, key string) (*models.UserDetails, e
 	return args.Get(0).(*models.UserDetails), args.Error(1)
 }
 
+func (m *MockRedis) GetMany(ctx context.Context, keys []string) (map[string]*models.UserDetails, error) {
+	args := m.Called(ctx, keys)
+	return args.Get(0).(map[string]*models.UserDetails), args.Error(1)
+}
+
 func (m *MockRedis) Set(ctx context.Context, key string, data *models.UserDetails) error {
 	args := m.Called(ctx, key, data)
 	return args.Error(0)
 }
 
+func (m *MockRedis) SetMissing(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
 func (m *MockRedis) Delete(ctx context.Context, key string) error {
 	args := m.Called(ctx, key)
 	return args.Error(0)
 }
+
+func (m *MockRedis) TopReads(ctx context.Context, n int64) ([]string, error) {
+	args := m.Called(ctx, n)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRedis) TrimReads(ctx context.Context, keep int64) error {
+	args := m.Called(ctx, keep)
+	return args.Error(0)
+}
+
+func (m *MockRedis) SampleKeys(ctx context.Context, n int) ([]string, error) {
+	args := m.Called(ctx, n)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRedis) AppendEvent(ctx context.Context, eventType models.EventType, userID int64) (string, error) {
+	args := m.Called(ctx, eventType, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRedis) LastEventSeq(ctx context.Context) (string, error) {
+	args := m.Called(ctx)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRedis) ReadEvents(ctx context.Context, after string, count int64, block time.Duration) ([]*models.UserEvent, error) {
+	args := m.Called(ctx, after, count, block)
+	return args.Get(0).([]*models.UserEvent), args.Error(1)
+}
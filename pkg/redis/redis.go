@@ -2,50 +2,161 @@ package redis
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	redis "github.com/redis/go-redis/v9"
 	"github.com/viswals_task/core/models"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// in redis for suitability, data is stored as a key:value where value is in JSON format.
+// in redis for suitability, data is stored as a key:value where value is encoded with the Codec, JSON by default.
+// Keys are namespaced as <prefix>:v<schema version>:<key>, e.g. users:v2:42.
+
+var (
+	ErrNotFound = errors.New("key does not exist in cache")
+	// ErrMissing is returned for keys cached as missing with SetMissing.
+	ErrMissing       = errors.New("key is cached as missing")
+	ErrInvalidConfig = errors.New("invalid redis configuration")
+)
+
+const (
+	// missingValue is the value of the keys cached as missing, it can't be confused with a JSON object.
+	missingValue = "-"
+	// readsKey is the sorted set of the number of reads per key, see IncrementReads.
+	readsKey = "reads"
+	// scanCount is the number of keys examined per SCAN call of DeleteByPrefix.
+	scanCount = 1000
+)
+
+const (
+	DefaultKeyPrefix    = "users"
+	DefaultEventsMaxLen = 100000
+	// SchemaVersion is the version of the cached models.UserDetails, increment it when the model changes so
+	// the entries of the previous version are not read anymore, they expire with their TTL.
+	SchemaVersion = 2
+)
+
+type Config struct {
+	TTL time.Duration
+	// NegativeTTL is the TTL of the keys cached as missing.
+	NegativeTTL time.Duration
+	// TTLJitter spreads the expiries of keys written together, every TTL is randomly changed by up to
+	// the fraction, e.g. 0.1 for ±10%.
+	TTLJitter float64
+	// KeyPrefix separates the keys from the other users of the database, defaults to DefaultKeyPrefix.
+	KeyPrefix string
+	// SchemaVersion defaults to SchemaVersion.
+	SchemaVersion int
+	// Codec encodes the users written, defaults to JSONCodec. Entries of every codec are read.
+	Codec Codec
+	// EventsMaxLen is about the number of user events kept for the clients resuming the change feed,
+	// defaults to DefaultEventsMaxLen.
+	EventsMaxLen int64
+}
 
 type Redis struct {
-	client *redis.Client
-	ttl    time.Duration
+	client       redis.UniversalClient
+	ttl          time.Duration
+	negativeTTL  time.Duration
+	jitter       float64
+	codec        Codec
+	eventsMaxLen int64
+	// prefix is shared by every schema version, namespace is the prefix of the keys of the current version.
+	prefix    string
+	namespace string
 }
 
-func New(connectionString string, ttl time.Duration) (*Redis, error) {
-	conf, err := redis.ParseURL(connectionString)
+func New(conn Connection, config Config) (*Redis, error) {
+	if config.TTLJitter < 0 || config.TTLJitter >= 1 {
+		return nil, fmt.Errorf("%w: TTL jitter %v must be in [0, 1)", ErrInvalidConfig, config.TTLJitter)
+	}
+
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = DefaultKeyPrefix
+	}
+
+	if config.SchemaVersion == 0 {
+		config.SchemaVersion = SchemaVersion
+	}
+
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+
+	if config.EventsMaxLen < 0 {
+		return nil, fmt.Errorf("%w: events max length %d must not be negative", ErrInvalidConfig, config.EventsMaxLen)
+	}
+
+	if config.EventsMaxLen == 0 {
+		config.EventsMaxLen = DefaultEventsMaxLen
+	}
+
+	client, err := conn.client()
 	if err != nil {
 		return nil, err
 	}
 
-	client := redis.NewClient(conf)
 	status := client.Ping(context.Background())
 
 	if status.Err() != nil {
+		_ = client.Close()
 		return nil, status.Err()
 	}
 
-	return &Redis{client: client, ttl: ttl}, nil
+	return &Redis{
+		client:       client,
+		ttl:          config.TTL,
+		negativeTTL:  config.NegativeTTL,
+		jitter:       config.TTLJitter,
+		codec:        config.Codec,
+		eventsMaxLen: config.EventsMaxLen,
+		prefix:       config.KeyPrefix + ":",
+		namespace:    fmt.Sprintf("%s:v%d:", config.KeyPrefix, config.SchemaVersion),
+	}, nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+func (r *Redis) key(key string) string {
+	return r.namespace + key
 }
 
+// expiration returns the ttl changed by up to the jitter.
+func (r *Redis) expiration(ttl time.Duration) time.Duration {
+	if r.jitter == 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration((rand.Float64()*2-1)*r.jitter*float64(ttl))
+}
+
+// Get returns ErrNotFound on a cache miss and ErrMissing for the keys cached as missing.
 func (r *Redis) Get(ctx context.Context, key string) (*models.UserDetails, error) {
-	out := r.client.Get(ctx, key)
+	out := r.client.Get(ctx, r.key(key))
 
 	if out.Err() != nil {
+		if errors.Is(out.Err(), redis.Nil) {
+			return nil, ErrNotFound
+		}
 		return nil, out.Err()
 	}
 
-	res, err := out.Result()
+	res, err := out.Bytes()
 	if err != nil {
 		return nil, err
 	}
 
+	if string(res) == missingValue {
+		return nil, ErrMissing
+	}
+
 	var userDetails = new(models.UserDetails)
 
-	err = json.Unmarshal([]byte(res), userDetails)
+	err = decode(res, userDetails)
 	if err != nil {
 		return nil, err
 	}
@@ -53,13 +164,79 @@ func (r *Redis) Get(ctx context.Context, key string) (*models.UserDetails, error
 	return userDetails, nil
 }
 
+// GetMany returns the cached users by key with a single MGET, the keys cached as missing map to nil and the keys not
+// cached are absent. On a cluster the keys belong to different slots, they are read with a pipeline of GET instead.
+func (r *Redis) GetMany(ctx context.Context, keys []string) (map[string]*models.UserDetails, error) {
+	users := make(map[string]*models.UserDetails, len(keys))
+	if len(keys) == 0 {
+		return users, nil
+	}
+
+	namespaced := make([]string, 0, len(keys))
+	for _, key := range keys {
+		namespaced = append(namespaced, r.key(key))
+	}
+
+	values, err := r.getValues(ctx, namespaced)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if data == missingValue {
+			users[keys[i]] = nil
+			continue
+		}
+
+		user := new(models.UserDetails)
+		// an entry which can't be decoded is a cache miss, the user is read from the database again.
+		if decode([]byte(data), user) != nil {
+			continue
+		}
+		users[keys[i]] = user
+	}
+
+	return users, nil
+}
+
+// getValues returns the values of the keys like MGET, nil for the keys which do not exist.
+func (r *Redis) getValues(ctx context.Context, keys []string) ([]interface{}, error) {
+	if _, ok := r.client.(*redis.ClusterClient); !ok {
+		return r.client.MGet(ctx, keys...).Result()
+	}
+
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(cmds))
+	for i, cmd := range cmds {
+		value, err := cmd.(*redis.StringCmd).Result()
+		if err == nil {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
 func (r *Redis) Set(ctx context.Context, key string, userDetails *models.UserDetails) error {
-	b, err := json.Marshal(userDetails)
+	b, err := r.codec.Marshal(userDetails)
 	if err != nil {
 		return err
 	}
 
-	out := r.client.Set(ctx, key, string(b), r.ttl)
+	out := r.client.Set(ctx, r.key(key), b, r.expiration(r.ttl))
 	if out.Err() != nil {
 		return out.Err()
 	}
@@ -67,6 +244,11 @@ func (r *Redis) Set(ctx context.Context, key string, userDetails *models.UserDet
 	return nil
 }
 
+// SetMissing caches the key as missing for the negative TTL, a Set of the key replaces it.
+func (r *Redis) SetMissing(ctx context.Context, key string) error {
+	return r.client.Set(ctx, r.key(key), missingValue, r.expiration(r.negativeTTL)).Err()
+}
+
 //func (r *Redis) SetBulk(ctx context.Context, userDetails []*models.UserDetails) error {
 //	var combinedErr error
 //	for _, userDetail := range userDetails {
@@ -79,9 +261,174 @@ func (r *Redis) Set(ctx context.Context, key string, userDetails *models.UserDet
 //}
 
 func (r *Redis) Delete(ctx context.Context, key string) error {
-	out := r.client.Del(ctx, key)
+	out := r.client.Del(ctx, r.key(key))
 	if out.Err() != nil {
 		return out.Err()
 	}
 	return nil
 }
+
+// IncrementReads adds the counts to the number of reads of the keys.
+func (r *Redis) IncrementReads(ctx context.Context, counts map[string]int64) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, count := range counts {
+			pipe.ZIncrBy(ctx, r.prefix+readsKey, float64(count), key)
+		}
+		return nil
+	})
+	return err
+}
+
+// TopReads returns at most n keys, the most read first.
+func (r *Redis) TopReads(ctx context.Context, n int64) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return r.client.ZRevRange(ctx, r.prefix+readsKey, 0, n-1).Result()
+}
+
+// TrimReads forgets the read counts of all but the keep most read keys.
+func (r *Redis) TrimReads(ctx context.Context, keep int64) error {
+	return r.client.ZRemRangeByRank(ctx, r.prefix+readsKey, 0, -keep-1).Err()
+}
+
+// SampleKeys returns up to n random keys of the current schema version without their namespace, a key can't be
+// returned twice. Fewer keys are returned when the database is small or shared with other keys.
+func (r *Redis) SampleKeys(ctx context.Context, n int) ([]string, error) {
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < n; i++ {
+			pipe.RandomKey(ctx)
+		}
+		return nil
+	})
+	// RandomKey returns redis.Nil on an empty database.
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, n)
+	keys := make([]string, 0, n)
+	for _, cmd := range cmds {
+		key, err := cmd.(*redis.StringCmd).Result()
+		if err != nil {
+			continue
+		}
+
+		key, ok := strings.CutPrefix(key, r.namespace)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// DeleteByPrefix deletes the keys of the current schema version starting with the prefix, an empty prefix deletes
+// all of them. Keys are found with SCAN so redis is not blocked, keys written meanwhile might not be deleted.
+// On a cluster every master is scanned.
+func (r *Redis) DeleteByPrefix(ctx context.Context, prefix string) (int64, error) {
+	match := escapePattern(r.key(prefix)) + "*"
+
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return deleteMatching(ctx, r.client, match)
+	}
+
+	var deleted atomic.Int64
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := deleteMatching(ctx, node, match)
+		deleted.Add(n)
+		return err
+	})
+	return deleted.Load(), err
+}
+
+func deleteMatching(ctx context.Context, client redis.Cmdable, match string) (int64, error) {
+	var deleted int64
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		// keys are unlinked one by one, the keys of a cluster node can belong to different slots.
+		cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Unlink(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		for _, cmd := range cmds {
+			deleted += cmd.(*redis.IntCmd).Val()
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// escapePattern escapes the glob characters of a SCAN pattern.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune("*?[]\\", c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Publish and Subscribe channels are namespaced with the key prefix, but not with the schema version.
+func (r *Redis) Publish(ctx context.Context, channel, message string) error {
+	return r.client.Publish(ctx, r.prefix+channel, message).Err()
+}
+
+// Subscribe returns the messages published on the channel, the channel is closed when the context is cancelled.
+// The subscription is re-established after a connection failure, messages published meanwhile are lost.
+func (r *Redis) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := r.client.Subscribe(ctx, r.prefix+channel)
+
+	// wait for the confirmation so no message published after Subscribe returns is missed.
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		in := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, nil
+}